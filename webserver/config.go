@@ -6,19 +6,87 @@ import (
 	"path/filepath"
 	"runtime"
 	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/spf13/viper"
 
 	"private-sphinx-docs/libs"
+	"private-sphinx-docs/server"
 	db "private-sphinx-docs/services/database"
+	sf "private-sphinx-docs/services/staticfiles"
 )
 
+// Cache lifetimes of the served documentation. Unset lifetimes are inherited
+type cacheConfig struct {
+	Static *time.Duration `mapstructure:"static"`
+	HTML   *time.Duration `mapstructure:"html"`
+	Other  *time.Duration `mapstructure:"other"`
+}
+
+func (c cacheConfig) apply(policy server.CachePolicy) server.CachePolicy {
+	if c.Static != nil {
+		policy.Static = *c.Static
+	}
+	if c.HTML != nil {
+		policy.HTML = *c.HTML
+	}
+	if c.Other != nil {
+		policy.Other = *c.Other
+	}
+	return policy
+}
+
 type Config struct {
 	App struct {
 		Port      int    `mapstructure:"port"`
 		DocFolder string `mapstructure:"doc_folder"`
-		TLS       struct {
+		Upload    struct {
+			MaxBodySize         int64 `mapstructure:"max_body_size"`
+			MaxTotalSize        int64 `mapstructure:"max_total_size"`
+			MaxEntries          int   `mapstructure:"max_entries"`
+			MaxFileSize         int64 `mapstructure:"max_file_size"`
+			MaxCompressionRatio int64 `mapstructure:"max_compression_ratio"`
+			// time after which unfinished resumable uploads are removed
+			ResumableExpiry time.Duration `mapstructure:"resumable_expiry"`
+		} `mapstructure:"upload"`
+		Storage struct {
+			// "local" stores the documentation in the doc folder, "s3" in an S3 compatible
+			// object store. The doc folder is still used for working files
+			Backend string `mapstructure:"backend"`
+			// serve the uploaded zip archives without extracting them (local backend only)
+			ServeArchives bool `mapstructure:"serve_archives"`
+			// store every distinct file once and save revisions as manifests (local backend only)
+			Deduplicate bool `mapstructure:"deduplicate"`
+			S3          struct {
+				Endpoint  string `mapstructure:"endpoint"`
+				AccessKey string `mapstructure:"access_key"`
+				SecretKey string `mapstructure:"secret_key"`
+				Region    string `mapstructure:"region"`
+				UseSSL    bool   `mapstructure:"use_ssl"`
+				Bucket    string `mapstructure:"bucket"`
+				Prefix    string `mapstructure:"prefix"`
+			} `mapstructure:"s3"`
+		} `mapstructure:"storage"`
+		Cache struct {
+			cacheConfig `mapstructure:",squash"`
+			// overrides of specific projects
+			Projects map[string]cacheConfig `mapstructure:"projects"`
+			// in-memory cache of small files, disabled if max_size is 0. Sizes are in bytes
+			Memory struct {
+				MaxSize     int64 `mapstructure:"max_size"`
+				MaxFileSize int64 `mapstructure:"max_file_size"`
+			} `mapstructure:"memory"`
+		} `mapstructure:"cache"`
+		Search struct {
+			// full text search of the hosted documentation at /api/search
+			Enabled bool `mapstructure:"enabled"`
+			// folder of the index, defaults to .search in the doc folder
+			Folder string `mapstructure:"folder"`
+			// search of the searchindex.js files of the Sphinx builds at /api/search/federated
+			Federated bool `mapstructure:"federated"`
+		} `mapstructure:"search"`
+		TLS struct {
 			CertFile string `mapstructure:"cert_file"`
 			KeyFile  string `mapstructure:"key_file"`
 		} `mapstructure:"tls"`
@@ -46,6 +114,47 @@ func (c *Config) DbOption() *db.DbOption {
 	}
 }
 
+func (c *Config) FileSysOption() *sf.FileSysOption {
+	upload := c.App.Upload
+	return &sf.FileSysOption{
+		Root:          c.App.DocFolder,
+		ServeArchives: c.App.Storage.ServeArchives,
+		Deduplicate:   c.App.Storage.Deduplicate,
+		Limits: sf.Limits{
+			MaxTotalSize:        upload.MaxTotalSize,
+			MaxEntries:          upload.MaxEntries,
+			MaxFileSize:         upload.MaxFileSize,
+			MaxCompressionRatio: upload.MaxCompressionRatio,
+		},
+	}
+}
+
+func (c *Config) ObjectStoreOption() *sf.ObjectStoreOption {
+	s3 := c.App.Storage.S3
+	return &sf.ObjectStoreOption{
+		Endpoint:  s3.Endpoint,
+		AccessKey: s3.AccessKey,
+		SecretKey: s3.SecretKey,
+		Region:    s3.Region,
+		UseSSL:    s3.UseSSL,
+		Bucket:    s3.Bucket,
+		Prefix:    s3.Prefix,
+		WorkDir:   c.App.DocFolder,
+		Limits:    c.FileSysOption().Limits,
+	}
+}
+
+// Gets the default cache policy of the documentation and the policies of specific projects
+func (c *Config) CachePolicies() (server.CachePolicy, map[string]server.CachePolicy) {
+	policy := c.App.Cache.apply(server.DefaultCachePolicy)
+
+	projects := make(map[string]server.CachePolicy, len(c.App.Cache.Projects))
+	for name, project := range c.App.Cache.Projects {
+		projects[strings.ToLower(name)] = project.apply(policy)
+	}
+	return policy, projects
+}
+
 func (c *Config) HasCert() bool {
 	tls := c.App.TLS
 
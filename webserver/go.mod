@@ -3,16 +3,22 @@ module private-sphinx-docs
 go 1.13
 
 require (
+	github.com/andybalholm/brotli v1.0.5
+	github.com/blevesearch/bleve v1.0.14
+	github.com/blevesearch/go-porterstemmer v1.0.3
 	github.com/dhui/dktest v0.3.2
 	github.com/go-chi/chi v4.1.0+incompatible
 	github.com/golang-migrate/migrate/v4 v4.10.0
 	github.com/hashicorp/go-multierror v1.0.0
 	github.com/jmoiron/sqlx v1.2.0
+	github.com/klauspost/compress v1.10.10
 	github.com/lib/pq v1.3.0
+	github.com/minio/minio-go/v6 v6.0.57
 	github.com/otiai10/copy v1.1.1
 	github.com/pkg/errors v0.9.1
-	github.com/sirupsen/logrus v1.4.2
+	github.com/sirupsen/logrus v1.5.0
 	github.com/spf13/viper v1.6.3
 	github.com/stretchr/testify v1.4.0
-	golang.org/x/crypto v0.0.0-20200302210943-78000ba7a073
+	golang.org/x/crypto v0.0.0-20200622213623-75b288015ac9
+	golang.org/x/net v0.0.0-20201021035429-f5854403a974
 )
@@ -2,10 +2,14 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"strings"
 	"syscall"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 
@@ -13,7 +17,9 @@ import (
 
 	"private-sphinx-docs/server"
 	db "private-sphinx-docs/services/database"
+	"private-sphinx-docs/services/search"
 	sf "private-sphinx-docs/services/staticfiles"
+	"private-sphinx-docs/services/uploads"
 )
 
 //go:generate python scripts/create_migration_file.py
@@ -25,11 +31,43 @@ func main() {
 		log.Fatal(err)
 	}
 
-	fh, err := sf.NewFileSys(config.App.DocFolder)
+	fh, err := newFileHandler(config)
 	if err != nil {
 		log.Fatal(err)
 	}
 
+	uploadStore, err := uploads.New(filepath.Join(fh.Source(), ".uploads"), config.App.Upload.ResumableExpiry)
+	if err != nil {
+		log.Fatal(err)
+	}
+	stopCollector := uploadStore.StartCollector(time.Hour)
+	defer stopCollector()
+
+	tasks := []server.MaintenanceTask{{
+		Id:          "collect-uploads",
+		Name:        "Remove expired uploads",
+		Description: "Removes the resumable uploads that were not finished in time",
+		Run: func() (string, error) {
+			n, err := uploadStore.Collect()
+			return fmt.Sprintf("removed %d uploads", n), err
+		},
+	}}
+
+	if blobs, ok := fh.(blobCollector); ok && config.App.Storage.Deduplicate {
+		stopBlobCollector := blobs.StartBlobCollector(time.Hour)
+		defer stopBlobCollector()
+
+		tasks = append(tasks, server.MaintenanceTask{
+			Id:          "collect-blobs",
+			Name:        "Remove unreferenced files",
+			Description: "Removes the deduplicated files no revision refers to anymore",
+			Run: func() (string, error) {
+				n, freed, err := blobs.CollectBlobs()
+				return fmt.Sprintf("removed %d files, freed %d bytes", n, freed), err
+			},
+		})
+	}
+
 	store, err := db.New(config.DbOption())
 	if err != nil {
 		log.Fatal(err)
@@ -43,11 +81,42 @@ func main() {
 		log.Info("Migrated database to latest version")
 	}
 
+	var index server.ISearchIndex
+	if config.App.Search.Enabled {
+		folder := config.App.Search.Folder
+		if folder == "" {
+			folder = filepath.Join(fh.Source(), ".search")
+		}
+		searchIndex, err := search.New(folder)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer func() { _ = searchIndex.Close() }()
+		index = searchIndex
+	}
+	var sphinx server.ISphinxIndex
+	if config.App.Search.Federated {
+		sphinx = search.NewSphinxIndex()
+	}
+	if index != nil || sphinx != nil {
+		go server.IndexProjects(store, fh, index, sphinx)
+	}
+
+	cache, projectCache := config.CachePolicies()
 	srv, err := server.New(server.Option{
 		Version:     version,
 		Port:        config.App.Port,
 		Store:       store,
 		FileHandler: fh,
+		Uploads:     uploadStore,
+
+		MaxUploadSize: config.App.Upload.MaxBodySize,
+		CachePolicy:   cache,
+		ProjectCache:  projectCache,
+		FileCache:     server.NewFileCache(config.App.Cache.Memory.MaxSize, config.App.Cache.Memory.MaxFileSize),
+		Search:        index,
+		Sphinx:        sphinx,
+		Maintenance:   tasks,
 	})
 	if err != nil {
 		log.Fatal(err)
@@ -61,6 +130,25 @@ func main() {
 
 }
 
+// Implemented by file handlers storing deduplicated files
+type blobCollector interface {
+	CollectBlobs() (int, int64, error)
+	StartBlobCollector(interval time.Duration) (stop func())
+}
+
+func newFileHandler(config *Config) (server.IFileHandler, error) {
+	switch backend := strings.ToLower(config.App.Storage.Backend); backend {
+	case "", "local":
+		log.Infof("Storing doc files in folder: %s", config.App.DocFolder)
+		return sf.NewFileSys(config.FileSysOption())
+	case "s3":
+		log.Infof("Storing doc files in bucket: %s", config.App.Storage.S3.Bucket)
+		return sf.NewObjectStore(config.ObjectStoreOption())
+	default:
+		return nil, errors.Errorf("unknown storage backend '%s'", backend)
+	}
+}
+
 func runServer(srv *http.Server, config *Config) {
 	if config.HasCert() {
 		log.Printf("Running server in HTTPS mode at %s", srv.Addr)
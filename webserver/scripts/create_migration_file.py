@@ -62,7 +62,7 @@ func generateMigrationFiles() (string, error) {
 
 def read_migration_content():
     contents = []
-    for script in folder.joinpath('migrations').glob('*.up.sql'):
+    for script in sorted(folder.joinpath('migrations').glob('*.up.sql')):
         name = script.name.split('.')[0]
         with open(script.absolute().as_posix()) as f:
             contents.append(f'"{name}": `{f.read()}`,')
@@ -27,8 +27,8 @@ func (h *AccountHandler) CreateAccount() http.HandlerFunc {
 
 		isAdmin := false
 		// get requester, if there's an error, it just means that requester is not admin user
-		req, err := authenticate(h.DB, r)
-		if err == nil && req != nil && req.IsAdmin {
+		req, _ := authenticate(h.DB, r)
+		if req != nil && req.IsAdmin {
 			// only allow admin to set admin
 			isAdmin = p.IsAdmin
 		}
@@ -45,6 +45,12 @@ func (h *AccountHandler) CreateAccount() http.HandlerFunc {
 			http.Error(w, err.Error(), 400)
 			return
 		}
+		if req == nil {
+			// self registration, the new account is the actor
+			req = account
+		}
+		recordAudit(h.DB, r, req, AuditAccountCreate, account.Username, nil, account)
+
 		// mask password
 		account.Password = ""
 
@@ -77,11 +83,22 @@ func (h *AccountHandler) UpdateAccount() http.HandlerFunc {
 			p.IsAdmin = false
 		}
 
+		before, err := h.DB.FetchAccountById(p.Id)
+		if err != nil {
+			BadRequest(w, err)
+			return
+		}
+		// copy as the store may update the fetched account in place
+		previous := *before
+
+		requester := account
 		account, err = h.DB.UpdateAccount(p.Cast())
 		if err != nil {
 			BadRequest(w, err)
 			return
 		}
+		recordAudit(h.DB, r, requester, AuditAccountUpdate, previous.Username, &previous, account)
+
 		account.Password = ""
 
 		toJson(w, account)
@@ -89,16 +106,6 @@ func (h *AccountHandler) UpdateAccount() http.HandlerFunc {
 }
 
 func (h *AccountHandler) DeleteAccount() http.HandlerFunc {
-	removeProjectFiles := func(projects []*db.Project) error {
-		var err error
-		for _, d := range projects {
-			if e := h.FS.Remove(d.Title); e != nil {
-				err = multierror.Append(err, errors.Wrapf(err, "could not remove project '%s;", d.Title))
-			}
-		}
-		return err
-	}
-
 	return func(w http.ResponseWriter, r *http.Request) {
 		account, err := authenticate(h.DB, r)
 		if err != nil {
@@ -112,33 +119,44 @@ func (h *AccountHandler) DeleteAccount() http.HandlerFunc {
 			return
 		}
 
-		// all validation done, now we get the account
-		account, err = h.DB.FetchAccount(username)
+		deleted, err := deleteAccount(h.DB, h.FS, username)
 		if err != nil {
 			BadRequest(w, err)
 			return
 		}
+		recordAudit(h.DB, r, account, AuditAccountDelete, username, deleted, nil)
 
-		projects, err := h.DB.FetchProjectsByAccount(account.Id)
-		if err != nil {
-			BadRequest(w, err)
-			return
-		}
+		Ok(w, r)
+	}
+}
 
-		err = removeProjectFiles(projects)
-		if err != nil {
-			BadRequest(w, err)
-			return
-		}
+// Deletes the account with the documentation of its projects. Returns the deleted account with
+// its projects
+func deleteAccount(store IStore, fs IFileHandler, username string) (*db.Account, error) {
+	account, err := store.FetchAccount(username)
+	if err != nil {
+		return nil, err
+	}
 
-		err = h.DB.DeleteAccount(username)
-		if err != nil {
-			http.Error(w, err.Error(), 400)
-			return
+	projects, err := store.FetchProjectsByAccount(account.Id)
+	if err != nil {
+		return nil, err
+	}
+	account.Projects = projects
+
+	for _, d := range projects {
+		if e := fs.Remove(d.Title); e != nil {
+			err = multierror.Append(err, errors.Wrapf(e, "could not remove project '%s'", d.Title))
 		}
+	}
+	if err != nil {
+		return nil, err
+	}
 
-		Ok(w, r)
+	if err := store.DeleteAccount(username); err != nil {
+		return nil, err
 	}
+	return account, nil
 }
 
 func (h *AccountHandler) ValidateAccount() http.HandlerFunc {
@@ -138,6 +138,16 @@ func TestAccountHandler_UpdateAccount(t *testing.T) {
 			assert.NoError(resp.Body.Close())
 		}
 	}
+
+	// the API requires the password
+	handler := NewAccountHandler()
+	var buf bytes.Buffer
+	assert.NoError(json.NewEncoder(&buf).Encode(&dto.AccountUpdate{Id: 1, Username: "admin"}))
+	r := NewTestRequest("PUT", "/", &buf, nil)
+	r.SetBasicAuth(admin.Username, admin.Password)
+	w := httptest.NewRecorder()
+	handler.UpdateAccount()(w, r)
+	assert.Equal(http.StatusBadRequest, w.Code)
 }
 
 func TestAccountHandler_DeleteAccount(t *testing.T) {
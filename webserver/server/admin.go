@@ -0,0 +1,501 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/pkg/errors"
+
+	"private-sphinx-docs/server/dto"
+	db "private-sphinx-docs/services/database"
+)
+
+const adminPath = "/admin"
+
+var adminTemplate = newPageTemplate(`
+{{define "title"}}Administration{{end}}
+{{define "content"}}
+{{$csrf := .CSRF}}
+<h1>Administration</h1>
+<p class="muted">Signed in as <strong>{{.Account.Username}}</strong>{{if .Account.IsAdmin}} (admin){{end}}</p>
+{{if .Message}}<p class="message">{{.Message}}</p>{{end}}
+{{if .Error}}<p class="message error">{{.Error}}</p>{{end}}
+
+<h2>Projects</h2>
+<table>
+  <thead><tr><th>Project</th><th>Owner</th><th>Version</th><th>Files</th><th>Size</th><th>Last updated</th><th></th></tr></thead>
+  <tbody>
+  {{range .Projects}}
+    <tr>
+      <td><a href="{{.URL}}">{{.Title}}</a></td>
+      <td>{{.Owner}}</td>
+      <td>{{if .Version}}{{.Version}}{{else}}<span class="muted">-</span>{{end}}</td>
+      <td>{{.Files}}</td>
+      <td>{{bytes .Size}}</td>
+      <td>{{date .LastUpdate}}</td>
+      <td>
+        <form method="post" action="/admin/projects/{{.Title}}/delete" onsubmit="return confirm('Delete {{.Title}} and all of its revisions?')">
+          <input type="hidden" name="csrf_token" value="{{$csrf}}">
+          <button type="submit">Delete</button>
+        </form>
+      </td>
+    </tr>
+  {{else}}
+    <tr><td colspan="7" class="muted">No projects</td></tr>
+  {{end}}
+  </tbody>
+</table>
+
+<h3>Upload documentation</h3>
+<form class="filters" method="post" action="/admin/projects?csrf_token={{.CSRF}}" enctype="multipart/form-data">
+  <input type="text" name="title" placeholder="Project" aria-label="Project" required>
+  <input type="file" name="content" aria-label="Archive" accept=".zip,.tar,.gz,.tgz,.zst" required>
+  <button type="submit">Upload</button>
+</form>
+<p class="muted">A zip, tar, tar.gz or tar.zst archive of the HTML build{{if .MaxUploadSize}}, at most {{bytes .MaxUploadSize}}{{end}}. Uploading to an existing project publishes a new revision.</p>
+
+<h2>Storage</h2>
+<p>{{.Storage.Files}} live files taking {{bytes .Storage.Size}}{{if not .Account.IsAdmin}} in your projects{{end}}.</p>
+
+{{if .Account.IsAdmin}}
+<h2>Accounts</h2>
+<table>
+  <thead><tr><th>Username</th><th>Projects</th><th>Update</th><th></th></tr></thead>
+  <tbody>
+  {{range .Accounts}}
+    <tr>
+      <td>{{.Username}}{{if .IsAdmin}} <span class="muted">(admin)</span>{{end}}</td>
+      <td>{{.Projects}}</td>
+      <td>
+        <form class="inline" method="post" action="/admin/accounts/{{.Username}}/update">
+          <input type="hidden" name="csrf_token" value="{{$csrf}}">
+          <input type="password" name="password" placeholder="New password" aria-label="New password">
+          <label><input type="checkbox" name="is_admin"{{if .IsAdmin}} checked{{end}}> admin</label>
+          <button type="submit">Update</button>
+        </form>
+      </td>
+      <td>
+        <form method="post" action="/admin/accounts/{{.Username}}/delete" onsubmit="return confirm('Delete {{.Username}} and all of its projects?')">
+          <input type="hidden" name="csrf_token" value="{{$csrf}}">
+          <button type="submit">Delete</button>
+        </form>
+      </td>
+    </tr>
+  {{end}}
+  </tbody>
+</table>
+
+<h3>Create account</h3>
+<form class="filters" method="post" action="/admin/accounts">
+  <input type="hidden" name="csrf_token" value="{{.CSRF}}">
+  <input type="text" name="username" placeholder="Username" aria-label="Username" required>
+  <input type="password" name="password" placeholder="Password" aria-label="Password" required>
+  <label><input type="checkbox" name="is_admin"> admin</label>
+  <button type="submit">Create</button>
+</form>
+
+<h2>Maintenance</h2>
+<table>
+  <tbody>
+  {{range .Tasks}}
+    <tr>
+      <td><strong>{{.Name}}</strong><br><span class="muted">{{.Description}}</span></td>
+      <td>
+        <form method="post" action="/admin/maintenance/{{.Id}}">
+          <input type="hidden" name="csrf_token" value="{{$csrf}}">
+          <button type="submit">Run</button>
+        </form>
+      </td>
+    </tr>
+  {{else}}
+    <tr><td class="muted">No maintenance tasks</td></tr>
+  {{end}}
+  </tbody>
+</table>
+{{else}}
+<h2>Account</h2>
+<form class="filters" method="post" action="/admin/accounts/{{.Account.Username}}/update">
+  <input type="hidden" name="csrf_token" value="{{.CSRF}}">
+  <input type="password" name="password" placeholder="New password" aria-label="New password" required>
+  <button type="submit">Change password</button>
+</form>
+{{end}}
+{{end}}
+`)
+
+// A task admins can run from the dashboard. Run returns a summary of the work done
+type MaintenanceTask struct {
+	Id          string
+	Name        string
+	Description string
+	Run         func() (string, error)
+}
+
+// Server rendered dashboard to manage accounts and projects from a browser. Admins manage every
+// account and project and run the maintenance tasks, other accounts manage their own projects.
+// Every form is protected with a CSRF token
+type AdminHandler struct {
+	DB IStore
+	FS IFileHandler
+	// Maximum size of an uploaded artifact in bytes. 0 means there is no limit
+	MaxUploadSize int64
+	Tasks         []MaintenanceTask
+}
+
+type adminPage struct {
+	Account       *db.Account
+	CSRF          string
+	Message       string
+	Error         string
+	Projects      []*adminProject
+	Accounts      []*adminAccount
+	Storage       storageUsage
+	Tasks         []MaintenanceTask
+	MaxUploadSize int64
+}
+
+type adminProject struct {
+	Title      string
+	Owner      string
+	URL        string
+	LastUpdate time.Time
+	// Version, number of files and size of the live documentation
+	Version string
+	Files   int
+	Size    int64
+}
+
+type adminAccount struct {
+	Username string
+	IsAdmin  bool
+	Projects int
+}
+
+type storageUsage struct {
+	Files int
+	Size  int64
+}
+
+func (h *AdminHandler) Routes() http.Handler {
+	r := chi.NewRouter()
+	r.Use(CSRFProtect)
+
+	r.Get("/", h.Dashboard())
+	r.Post("/projects", h.UploadProject())
+	r.Post("/projects/{title}/delete", h.DeleteProject())
+	r.Post("/accounts", h.CreateAccount())
+	r.Post("/accounts/{username}/update", h.UpdateAccount())
+	r.Post("/accounts/{username}/delete", h.DeleteAccount())
+	r.Post("/maintenance/{id}", h.RunTask())
+	return r
+}
+
+func (h *AdminHandler) Dashboard() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		account, ok := h.authenticate(w, r)
+		if !ok {
+			return
+		}
+
+		page, err := h.page(r, account)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		page.CSRF = csrfToken(w, r, adminPath)
+		renderHTML(w, adminTemplate, page)
+	}
+}
+
+func (h *AdminHandler) page(r *http.Request, account *db.Account) (*adminPage, error) {
+	page := &adminPage{
+		Account:       account,
+		Message:       r.URL.Query().Get("message"),
+		Error:         r.URL.Query().Get("error"),
+		MaxUploadSize: h.MaxUploadSize,
+	}
+
+	var projects []*db.Project
+	var err error
+	if account.IsAdmin {
+		projects, err = h.DB.FetchProjects()
+	} else {
+		projects, err = h.DB.FetchProjectsByAccount(account.Id)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	accounts, err := h.DB.FetchAccounts()
+	if err != nil {
+		return nil, err
+	}
+	usernames := make(map[int]string, len(accounts))
+	owned := make(map[int]int, len(accounts))
+	for _, acc := range accounts {
+		usernames[acc.Id] = acc.Username
+	}
+
+	ids := make([]int, len(projects))
+	for i, p := range projects {
+		ids[i] = p.Id
+	}
+	live, err := h.DB.FetchLiveRevisions(ids)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, p := range projects {
+		project := &adminProject{
+			Title:      p.Title,
+			Owner:      usernames[p.AccountId],
+			URL:        projectURL(r, p.Title, ""),
+			LastUpdate: p.LastUpdate,
+		}
+		if rev, ok := live[p.Id]; ok {
+			project.Version = rev.Version
+			project.Files = rev.FileCount
+			project.Size = rev.TotalSize
+		}
+		page.Projects = append(page.Projects, project)
+		page.Storage.Files += project.Files
+		page.Storage.Size += project.Size
+		owned[p.AccountId]++
+	}
+	sort.Slice(page.Projects, func(i, j int) bool {
+		return strings.ToLower(page.Projects[i].Title) < strings.ToLower(page.Projects[j].Title)
+	})
+
+	if account.IsAdmin {
+		for _, acc := range accounts {
+			page.Accounts = append(page.Accounts, &adminAccount{Username: acc.Username, IsAdmin: acc.IsAdmin, Projects: owned[acc.Id]})
+		}
+		sort.Slice(page.Accounts, func(i, j int) bool { return page.Accounts[i].Username < page.Accounts[j].Username })
+		page.Tasks = h.Tasks
+	}
+	return page, nil
+}
+
+// Publishes the uploaded archive as a new revision of the project
+func (h *AdminHandler) UploadProject() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		account, ok := h.authenticate(w, r)
+		if !ok {
+			return
+		}
+
+		projects := h.projects()
+		if projects.tooLarge(r.ContentLength) {
+			redirectAdmin(w, r, "", errors.New(projects.tooLargeMessage()))
+			return
+		}
+		if h.MaxUploadSize > 0 {
+			r.Body = http.MaxBytesReader(w, r.Body, h.MaxUploadSize)
+		}
+		if err := r.ParseMultipartForm(10 << 20); bodyTooLarge(err) {
+			redirectAdmin(w, r, "", errors.New(projects.tooLargeMessage()))
+			return
+		} else if err != nil {
+			redirectAdmin(w, r, "", errors.Wrap(err, "could not read upload"))
+			return
+		}
+
+		title := strings.TrimSpace(r.PostFormValue("title"))
+		if err := projects.canManageProject(account, title); err != nil {
+			redirectAdmin(w, r, "", err)
+			return
+		}
+
+		file, header, err := r.FormFile("content")
+		if err != nil {
+			redirectAdmin(w, r, "", errors.Wrap(err, "error retrieving file"))
+			return
+		}
+		defer func() { _ = file.Close() }()
+
+		metadata := dto.NewProjectMetadata(r.MultipartForm.Value)
+		if err := metadata.Validate(); err != nil {
+			redirectAdmin(w, r, "", err)
+			return
+		}
+
+		if _, err := projects.publish(r, account, title, metadata, file, header.Size); err != nil {
+			redirectAdmin(w, r, "", err)
+			return
+		}
+		redirectAdmin(w, r, fmt.Sprintf("Published %s", title), nil)
+	}
+}
+
+func (h *AdminHandler) DeleteProject() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		account, ok := h.authenticate(w, r)
+		if !ok {
+			return
+		}
+
+		title := chi.URLParam(r, "title")
+		projects := h.projects()
+		if err := projects.canManageProject(account, title); err != nil {
+			redirectAdmin(w, r, "", err)
+			return
+		}
+
+		project, err := projects.deleteProject(title)
+		if err != nil {
+			redirectAdmin(w, r, "", err)
+			return
+		}
+		recordAudit(h.DB, r, account, AuditProjectDelete, title, project, nil)
+		redirectAdmin(w, r, fmt.Sprintf("Deleted %s", title), nil)
+	}
+}
+
+func (h *AdminHandler) CreateAccount() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		account, ok := h.authenticate(w, r)
+		if !ok {
+			return
+		}
+		if !account.IsAdmin {
+			Forbid(w, r)
+			return
+		}
+
+		created, err := h.DB.CreateAccount(strings.TrimSpace(r.PostFormValue("username")), r.PostFormValue("password"), r.PostFormValue("is_admin") != "")
+		if err != nil {
+			redirectAdmin(w, r, "", err)
+			return
+		}
+		recordAudit(h.DB, r, account, AuditAccountCreate, created.Username, nil, created)
+		redirectAdmin(w, r, fmt.Sprintf("Created account %s", created.Username), nil)
+	}
+}
+
+// Changes the password of the account, the password is kept if none is given. Admins can also
+// change the admin rights
+func (h *AdminHandler) UpdateAccount() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		account, ok := h.authenticate(w, r)
+		if !ok {
+			return
+		}
+
+		username := chi.URLParam(r, "username")
+		if !(account.IsAdmin || account.Username == username) {
+			Forbid(w, r)
+			return
+		}
+
+		before, err := h.DB.FetchAccount(username)
+		if err != nil {
+			redirectAdmin(w, r, "", err)
+			return
+		}
+		previous := *before
+
+		isAdmin := previous.IsAdmin
+		if account.IsAdmin {
+			isAdmin = r.PostFormValue("is_admin") != ""
+		}
+		updated := &db.Account{Id: previous.Id, Username: previous.Username, IsAdmin: isAdmin}
+		if password := strings.TrimSpace(r.PostFormValue("password")); password != "" {
+			updated.Password = password
+			updated, err = h.DB.UpdateAccount(updated)
+		} else {
+			// the form was sent without a password, only the admin rights change
+			err = h.DB.SetAccountAdmin(previous.Id, isAdmin)
+		}
+		if err != nil {
+			redirectAdmin(w, r, "", err)
+			return
+		}
+		recordAudit(h.DB, r, account, AuditAccountUpdate, username, &previous, updated)
+		redirectAdmin(w, r, fmt.Sprintf("Updated account %s", username), nil)
+	}
+}
+
+func (h *AdminHandler) DeleteAccount() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		account, ok := h.authenticate(w, r)
+		if !ok {
+			return
+		}
+
+		username := chi.URLParam(r, "username")
+		if !(account.IsAdmin || account.Username == username) {
+			Forbid(w, r)
+			return
+		}
+
+		deleted, err := deleteAccount(h.DB, h.FS, username)
+		if err != nil {
+			redirectAdmin(w, r, "", err)
+			return
+		}
+		recordAudit(h.DB, r, account, AuditAccountDelete, username, deleted, nil)
+		redirectAdmin(w, r, fmt.Sprintf("Deleted account %s", username), nil)
+	}
+}
+
+// Runs a maintenance task. Only admins can run tasks
+func (h *AdminHandler) RunTask() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		account, ok := h.authenticate(w, r)
+		if !ok {
+			return
+		}
+		if !account.IsAdmin {
+			Forbid(w, r)
+			return
+		}
+
+		id := chi.URLParam(r, "id")
+		for _, task := range h.Tasks {
+			if task.Id != id {
+				continue
+			}
+
+			summary, err := task.Run()
+			if err != nil {
+				redirectAdmin(w, r, "", errors.Wrapf(err, "%s failed", task.Name))
+				return
+			}
+			recordAudit(h.DB, r, account, AuditMaintenanceRun, task.Id, nil, nil)
+			redirectAdmin(w, r, fmt.Sprintf("%s: %s", task.Name, summary), nil)
+			return
+		}
+		http.NotFound(w, r)
+	}
+}
+
+func (h *AdminHandler) projects() *ProjectHandler {
+	return &ProjectHandler{DB: h.DB, FS: h.FS, MaxUploadSize: h.MaxUploadSize}
+}
+
+// Authenticates the requester, asking the browser for credentials if it fails
+func (h *AdminHandler) authenticate(w http.ResponseWriter, r *http.Request) (*db.Account, bool) {
+	account, err := authenticate(h.DB, r)
+	if err != nil {
+		w.Header().Set("WWW-Authenticate", `Basic realm="Documentation administration", charset="UTF-8"`)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return nil, false
+	}
+	return account, true
+}
+
+// Goes back to the dashboard showing the outcome of a form
+func redirectAdmin(w http.ResponseWriter, r *http.Request, message string, err error) {
+	query := url.Values{}
+	if err != nil {
+		query.Set("error", err.Error())
+	} else {
+		query.Set("message", message)
+	}
+	http.Redirect(w, r, adminPath+"/?"+query.Encode(), http.StatusSeeOther)
+}
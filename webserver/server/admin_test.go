@@ -0,0 +1,139 @@
+package server_test
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	. "private-sphinx-docs/server"
+)
+
+func TestAdminHandler(t *testing.T) {
+	assert := require.New(t)
+
+	store := NewMockStore()
+	runs := 0
+	srv, err := New(Option{Store: store, FileHandler: NewFileHandler(), Maintenance: []MaintenanceTask{{
+		Id:   "count",
+		Name: "Count runs",
+		Run: func() (string, error) {
+			runs++
+			return "counted", nil
+		},
+	}}})
+	assert.NoError(err)
+
+	serve := func(r *http.Request, username string, status int) *httptest.ResponseRecorder {
+		if username != "" {
+			r.SetBasicAuth(username, "password")
+		}
+		w := httptest.NewRecorder()
+		srv.Handler.ServeHTTP(w, r)
+		assert.Equal(status, w.Code, w.Body.String())
+		return w
+	}
+
+	// browsers are asked for credentials
+	w := serve(httptest.NewRequest(http.MethodGet, "http://localhost/admin/", nil), "", http.StatusUnauthorized)
+	assert.Contains(w.Header().Get("WWW-Authenticate"), "Basic")
+
+	w = serve(httptest.NewRequest(http.MethodGet, "http://localhost/admin/", nil), "admin", http.StatusOK)
+	page := w.Body.String()
+	assert.Contains(page, `<a href="http://project1.localhost/">project1</a>`)
+	assert.Contains(page, "Create account")
+	assert.Contains(page, "Count runs")
+
+	cookies := w.Result().Cookies()
+	assert.Len(cookies, 1)
+	token := cookies[0].Value
+	assert.Contains(page, `value="`+token+`"`)
+
+	post := func(path string, form url.Values, username string, status int) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "http://localhost"+path, strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.AddCookie(cookies[0])
+		return serve(r, username, status)
+	}
+	redirected := func(w *httptest.ResponseRecorder) url.Values {
+		location, err := url.Parse(w.Header().Get("Location"))
+		assert.NoError(err)
+		assert.Equal("/admin/", location.Path)
+		return location.Query()
+	}
+
+	// forms without the CSRF token are rejected
+	post("/admin/accounts", url.Values{"username": {"user1"}, "password": {"password"}}, "admin", http.StatusForbidden)
+	post("/admin/accounts", url.Values{"username": {"user1"}, "password": {"password"}, "csrf_token": {"invalid"}}, "admin", http.StatusForbidden)
+	_, err = store.FetchAccount("user1")
+	assert.Error(err)
+
+	w = post("/admin/accounts", url.Values{"username": {"user1"}, "password": {"password"}, "csrf_token": {token}}, "admin", http.StatusSeeOther)
+	assert.Equal("Created account user1", redirected(w).Get("message"))
+	user, err := store.FetchAccount("user1")
+	assert.NoError(err)
+	assert.False(user.IsAdmin)
+
+	w = post("/admin/accounts", url.Values{"username": {"user1"}, "password": {"password"}, "csrf_token": {token}}, "admin", http.StatusSeeOther)
+	assert.NotEmpty(redirected(w).Get("error"))
+
+	// accounts manage their own projects
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	assert.NoError(mw.WriteField("title", "project2"))
+	part, err := mw.CreateFormFile("content", "docs.zip")
+	assert.NoError(err)
+	_, _ = part.Write(zipFiles(t, map[string]string{"index.html": "docs"}))
+	assert.NoError(mw.Close())
+
+	r := httptest.NewRequest(http.MethodPost, "http://localhost/admin/projects?csrf_token="+token, &body)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	r.AddCookie(cookies[0])
+	w = serve(r, "user1", http.StatusSeeOther)
+	assert.Equal("Published project2", redirected(w).Get("message"))
+
+	page = serve(httptest.NewRequest(http.MethodGet, "http://localhost/admin/", nil), "user1", http.StatusOK).Body.String()
+	assert.Contains(page, "project2")
+	assert.NotContains(page, "project1")
+	assert.NotContains(page, "Create account")
+	assert.Contains(page, "2 live files taking 1.0 KiB in your projects")
+
+	w = post("/admin/projects/project1/delete", url.Values{"csrf_token": {token}}, "user1", http.StatusSeeOther)
+	assert.NotEmpty(redirected(w).Get("error"))
+	post("/admin/maintenance/count", url.Values{"csrf_token": {token}}, "user1", http.StatusForbidden)
+	post("/admin/accounts/admin/delete", url.Values{"csrf_token": {token}}, "user1", http.StatusForbidden)
+
+	w = post("/admin/accounts/user1/update", url.Values{"password": {"changed"}, "is_admin": {"on"}, "csrf_token": {token}}, "user1", http.StatusSeeOther)
+	assert.Equal("Updated account user1", redirected(w).Get("message"))
+	user, err = store.FetchAccount("user1")
+	assert.NoError(err)
+	assert.False(user.IsAdmin)
+	assert.True(user.HasValidPassword("changed"))
+
+	// the password is kept if none is given
+	w = post("/admin/accounts/user1/update", url.Values{"is_admin": {"on"}, "csrf_token": {token}}, "admin", http.StatusSeeOther)
+	assert.Equal("Updated account user1", redirected(w).Get("message"))
+	user, err = store.FetchAccount("user1")
+	assert.NoError(err)
+	assert.True(user.IsAdmin)
+	assert.True(user.HasValidPassword("changed"))
+	w = post("/admin/accounts/user1/update", url.Values{"csrf_token": {token}}, "admin", http.StatusSeeOther)
+	assert.Equal("Updated account user1", redirected(w).Get("message"))
+	assert.False(user.IsAdmin)
+
+	// admins run the maintenance tasks and manage every project
+	w = post("/admin/maintenance/count", url.Values{"csrf_token": {token}}, "admin", http.StatusSeeOther)
+	assert.Equal("Count runs: counted", redirected(w).Get("message"))
+	assert.Equal(1, runs)
+	post("/admin/maintenance/unknown", url.Values{"csrf_token": {token}}, "admin", http.StatusNotFound)
+
+	w = post("/admin/projects/project2/delete", url.Values{"csrf_token": {token}}, "admin", http.StatusSeeOther)
+	assert.Equal("Deleted project2", redirected(w).Get("message"))
+	_, err = store.FetchProject("project2")
+	assert.Error(err)
+}
@@ -0,0 +1,155 @@
+package server
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	db "private-sphinx-docs/services/database"
+	sf "private-sphinx-docs/services/staticfiles"
+)
+
+// Formats of the downloadable archives keyed by the format query parameter
+var archiveFormats = map[string]struct {
+	ext         string
+	contentType string
+	write       func(w io.Writer, root string, walk func(fn sf.WalkFunc) error) error
+}{
+	"zip":    {".zip", "application/zip", writeZipArchive},
+	"tar.gz": {".tar.gz", "application/gzip", writeTarGzArchive},
+}
+
+// Streams the documentation of the project as zip (default) or tar.gz archive, set with the
+// format query parameter. The live documentation is sent unless an earlier version is given
+// with the version query parameter. Like the documentation itself, no account is required
+func (h *ProjectHandler) DownloadArchive() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		title := chi.URLParam(r, "title")
+		query := r.URL.Query()
+
+		formatName := strings.TrimSpace(query.Get("format"))
+		if formatName == "" {
+			formatName = "zip"
+		}
+		format, ok := archiveFormats[formatName]
+		if !ok {
+			BadRequest(w, errors.Errorf("unsupported format '%s', use 'zip' or 'tar.gz'", formatName))
+			return
+		}
+
+		project, err := h.DB.FetchProject(title)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+
+		version := strings.TrimSpace(query.Get("version"))
+		if version == "latest" {
+			version = ""
+		}
+		artifact, err := h.versionArtifact(project, version)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+
+		root := project.Title
+		if version != "" {
+			root += "-" + version
+		}
+		// the version is set by the uploaded documentation, keep it out of the header
+		root = strings.NewReplacer("/", "_", "\\", "_", `"`, "_").Replace(root)
+
+		w.Header().Set("Content-Type", format.contentType)
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s%s"`, root, format.ext))
+		w.WriteHeader(http.StatusOK)
+
+		err = format.write(w, root, func(fn sf.WalkFunc) error {
+			return h.FS.Walk(project.Title, artifact, fn)
+		})
+		if err != nil {
+			// the status is sent already, the client gets a truncated archive
+			log.WithError(err).WithField("project", project.Title).Error("could not send archive")
+		}
+	}
+}
+
+// Finds the artifact of the version of the project. The live documentation (an empty
+// artifact) is used if no version is given or the version is live
+func (h *ProjectHandler) versionArtifact(project *db.Project, version string) (string, error) {
+	if version == "" {
+		if _, err := h.FS.Stat(project.Title, ""); err != nil {
+			return "", errors.Errorf("project '%s' has not been published", project.Title)
+		}
+		return "", nil
+	}
+
+	revisions, err := h.DB.FetchRevisions(project.Id)
+	if err != nil {
+		return "", err
+	}
+	// revisions are sorted latest first
+	for _, rev := range revisions {
+		if rev.Version != version {
+			continue
+		}
+		if project.RevisionId != nil && *project.RevisionId == rev.Id {
+			return "", nil
+		}
+		return rev.Artifact, nil
+	}
+	return "", errors.Errorf("project '%s' has no version '%s'", project.Title, version)
+}
+
+func writeZipArchive(w io.Writer, root string, walk func(fn sf.WalkFunc) error) error {
+	zw := zip.NewWriter(w)
+	err := walk(func(p string, size int64, modTime time.Time, r io.Reader) error {
+		header := &zip.FileHeader{Name: path.Join(root, p), Method: zip.Deflate, Modified: modTime}
+		fw, err := zw.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(fw, r)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+func writeTarGzArchive(w io.Writer, root string, walk func(fn sf.WalkFunc) error) error {
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+	err := walk(func(p string, size int64, modTime time.Time, r io.Reader) error {
+		header := &tar.Header{
+			Name:     path.Join(root, p),
+			Mode:     0644,
+			Size:     size,
+			ModTime:  modTime,
+			Typeflag: tar.TypeReg,
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		_, err := io.CopyN(tw, r, size)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gw.Close()
+}
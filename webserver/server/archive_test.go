@@ -0,0 +1,123 @@
+package server_test
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	. "private-sphinx-docs/server"
+	sf "private-sphinx-docs/services/staticfiles"
+)
+
+func TestProjectHandler_DownloadArchive(t *testing.T) {
+	assert := require.New(t)
+
+	fs, cleanup := NewTestFileSys(t, sf.FileSysOption{})
+	defer cleanup()
+	srv := NewTestServer(t, Option{FileHandler: fs})
+
+	get := func(query string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		srv.Handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://localhost/api/project/project1/archive"+query, nil))
+		return w
+	}
+
+	// never published
+	assert.Equal(http.StatusNotFound, get("").Code)
+
+	v1 := map[string]string{
+		"index.html":                       "<html>v1</html>",
+		"_static/documentation_options.js": `var DOCUMENTATION_OPTIONS = { VERSION: '1.0' };`,
+	}
+	v2 := map[string]string{
+		"index.html":                       "<html>v2</html>",
+		"api/module.html":                  "<html>module</html>",
+		"_static/documentation_options.js": `var DOCUMENTATION_OPTIONS = { VERSION: '2.0' };`,
+	}
+	srv.Publish("project1", v1)
+	srv.Publish("project1", v2)
+
+	prefixed := func(prefix string, files map[string]string) map[string]string {
+		expected := make(map[string]string)
+		for name, content := range files {
+			expected[prefix+"/"+name] = content
+		}
+		return expected
+	}
+
+	// the live documentation is downloaded without an account
+	w := get("")
+	assert.Equal(http.StatusOK, w.Code, w.Body.String())
+	assert.Equal("application/zip", w.Header().Get("Content-Type"))
+	assert.Equal(`attachment; filename="project1.zip"`, w.Header().Get("Content-Disposition"))
+	assert.Equal(prefixed("project1", v2), readZip(t, w.Body.Bytes()))
+
+	w = get("?format=tar.gz&version=latest")
+	assert.Equal(http.StatusOK, w.Code, w.Body.String())
+	assert.Equal("application/gzip", w.Header().Get("Content-Type"))
+	assert.Equal(`attachment; filename="project1.tar.gz"`, w.Header().Get("Content-Disposition"))
+	assert.Equal(prefixed("project1", v2), readTarGz(t, w.Body.Bytes()))
+
+	// earlier versions are read from the saved artifacts
+	w = get("?version=1.0")
+	assert.Equal(http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(`attachment; filename="project1-1.0.zip"`, w.Header().Get("Content-Disposition"))
+	assert.Equal(prefixed("project1-1.0", v1), readZip(t, w.Body.Bytes()))
+
+	w = get("?version=1.0&format=tar.gz")
+	assert.Equal(http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(prefixed("project1-1.0", v1), readTarGz(t, w.Body.Bytes()))
+
+	w = get("?version=2.0")
+	assert.Equal(http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(prefixed("project1-2.0", v2), readZip(t, w.Body.Bytes()))
+
+	assert.Equal(http.StatusNotFound, get("?version=3.0").Code)
+	assert.Equal(http.StatusBadRequest, get("?format=rar").Code)
+
+	w = httptest.NewRecorder()
+	srv.Handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://localhost/api/project/unknown/archive", nil))
+	assert.Equal(http.StatusNotFound, w.Code)
+}
+
+func readZip(t *testing.T, content []byte) map[string]string {
+	reader, err := zip.NewReader(bytes.NewReader(content), int64(len(content)))
+	require.NoError(t, err)
+
+	files := make(map[string]string)
+	for _, file := range reader.File {
+		rc, err := file.Open()
+		require.NoError(t, err)
+		b, err := ioutil.ReadAll(rc)
+		require.NoError(t, err)
+		_ = rc.Close()
+		files[file.Name] = string(b)
+	}
+	return files
+}
+
+func readTarGz(t *testing.T, content []byte) map[string]string {
+	gz, err := gzip.NewReader(bytes.NewReader(content))
+	require.NoError(t, err)
+	tr := tar.NewReader(gz)
+
+	files := make(map[string]string)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return files
+		}
+		require.NoError(t, err)
+		b, err := ioutil.ReadAll(tr)
+		require.NoError(t, err)
+		files[header.Name] = string(b)
+	}
+}
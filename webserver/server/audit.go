@@ -0,0 +1,182 @@
+package server
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/middleware"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	db "private-sphinx-docs/services/database"
+)
+
+const (
+	AuditAccountCreate      = "account.create"
+	AuditAccountUpdate      = "account.update"
+	AuditAccountDelete      = "account.delete"
+	AuditAccountImpersonate = "account.impersonate"
+	AuditProjectUpload      = "project.upload"
+	AuditProjectUpdate      = "project.update"
+	AuditProjectDelete      = "project.delete"
+	AuditProjectRollback    = "project.rollback"
+	AuditMaintenanceRun     = "maintenance.run"
+
+	// actor used when the requester could not be authenticated
+	anonymous = "anonymous"
+)
+
+type AuditHandler struct {
+	DB IStore
+}
+
+// Lists the audit events. Only admins can query the audit trail. Results can be
+// filtered with the actor, action, target, since, until (RFC3339) and limit query
+// parameters
+func (h *AuditHandler) FetchEvents() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		account, err := authenticate(h.DB, r)
+		if err != nil || !account.IsAdmin {
+			Forbid(w, r)
+			return
+		}
+
+		filter, err := parseAuditFilter(r)
+		if err != nil {
+			BadRequest(w, err)
+			return
+		}
+
+		events, err := h.DB.FetchAuditEvents(filter)
+		if err != nil {
+			BadRequest(w, err)
+			return
+		}
+
+		toJson(w, events)
+	}
+}
+
+func parseAuditFilter(r *http.Request) (db.AuditFilter, error) {
+	query := r.URL.Query()
+	filter := db.AuditFilter{
+		Actor:  strings.TrimSpace(query.Get("actor")),
+		Action: strings.TrimSpace(query.Get("action")),
+		Target: strings.TrimSpace(query.Get("target")),
+	}
+
+	parseTime := func(key string) (time.Time, error) {
+		value := strings.TrimSpace(query.Get(key))
+		if value == "" {
+			return time.Time{}, nil
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return time.Time{}, errors.Wrapf(err, "'%s' must be an RFC3339 timestamp", key)
+		}
+		return t, nil
+	}
+
+	var err error
+	if filter.Since, err = parseTime("since"); err != nil {
+		return filter, err
+	}
+	if filter.Until, err = parseTime("until"); err != nil {
+		return filter, err
+	}
+
+	if value := strings.TrimSpace(query.Get("limit")); value != "" {
+		filter.Limit, err = strconv.Atoi(value)
+		if err != nil || filter.Limit < 0 {
+			return filter, errors.Errorf("invalid limit '%s'", value)
+		}
+	}
+
+	return filter, nil
+}
+
+// Records a mutating operation in the audit trail. The actor is the (possibly impersonated)
+// requester. Failures are logged but do not fail the request as the operation has already
+// been carried out.
+func recordAudit(store IStore, r *http.Request, actor *db.Account, action, target string, before, after interface{}) {
+	event := &db.AuditEvent{
+		Actor:     anonymous,
+		Action:    action,
+		Target:    target,
+		IP:        remoteIP(r),
+		RequestId: middleware.GetReqID(r.Context()),
+		Diff:      auditDiff(before, after),
+	}
+	if actor != nil {
+		event.Actor = actor.Username
+	}
+	if admin := impersonator(r); admin != nil {
+		event.Impersonator = admin.Username
+	}
+
+	if _, err := store.CreateAuditEvent(event); err != nil {
+		log.WithFields(log.Fields{
+			"actor":      event.Actor,
+			"action":     event.Action,
+			"target":     event.Target,
+			"request_id": event.RequestId,
+		}).Errorf("could not record audit event: %v", err)
+	}
+}
+
+func remoteIP(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+// Computes the fields that changed between the JSON representations of before and after.
+// Either of them can be nil when an object is created or removed. Passwords are masked.
+func auditDiff(before, after interface{}) json.RawMessage {
+	type change struct {
+		Old interface{} `json:"old,omitempty"`
+		New interface{} `json:"new,omitempty"`
+	}
+
+	toMap := func(object interface{}) map[string]interface{} {
+		values := make(map[string]interface{})
+		if v := reflect.ValueOf(object); !v.IsValid() || (v.Kind() == reflect.Ptr && v.IsNil()) {
+			return values
+		}
+		if content, err := json.Marshal(object); err == nil {
+			_ = json.Unmarshal(content, &values)
+		}
+		return values
+	}
+	mask := func(key string, value interface{}) interface{} {
+		if key == "password" && value != nil {
+			return "********"
+		}
+		return value
+	}
+
+	prev, next := toMap(before), toMap(after)
+	diff := make(map[string]change)
+	for key, value := range prev {
+		if !reflect.DeepEqual(value, next[key]) {
+			diff[key] = change{Old: mask(key, value), New: mask(key, next[key])}
+		}
+	}
+	for key, value := range next {
+		if _, exist := prev[key]; !exist {
+			diff[key] = change{New: mask(key, value)}
+		}
+	}
+
+	content, err := json.Marshal(diff)
+	if err != nil {
+		return json.RawMessage("{}")
+	}
+	return content
+}
@@ -0,0 +1,65 @@
+package server_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	. "private-sphinx-docs/server"
+	db "private-sphinx-docs/services/database"
+)
+
+func TestAuditHandler_FetchEvents(t *testing.T) {
+	t.Parallel()
+	assert := require.New(t)
+
+	store := NewMockStore()
+	_, err := store.CreateAccount("user1", "password", false)
+	assert.NoError(err)
+
+	// deleting a project should leave a trail
+	projects := &ProjectHandler{DB: store, FS: NewFileHandler()}
+	r := NewTestRequest("DELETE", "/", nil, map[string]string{"title": "project1"})
+	r.SetBasicAuth("admin", "password")
+	w := httptest.NewRecorder()
+	projects.DeleteProject()(w, r)
+	assert.Equal(http.StatusOK, w.Code)
+
+	handler := &AuditHandler{DB: store}
+	for _, s := range []struct {
+		Username   string
+		Query      string
+		Count      int
+		StatusCode int
+	}{
+		{"admin", "/", 1, http.StatusOK},
+		{"admin", "/?action=" + AuditProjectDelete + "&target=project1", 1, http.StatusOK},
+		{"admin", "/?actor=user1", 0, http.StatusOK},
+		{"admin", "/?since=yesterday", 0, http.StatusBadRequest},
+		{"user1", "/", 0, http.StatusForbidden},
+	} {
+		r := NewTestRequest("GET", s.Query, nil, nil)
+		r.SetBasicAuth(s.Username, "password")
+		w := httptest.NewRecorder()
+
+		handler.FetchEvents()(w, r)
+		assert.Equal(s.StatusCode, w.Code)
+
+		if s.StatusCode == http.StatusOK {
+			resp := w.Result()
+			var events []*db.AuditEvent
+			err = json.NewDecoder(resp.Body).Decode(&events)
+			assert.NoError(err)
+			assert.Len(events, s.Count)
+			assert.NoError(resp.Body.Close())
+
+			for _, e := range events {
+				assert.Equal("admin", e.Actor)
+				assert.Contains(string(e.Diff), "project1")
+			}
+		}
+	}
+}
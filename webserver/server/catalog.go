@@ -0,0 +1,172 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	db "private-sphinx-docs/services/database"
+)
+
+var catalogTemplate = newPageTemplate(`
+{{define "title"}}Documentation catalog{{end}}
+{{define "content"}}
+<h1>Documentation catalog</h1>
+<form class="filters" method="get" action="/">
+  <input type="search" name="q" value="{{.Query}}" placeholder="Title starts with" aria-label="Title">
+  <select name="owner" aria-label="Owner">
+    <option value="">All owners</option>
+    {{range .Owners}}<option value="{{.}}"{{if eq . $.Owner}} selected{{end}}>{{.}}</option>{{end}}
+  </select>
+  <select name="tag" aria-label="Tag">
+    <option value="">All tags</option>
+    {{range .Tags}}<option value="{{.}}"{{if eq . $.Tag}} selected{{end}}>{{.}}</option>{{end}}
+  </select>
+  <button type="submit">Filter</button>
+</form>
+<p class="muted">{{.Total}} project{{if ne .Total 1}}s{{end}}</p>
+<table>
+  <thead><tr><th>Project</th><th>Version</th><th>Owner</th><th>Last updated</th></tr></thead>
+  <tbody>
+  {{range .Projects}}
+    <tr>
+      <td>
+        <a href="{{.URL}}">{{.Title}}</a>
+        {{if .Description}}<div class="muted">{{.Description}}</div>{{end}}
+        {{if .Tags}}<div>{{range .Tags}}<a class="tag" href="/?tag={{.}}">{{.}}</a>{{end}}</div>{{end}}
+        {{if .RepositoryURL}}<a class="muted" href="{{.RepositoryURL}}">Source</a>{{end}}
+        {{if .IssueTrackerURL}}<a class="muted" href="{{.IssueTrackerURL}}">Issues</a>{{end}}
+      </td>
+      <td>{{if .Version}}{{.Version}}{{else}}<span class="muted">-</span>{{end}}</td>
+      <td>{{.Owner}}</td>
+      <td>{{date .LastUpdate}}</td>
+    </tr>
+  {{else}}
+    <tr><td colspan="4" class="muted">No projects found</td></tr>
+  {{end}}
+  </tbody>
+</table>
+{{if .Next}}<p><a href="{{.Next}}">Next page</a></p>{{end}}
+{{end}}
+`)
+
+// Number of projects on a page of the catalog
+const catalogPageSize = 50
+
+type CatalogHandler struct {
+	DB IStore
+}
+
+type catalogEntry struct {
+	Title           string
+	Description     string
+	Tags            []string
+	RepositoryURL   string
+	IssueTrackerURL string
+	Version         string
+	Owner           string
+	LastUpdate      time.Time
+	URL             string
+}
+
+type catalogPage struct {
+	Query    string
+	Owner    string
+	Owners   []string
+	Tag      string
+	Tags     []string
+	Total    int
+	Projects []*catalogEntry
+	// Url of the next page, empty on the last page
+	Next string
+}
+
+// Renders the catalog of every hosted project with links to their documentation. The projects
+// can be filtered by title prefix (q), owner and tag and are listed in pages, the cursor query
+// parameter selects the page
+func (h *CatalogHandler) Index() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		owners, err := h.DB.FetchProjectOwners()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		tags, err := h.DB.FetchProjectTags()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		values := r.URL.Query()
+		page := &catalogPage{
+			Query: strings.TrimSpace(values.Get("q")),
+			Owner: values.Get("owner"),
+			Tag:   strings.ToLower(strings.TrimSpace(values.Get("tag"))),
+			Tags:  tags,
+		}
+		filter := db.ProjectFilter{TitlePrefix: page.Query, Sort: db.SortByTitle, Limit: catalogPageSize}
+		if page.Tag != "" {
+			filter.Tags = []string{page.Tag}
+		}
+
+		usernames := make(map[int]string, len(owners))
+		for _, acc := range owners {
+			usernames[acc.Id] = acc.Username
+			page.Owners = append(page.Owners, acc.Username)
+			if acc.Username == page.Owner {
+				filter.AccountId = acc.Id
+			}
+		}
+		if page.Owner != "" && filter.AccountId == 0 {
+			// nobody by that name owns a project
+			renderHTML(w, catalogTemplate, page)
+			return
+		}
+
+		if cursor := values.Get("cursor"); cursor != "" {
+			if filter.After, err = decodeCursor(filter, cursor); err != nil {
+				BadRequest(w, err)
+				return
+			}
+		}
+
+		result, err := h.DB.QueryProjects(filter)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		ids := make([]int, len(result.Projects))
+		for i, p := range result.Projects {
+			ids[i] = p.Id
+		}
+		live, err := h.DB.FetchLiveRevisions(ids)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		page.Total = result.Total
+		for _, p := range result.Projects {
+			entry := &catalogEntry{
+				Title:           p.Title,
+				Description:     p.Description,
+				Tags:            p.Tags,
+				RepositoryURL:   p.RepositoryURL,
+				IssueTrackerURL: p.IssueTrackerURL,
+				Owner:           usernames[p.AccountId],
+				LastUpdate:      p.LastUpdate,
+				URL:             projectURL(r, p.Title, ""),
+			}
+			if rev, ok := live[p.Id]; ok {
+				entry.Version = rev.Version
+			}
+			page.Projects = append(page.Projects, entry)
+		}
+		if len(result.Projects) == filter.Limit {
+			values.Set("cursor", encodeCursor(filter, result.Projects[len(result.Projects)-1]))
+			page.Next = "/?" + values.Encode()
+		}
+
+		renderHTML(w, catalogTemplate, page)
+	}
+}
@@ -0,0 +1,98 @@
+package server_test
+
+import (
+	"fmt"
+	"html"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	. "private-sphinx-docs/server"
+	sf "private-sphinx-docs/services/staticfiles"
+)
+
+func TestCatalogHandler_Index(t *testing.T) {
+	assert := require.New(t)
+
+	fs, cleanup := NewTestFileSys(t, sf.FileSysOption{})
+	defer cleanup()
+
+	store := NewMockStore()
+	user, err := store.CreateAccount("user1", "password", false)
+	assert.NoError(err)
+	_, err = store.CreateAccount("user2", "password", false)
+	assert.NoError(err)
+	other, err := store.CreateOrUpdateProject(user.Id, "Other<Project>")
+	assert.NoError(err)
+	other.Description = "Other documentation"
+	other.Tags = []string{"python", "tools"}
+	other.RepositoryURL = "https://example.com/other"
+	_, err = store.UpdateProjectMetadata(other)
+	assert.NoError(err)
+
+	srv := NewTestServer(t, Option{Store: store, FileHandler: fs})
+
+	// the version is the one recorded with the live revision
+	srv.Publish("project1", map[string]string{
+		"index.html":                       "docs",
+		"_static/documentation_options.js": `var DOCUMENTATION_OPTIONS = { VERSION: '2.1' };`,
+	})
+
+	get := func(params string) string {
+		w := httptest.NewRecorder()
+		srv.Handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://localhost/"+params, nil))
+		assert.Equal(http.StatusOK, w.Code)
+		assert.Equal("text/html; charset=utf-8", w.Header().Get("Content-Type"))
+		return w.Body.String()
+	}
+
+	page := get("")
+	assert.Contains(page, `<a href="http://project1.localhost/">project1</a>`)
+	assert.Contains(page, "<td>2.1</td>")
+	assert.Contains(page, "Other&lt;Project&gt;")
+	assert.Contains(page, "2 projects")
+	assert.Contains(page, `<option value="user1">user1</option>`)
+	assert.NotContains(page, "user2", "only accounts owning a project are listed")
+
+	page = get("?q=PROJ")
+	assert.Contains(page, "1 project<")
+	assert.Contains(page, `value="PROJ"`)
+	assert.NotContains(page, "Other&lt;Project&gt;")
+
+	page = get("?owner=user1")
+	assert.Contains(page, "1 project<")
+	assert.Contains(page, `<option value="user1" selected>user1</option>`)
+	assert.NotContains(page, `<a href="http://project1.localhost/">`)
+
+	page = get("?tag=tools")
+	assert.Contains(page, "1 project<")
+	assert.Contains(page, "Other documentation")
+	assert.Contains(page, `<a class="tag" href="/?tag=python">python</a>`)
+	assert.Contains(page, `<a class="muted" href="https://example.com/other">Source</a>`)
+	assert.Contains(page, `<option value="tools" selected>tools</option>`)
+	assert.NotContains(page, `<a href="http://project1.localhost/">`)
+
+	assert.Contains(get("?q=unknown"), "No projects found")
+	assert.Contains(get("?owner=nobody"), "No projects found")
+
+	// the catalog is listed in pages
+	for i := 0; i <= 50; i++ {
+		_, err := store.CreateOrUpdateProject(user.Id, fmt.Sprintf("paged%02d", i))
+		assert.NoError(err)
+	}
+	page = get("?q=paged")
+	assert.Contains(page, "51 projects")
+	assert.Contains(page, "paged49")
+	assert.NotContains(page, "paged50")
+	assert.Contains(page, "Next page")
+
+	next := regexp.MustCompile(`href="(/\?[^"]+)">Next page`).FindStringSubmatch(page)
+	assert.Len(next, 2)
+	page = get(html.UnescapeString(next[1])[1:])
+	assert.Contains(page, "paged50")
+	assert.NotContains(page, "paged49")
+	assert.NotContains(page, "Next page")
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strings"
 
 	"github.com/pkg/errors"
 
@@ -34,12 +35,26 @@ func Ok(w http.ResponseWriter, _ *http.Request) {
 	_, _ = fmt.Fprintln(w, "okay")
 }
 
+// Links to the location (relative to the documentation root) on the subdomain of the project
+func projectURL(r *http.Request, project, location string) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return fmt.Sprintf("%s://%s.%s/%s", scheme, project, r.Host, strings.TrimPrefix(location, "/"))
+}
+
 func BadRequest(w http.ResponseWriter, err error) {
 	http.Error(w, err.Error(), http.StatusBadRequest)
 	return
 }
 
 func authenticate(store IStore, r *http.Request) (*db.Account, error) {
+	// admin has already been authenticated by the Impersonate middleware
+	if account := impersonated(r); account != nil {
+		return account, nil
+	}
+
 	username, password, ok := r.BasicAuth()
 	if !ok {
 		return nil, errors.New("authentication not set in request")
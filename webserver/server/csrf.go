@@ -0,0 +1,63 @@
+package server
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"net/http"
+)
+
+// The CSRF token of the HTML forms is kept in a cookie and must be sent back with every form
+// (double submit). Other sites can neither read the cookie nor set the form field
+const (
+	csrfCookie = "csrf_token"
+	csrfField  = "csrf_token"
+	csrfLength = 32
+)
+
+// Gets the CSRF token of the browser, issuing a new one if it has none
+func csrfToken(w http.ResponseWriter, r *http.Request, path string) string {
+	if c, err := r.Cookie(csrfCookie); err == nil && len(c.Value) == 2*csrfLength {
+		return c.Value
+	}
+
+	b := make([]byte, csrfLength)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	token := hex.EncodeToString(b)
+	http.SetCookie(w, &http.Cookie{
+		Name:     csrfCookie,
+		Value:    token,
+		Path:     path,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteStrictMode,
+	})
+	return token
+}
+
+// Rejects the requests changing state (any method but GET, HEAD and OPTIONS) without a valid
+// CSRF token in their form or query
+func CSRFProtect(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// multipart forms (uploads) send the token in the query so the body is not parsed here
+		token := r.URL.Query().Get(csrfField)
+		if token == "" {
+			token = r.PostFormValue(csrfField)
+		}
+
+		c, err := r.Cookie(csrfCookie)
+		if err != nil || len(c.Value) != 2*csrfLength || subtle.ConstantTimeCompare([]byte(c.Value), []byte(token)) != 1 {
+			http.Error(w, "invalid CSRF token", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
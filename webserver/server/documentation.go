@@ -1,42 +1,218 @@
 package server
 
 import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
 	"net/http"
 	"os"
-	"path/filepath"
+	"path"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/go-chi/chi"
 	log "github.com/sirupsen/logrus"
-
-	"private-sphinx-docs/libs"
 )
 
+// Content encodings of the precompressed files, in order of preference
+var contentEncodings = []string{"br", "gzip"}
+
+// Serves the live documentation of the project named by the subdomain
 type DocumentationHandler struct {
-	Root string
+	FS IFileHandler
+	// Default cache policy and the policies of specific projects
+	CachePolicy  CachePolicy
+	ProjectCache map[string]CachePolicy
+	// Optional in-memory cache of small files
+	Cache *FileCache
 }
 
-func (h *DocumentationHandler) MustInit() {
-	if !libs.PathExists(h.Root) {
-		err := os.MkdirAll(h.Root, 0744)
-		if err != nil {
-			panic(err)
+// How long the files of a project can be cached by browsers and proxies. Files are always
+// served with an ETag derived from their content so a zero duration means the file is
+// revalidated on every request
+type CachePolicy struct {
+	// Sphinx assets under _static, cached as immutable
+	Static time.Duration
+	// HTML pages, revalidated once stale
+	HTML time.Duration
+	// Any other file
+	Other time.Duration
+}
+
+// Sphinx links its assets with a version in the query string, they are cached for a year
+var DefaultCachePolicy = CachePolicy{Static: 365 * 24 * time.Hour, HTML: time.Minute, Other: time.Hour}
+
+// Builds the Cache-Control header of the file at the slash separated path
+func (c CachePolicy) header(p string) string {
+	switch {
+	case strings.HasPrefix(p, "/_static/"):
+		if c.Static > 0 {
+			return fmt.Sprintf("public, max-age=%d, immutable", int(c.Static.Seconds()))
+		}
+	case strings.HasSuffix(p, ".html") || strings.HasSuffix(p, ".htm"):
+		if c.HTML > 0 {
+			return fmt.Sprintf("public, max-age=%d, must-revalidate", int(c.HTML.Seconds()))
+		}
+	default:
+		if c.Other > 0 {
+			return fmt.Sprintf("public, max-age=%d", int(c.Other.Seconds()))
 		}
 	}
+	return "no-cache"
 }
 
-func (h *DocumentationHandler) FileServer() http.HandlerFunc {
-	log.Infof("Serving doc files from root folder: %s", h.Root)
+func (h *DocumentationHandler) cachePolicy(name string) CachePolicy {
+	if policy, ok := h.ProjectCache[strings.ToLower(name)]; ok {
+		return policy
+	}
+	return h.CachePolicy
+}
 
+func (h *DocumentationHandler) FileServer() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		// The package name
 		name := strings.Split(r.Host, ".")[0]
-		root := http.Dir(filepath.Join(h.Root, name))
-		fileServer := http.FileServer(root)
+		fileServer := http.FileServer(&projectFileSystem{fs: h.FS, name: name})
 
 		ctx := chi.RouteContext(r.Context())
 		pathPrefix := strings.TrimSuffix(ctx.RoutePattern(), "/*")
-		fs := http.StripPrefix(pathPrefix, fileServer)
+		fs := http.StripPrefix(pathPrefix, h.serve(name, fileServer))
 		fs.ServeHTTP(w, r)
 	}
 }
+
+// Adds the caching headers and serves the file precompressed at publish time if the client
+// accepts its encoding, otherwise falls back to next
+func (h *DocumentationHandler) serve(name string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+
+		p := r.URL.Path
+		if strings.HasSuffix(p, "/index.html") {
+			// redirected to the directory by the file server
+			next.ServeHTTP(w, r)
+			return
+		} else if strings.HasSuffix(p, "/") {
+			p += "index.html"
+		}
+
+		// directory listings, redirects and missing files are not cached
+		checksum, err := h.FS.Checksum(name, p)
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Cache-Control", h.cachePolicy(name).header(p))
+		w.Header().Set("ETag", fmt.Sprintf(`"%s"`, checksum))
+
+		for _, encoding := range acceptedEncodings(r.Header.Get("Accept-Encoding")) {
+			if h.serveFile(w, r, name, p, checksum, encoding) {
+				return
+			}
+		}
+		// files are read through the cache, the file server is only used without one
+		if h.Cache != nil && h.serveFile(w, r, name, p, checksum, "") {
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Serves the file with the content encoding ("" for the file as it is), from the file cache
+// if possible. Returns false if there is no such file
+func (h *DocumentationHandler) serveFile(w http.ResponseWriter, r *http.Request, name, p, checksum, encoding string) bool {
+	var content io.ReadSeeker
+	var size int64
+	var modTime time.Time
+
+	key := fileCacheKey{project: strings.ToLower(name), path: p, encoding: encoding}
+	if cached, ok := h.Cache.get(key, checksum); ok {
+		content, size, modTime = bytes.NewReader(cached.content), int64(len(cached.content)), cached.modTime
+	} else {
+		var file http.File
+		var err error
+		if encoding == "" {
+			file, err = h.FS.Open(name, p)
+		} else {
+			file, err = h.FS.OpenEncoded(name, p, encoding)
+		}
+		if err != nil {
+			return false
+		}
+		defer func() { _ = file.Close() }()
+
+		if cached, ok := h.Cache.load(key, checksum, file); ok {
+			content, size, modTime = bytes.NewReader(cached.content), int64(len(cached.content)), cached.modTime
+		} else {
+			info, err := file.Stat()
+			if err != nil || info.IsDir() {
+				return false
+			}
+			content, size, modTime = file, info.Size(), info.ModTime()
+		}
+	}
+
+	if encoding != "" {
+		// the type is sniffed from the content otherwise, which is compressed
+		contentType := mime.TypeByExtension(path.Ext(p))
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Content-Encoding", encoding)
+		// every encoding is a different representation
+		w.Header().Set("ETag", fmt.Sprintf(`"%s-%s"`, checksum, encoding))
+		// ServeContent leaves the length of encoded content unset. Ranges are ignored,
+		// the whole file is sent so that the length is always right
+		w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+		r.Header.Del("Range")
+	}
+	http.ServeContent(w, r, p, modTime, content)
+	return true
+}
+
+// Lists the precompressed encodings the client accepts, in order of preference
+func acceptedEncodings(header string) []string {
+	accepted := make(map[string]bool)
+	for _, part := range strings.Split(header, ",") {
+		params := strings.Split(part, ";")
+		encoding := strings.ToLower(strings.TrimSpace(params[0]))
+
+		q := 1.0
+		for _, param := range params[1:] {
+			if param = strings.TrimSpace(param); strings.HasPrefix(param, "q=") {
+				if value, err := strconv.ParseFloat(strings.TrimPrefix(param, "q="), 64); err == nil {
+					q = value
+				}
+			}
+		}
+		accepted[encoding] = q > 0
+	}
+
+	var encodings []string
+	for _, encoding := range contentEncodings {
+		if accepted[encoding] {
+			encodings = append(encodings, encoding)
+		}
+	}
+	return encodings
+}
+
+// Exposes the files of a single project as a http.FileSystem
+type projectFileSystem struct {
+	fs   IFileHandler
+	name string
+}
+
+func (p *projectFileSystem) Open(name string) (http.File, error) {
+	file, err := p.fs.Open(p.name, name)
+	if err != nil && !os.IsNotExist(err) && !os.IsPermission(err) {
+		// the file server would send the error to the client
+		log.Errorf("could not open '%s' of project '%s': %v", name, p.name, err)
+		return nil, os.ErrNotExist
+	}
+	return file, err
+}
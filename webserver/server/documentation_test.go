@@ -0,0 +1,134 @@
+package server_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/stretchr/testify/require"
+
+	. "private-sphinx-docs/server"
+	sf "private-sphinx-docs/services/staticfiles"
+)
+
+func TestDocumentationHandler_FileServer(t *testing.T) {
+	assert := require.New(t)
+
+	fs, cleanup := NewTestFileSys(t, sf.FileSysOption{})
+	defer cleanup()
+
+	page := strings.Repeat("<p>Sphinx</p>", 1000)
+	content := zipFiles(t, map[string]string{"index.html": page, "small.html": "<p>small</p>"})
+	_, err := fs.Upload(bytes.NewReader(content), "project", int64(len(content)))
+	assert.NoError(err)
+
+	handler := DocumentationHandler{FS: fs}
+	router := chi.NewRouter()
+	router.Handle("/*", handler.FileServer())
+
+	for _, test := range []struct {
+		Path           string
+		AcceptEncoding string
+		Encoding       string
+	}{
+		{"/", "gzip, deflate, br", "br"},
+		{"/", "gzip, br;q=0", "gzip"},
+		{"/", "", ""},
+		{"/small.html", "gzip", ""},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "http://project.docs.localhost"+test.Path, nil)
+		if test.AcceptEncoding != "" {
+			r.Header.Set("Accept-Encoding", test.AcceptEncoding)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+
+		assert.Equal(http.StatusOK, w.Code)
+		assert.Equal(test.Encoding, w.Header().Get("Content-Encoding"))
+		assert.Equal("Accept-Encoding", w.Header().Get("Vary"))
+		assert.Equal("text/html; charset=utf-8", w.Header().Get("Content-Type"))
+		assert.Equal(strconv.Itoa(w.Body.Len()), w.Header().Get("Content-Length"))
+
+		if test.Encoding == "gzip" {
+			zr, err := gzip.NewReader(w.Body)
+			assert.NoError(err)
+			body, err := ioutil.ReadAll(zr)
+			assert.NoError(err)
+			assert.Equal(page, string(body))
+		} else if test.Encoding == "" && test.Path == "/" {
+			assert.Equal(page, w.Body.String())
+		}
+	}
+}
+
+func TestDocumentationHandler_Caching(t *testing.T) {
+	assert := require.New(t)
+
+	fs, cleanup := NewTestFileSys(t, sf.FileSysOption{})
+	defer cleanup()
+
+	page := strings.Repeat("<p>Sphinx</p>", 1000)
+	files := map[string]string{"index.html": page, "_static/app.js": "js", "objects.inv": "inv"}
+	for _, name := range []string{"project", "other"} {
+		content := zipFiles(t, files)
+		_, err := fs.Upload(bytes.NewReader(content), name, int64(len(content)))
+		assert.NoError(err)
+	}
+
+	handler := DocumentationHandler{
+		FS:           fs,
+		CachePolicy:  DefaultCachePolicy,
+		ProjectCache: map[string]CachePolicy{"other": {HTML: time.Hour}},
+	}
+	router := chi.NewRouter()
+	router.Handle("/*", handler.FileServer())
+
+	serve := func(host, path string, headers map[string]string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "http://"+host+".docs.localhost"+path, nil)
+		for key, value := range headers {
+			r.Header.Set(key, value)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		return w
+	}
+
+	sum := sha256.Sum256([]byte(page))
+	etag := fmt.Sprintf(`"%s"`, hex.EncodeToString(sum[:]))
+
+	for _, test := range []struct {
+		Project      string
+		Path         string
+		Headers      map[string]string
+		StatusCode   int
+		ETag         string
+		CacheControl string
+	}{
+		{"project", "/", nil, http.StatusOK, etag, "public, max-age=60, must-revalidate"},
+		{"project", "/", map[string]string{"If-None-Match": etag}, http.StatusNotModified, etag, "public, max-age=60, must-revalidate"},
+		{"project", "/", map[string]string{"Accept-Encoding": "gzip"}, http.StatusOK, strings.TrimSuffix(etag, `"`) + `-gzip"`, "public, max-age=60, must-revalidate"},
+		{"project", "/_static/app.js", nil, http.StatusOK, "", "public, max-age=31536000, immutable"},
+		{"project", "/objects.inv", nil, http.StatusOK, "", "public, max-age=3600"},
+		{"project", "/_static/", nil, http.StatusOK, "", ""},
+		{"project", "/missing.html", nil, http.StatusNotFound, "", ""},
+		{"other", "/", nil, http.StatusOK, etag, "public, max-age=3600, must-revalidate"},
+		{"other", "/_static/app.js", nil, http.StatusOK, "", "no-cache"},
+	} {
+		w := serve(test.Project, test.Path, test.Headers)
+		assert.Equal(test.StatusCode, w.Code, test.Path)
+		assert.Equal(test.CacheControl, w.Header().Get("Cache-Control"), test.Path)
+		if test.ETag != "" {
+			assert.Equal(test.ETag, w.Header().Get("ETag"))
+		}
+	}
+}
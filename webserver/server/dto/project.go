@@ -0,0 +1,117 @@
+package dto
+
+import (
+	"net/url"
+	"strings"
+
+	db "private-sphinx-docs/services/database"
+)
+
+// Keys of the project metadata in upload forms, query strings and tus Upload-Metadata
+const (
+	MetadataDescription  = "description"
+	MetadataRepository   = "repository_url"
+	MetadataIssueTracker = "issue_tracker_url"
+	MetadataContacts     = "contacts"
+	MetadataTags         = "tags"
+)
+
+// Metadata of a project. Nil fields are left unchanged when applied to a project
+type ProjectMetadata struct {
+	Description     *string   `json:"description"`
+	RepositoryURL   *string   `json:"repositoryUrl"`
+	IssueTrackerURL *string   `json:"issueTrackerUrl"`
+	Contacts        *[]string `json:"contacts"`
+	Tags            *[]string `json:"tags"`
+}
+
+// Reads the metadata from form or query values. Contacts and tags may be repeated or comma
+// separated
+func NewProjectMetadata(values url.Values) *ProjectMetadata {
+	m := &ProjectMetadata{}
+	text := func(key string) *string {
+		if _, ok := values[key]; !ok {
+			return nil
+		}
+		v := strings.TrimSpace(values.Get(key))
+		return &v
+	}
+	list := func(key string) *[]string {
+		if _, ok := values[key]; !ok {
+			return nil
+		}
+		var items []string
+		for _, v := range values[key] {
+			items = append(items, strings.Split(v, ",")...)
+		}
+		return &items
+	}
+
+	m.Description = text(MetadataDescription)
+	m.RepositoryURL = text(MetadataRepository)
+	m.IssueTrackerURL = text(MetadataIssueTracker)
+	m.Contacts = list(MetadataContacts)
+	m.Tags = list(MetadataTags)
+	return m
+}
+
+// True if no metadata is set
+func (m *ProjectMetadata) Empty() bool {
+	return m == nil || (m.Description == nil && m.RepositoryURL == nil && m.IssueTrackerURL == nil &&
+		m.Contacts == nil && m.Tags == nil)
+}
+
+// Sets the metadata on the project. Tags are lowercased and duplicates removed
+func (m *ProjectMetadata) Apply(project *db.Project) {
+	if m == nil {
+		return
+	}
+	if m.Description != nil {
+		project.Description = strings.TrimSpace(*m.Description)
+	}
+	if m.RepositoryURL != nil {
+		project.RepositoryURL = strings.TrimSpace(*m.RepositoryURL)
+	}
+	if m.IssueTrackerURL != nil {
+		project.IssueTrackerURL = strings.TrimSpace(*m.IssueTrackerURL)
+	}
+	if m.Contacts != nil {
+		project.Contacts = uniqueValues(*m.Contacts, false)
+	}
+	if m.Tags != nil {
+		project.Tags = uniqueValues(*m.Tags, true)
+	}
+}
+
+// Checks the metadata without a project at hand
+func (m *ProjectMetadata) Validate() error {
+	project := &db.Project{}
+	m.Apply(project)
+	return project.ValidateMetadata()
+}
+
+// Normalizes the tags given in a query, the same way as they are stored
+func NormalizeTags(tags []string) []string {
+	var values []string
+	for _, t := range tags {
+		values = append(values, strings.Split(t, ",")...)
+	}
+	return uniqueValues(values, true)
+}
+
+func uniqueValues(values []string, lower bool) []string {
+	seen := make(map[string]bool, len(values))
+	unique := make([]string, 0, len(values))
+	for _, v := range values {
+		v = strings.TrimSpace(v)
+		if lower {
+			v = strings.ToLower(v)
+		}
+		if v == "" || seen[v] {
+			continue
+		}
+		seen[v] = true
+		unique = append(unique, v)
+	}
+	return unique
+}
@@ -0,0 +1,243 @@
+package server
+
+import (
+	"container/list"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	sf "private-sphinx-docs/services/staticfiles"
+)
+
+// Bounded LRU cache of the content of small documentation files, sized in bytes. Entries are
+// dropped when their project is published or removed, and are only served while the checksum
+// of the live file matches. A nil cache is disabled.
+type FileCache struct {
+	maxSize     int64
+	maxFileSize int64
+
+	mu    sync.Mutex
+	size  int64
+	lru   *list.List
+	items map[fileCacheKey]*list.Element
+
+	hits      uint64
+	misses    uint64
+	evictions uint64
+}
+
+type fileCacheKey struct {
+	project  string
+	path     string
+	encoding string
+}
+
+type cachedFile struct {
+	key      fileCacheKey
+	checksum string
+	content  []byte
+	modTime  time.Time
+}
+
+// Hit and miss counters and current usage of the cache
+type FileCacheStats struct {
+	Hits        uint64 `json:"hits"`
+	Misses      uint64 `json:"misses"`
+	Evictions   uint64 `json:"evictions"`
+	Entries     int    `json:"entries"`
+	Size        int64  `json:"size"`
+	MaxSize     int64  `json:"maxSize"`
+	MaxFileSize int64  `json:"maxFileSize"`
+}
+
+// Creates a cache holding at most maxSize bytes of files no larger than maxFileSize bytes.
+// Returns nil (no cache) if maxSize is not positive
+func NewFileCache(maxSize, maxFileSize int64) *FileCache {
+	if maxSize <= 0 {
+		return nil
+	}
+	if maxFileSize <= 0 || maxFileSize > maxSize {
+		maxFileSize = maxSize
+	}
+
+	return &FileCache{
+		maxSize:     maxSize,
+		maxFileSize: maxFileSize,
+		lru:         list.New(),
+		items:       make(map[fileCacheKey]*list.Element),
+	}
+}
+
+// Gets the cached file if it still has the checksum of the live file
+func (c *FileCache) get(key fileCacheKey, checksum string) (*cachedFile, bool) {
+	if c == nil {
+		return nil, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.items[key]; ok {
+		if file := e.Value.(*cachedFile); file.checksum == checksum {
+			c.lru.MoveToFront(e)
+			atomic.AddUint64(&c.hits, 1)
+			return file, true
+		}
+		c.remove(e)
+	}
+
+	atomic.AddUint64(&c.misses, 1)
+	return nil, false
+}
+
+func (c *FileCache) add(file *cachedFile) {
+	size := int64(len(file.content))
+	if size > c.maxFileSize {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.items[file.key]; ok {
+		c.remove(e)
+	}
+	for c.size+size > c.maxSize {
+		c.remove(c.lru.Back())
+		atomic.AddUint64(&c.evictions, 1)
+	}
+
+	c.items[file.key] = c.lru.PushFront(file)
+	c.size += size
+}
+
+// Must be called with the lock held
+func (c *FileCache) remove(e *list.Element) {
+	file := c.lru.Remove(e).(*cachedFile)
+	delete(c.items, file.key)
+	c.size -= int64(len(file.content))
+}
+
+// Drops every cached file of the project
+func (c *FileCache) Invalidate(project string) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	project = strings.ToLower(project)
+	for key, e := range c.items {
+		if key.project == project {
+			c.remove(e)
+		}
+	}
+}
+
+// Drops every cached file. Returns the number of files dropped
+func (c *FileCache) Clear() int {
+	if c == nil {
+		return 0
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	n := len(c.items)
+	c.lru.Init()
+	c.items = make(map[fileCacheKey]*list.Element)
+	c.size = 0
+	return n
+}
+
+func (c *FileCache) Stats() FileCacheStats {
+	if c == nil {
+		return FileCacheStats{}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return FileCacheStats{
+		Hits:        atomic.LoadUint64(&c.hits),
+		Misses:      atomic.LoadUint64(&c.misses),
+		Evictions:   atomic.LoadUint64(&c.evictions),
+		Entries:     len(c.items),
+		Size:        c.size,
+		MaxSize:     c.maxSize,
+		MaxFileSize: c.maxFileSize,
+	}
+}
+
+// Reads the file into the cache if it is small enough. Returns false if it is not cached
+func (c *FileCache) load(key fileCacheKey, checksum string, file http.File) (*cachedFile, bool) {
+	if c == nil {
+		return nil, false
+	}
+
+	info, err := file.Stat()
+	if err != nil || info.IsDir() || info.Size() > c.maxFileSize {
+		return nil, false
+	}
+
+	content := make([]byte, info.Size())
+	if _, err := io.ReadFull(file, content); err != nil {
+		return nil, false
+	}
+
+	cached := &cachedFile{key: key, checksum: checksum, content: content, modTime: info.ModTime()}
+	c.add(cached)
+	return cached, true
+}
+
+type CacheHandler struct {
+	DB    IStore
+	Cache *FileCache
+}
+
+// Reports the hit and miss counters and the usage of the file cache. Only admins can query
+// the statistics
+func (h *CacheHandler) FetchStats() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		account, err := authenticate(h.DB, r)
+		if err != nil || !account.IsAdmin {
+			Forbid(w, r)
+			return
+		}
+
+		toJson(w, struct {
+			Enabled bool `json:"enabled"`
+			FileCacheStats
+		}{h.Cache != nil, h.Cache.Stats()})
+	}
+}
+
+// Drops the cached files of a project whenever it is published or removed
+type invalidatingFileHandler struct {
+	IFileHandler
+	cache *FileCache
+}
+
+func (f *invalidatingFileHandler) Upload(r io.ReaderAt, name string, size int64) (*sf.Stats, error) {
+	defer f.cache.Invalidate(name)
+	return f.IFileHandler.Upload(r, name, size)
+}
+
+func (f *invalidatingFileHandler) Restore(name, artifact string) error {
+	defer f.cache.Invalidate(name)
+	return f.IFileHandler.Restore(name, artifact)
+}
+
+func (f *invalidatingFileHandler) Sync(name string, r io.ReaderAt, size int64, deletions []string) (string, string, int64, *sf.Stats, error) {
+	defer f.cache.Invalidate(name)
+	return f.IFileHandler.Sync(name, r, size, deletions)
+}
+
+func (f *invalidatingFileHandler) Remove(name string) error {
+	defer f.cache.Invalidate(name)
+	return f.IFileHandler.Remove(name)
+}
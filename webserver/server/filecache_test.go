@@ -0,0 +1,84 @@
+package server_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	. "private-sphinx-docs/server"
+	sf "private-sphinx-docs/services/staticfiles"
+)
+
+func TestFileCache(t *testing.T) {
+	assert := require.New(t)
+
+	fs, cleanup := NewTestFileSys(t, sf.FileSysOption{})
+	defer cleanup()
+
+	large := strings.Repeat("x", 2048)
+	content := zipFiles(t, map[string]string{
+		"index.html":     "v1",
+		"sub/index.html": "sub",
+		"other.html":     "oth",
+		"large.txt":      large,
+	})
+	_, err := fs.Upload(bytes.NewReader(content), "project1", int64(len(content)))
+	assert.NoError(err)
+
+	cache := NewFileCache(7, 4)
+	srv := NewTestServer(t, Option{FileHandler: fs, FileCache: cache})
+
+	get := func(path, expected string) {
+		w := httptest.NewRecorder()
+		srv.Handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://project1.localhost"+path, nil))
+		assert.Equal(http.StatusOK, w.Code)
+		assert.Equal(expected, w.Body.String())
+	}
+	stats := func() FileCacheStats {
+		r := httptest.NewRequest(http.MethodGet, "http://localhost/api/admin/cache", nil)
+		r.SetBasicAuth("admin", "password")
+		w := httptest.NewRecorder()
+		srv.Handler.ServeHTTP(w, r)
+		assert.Equal(http.StatusOK, w.Code)
+
+		var stats FileCacheStats
+		assert.NoError(json.NewDecoder(w.Body).Decode(&stats))
+		return stats
+	}
+
+	get("/", "v1")
+	get("/", "v1")
+	assert.Equal(FileCacheStats{Hits: 1, Misses: 1, Entries: 1, Size: 2, MaxSize: 7, MaxFileSize: 4}, stats())
+
+	// large files are served without being cached
+	get("/large.txt", large)
+	assert.Equal(1, stats().Entries)
+	cache.Invalidate("project1")
+
+	// least recently used files are evicted once the cache is full
+	get("/other.html", "oth")
+	get("/sub/", "sub")
+	get("/", "v1")
+	get("/other.html", "oth")
+	s := stats()
+	assert.EqualValues(2, s.Evictions)
+	assert.Equal(2, s.Entries)
+	get("/", "v1")
+	assert.EqualValues(2, stats().Hits)
+
+	// publishing a project drops its files
+	content = zipFiles(t, map[string]string{"index.html": "v2"})
+	r := httptest.NewRequest(http.MethodPut, "http://localhost/api/project/project1", bytes.NewReader(content))
+	r.SetBasicAuth("admin", "password")
+	r.Header.Set("Content-Type", "application/zip")
+	w := httptest.NewRecorder()
+	srv.Handler.ServeHTTP(w, r)
+	assert.Equal(http.StatusOK, w.Code, w.Body.String())
+	assert.Zero(stats().Entries)
+	get("/", "v2")
+}
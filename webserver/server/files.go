@@ -0,0 +1,115 @@
+package server
+
+import (
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/pkg/errors"
+)
+
+// An entry of a directory of the live documentation
+type FileEntry struct {
+	Name string `json:"name"`
+	// Slash separated path relative to the project root
+	Path        string    `json:"path"`
+	IsDir       bool      `json:"isDir"`
+	Size        int64     `json:"size"`
+	ModTime     time.Time `json:"modTime"`
+	ContentType string    `json:"contentType,omitempty"`
+}
+
+// Lists the directory at the path query parameter (the project root by default) of the live
+// documentation of the project. Requires a valid account
+func (h *ProjectHandler) ListFiles() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if _, err := authenticate(h.DB, r); err != nil {
+			Forbid(w, r)
+			return
+		}
+
+		title := chi.URLParam(r, "title")
+		dir := cleanFilePath(r.URL.Query().Get("path"))
+		info, err := h.FS.Stat(title, dir)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		} else if !info.IsDir() {
+			BadRequest(w, errors.Errorf("'%s' is not a directory", dir))
+			return
+		}
+
+		infos, err := h.FS.List(title, dir)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		entries := make([]*FileEntry, 0, len(infos))
+		for _, info := range infos {
+			entry := &FileEntry{
+				Name:    info.Name(),
+				Path:    path.Join(dir, info.Name()),
+				IsDir:   info.IsDir(),
+				ModTime: info.ModTime(),
+			}
+			if !entry.IsDir {
+				entry.Size = info.Size()
+				entry.ContentType = contentType(entry.Name)
+			}
+			entries = append(entries, entry)
+		}
+		toJson(w, entries)
+	}
+}
+
+// Serves the raw bytes of the file at the path query parameter of the live documentation of
+// the project. Requires a valid account. Range and conditional requests are supported
+func (h *ProjectHandler) RawFile() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if _, err := authenticate(h.DB, r); err != nil {
+			Forbid(w, r)
+			return
+		}
+
+		p := cleanFilePath(r.URL.Query().Get("path"))
+		if p == "" {
+			BadRequest(w, errors.New("path of the file must be specified"))
+			return
+		}
+
+		file, err := h.FS.Open(chi.URLParam(r, "title"), p)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		defer func() { _ = file.Close() }()
+
+		info, err := file.Stat()
+		if err != nil || info.IsDir() {
+			http.NotFound(w, r)
+			return
+		}
+
+		// the documentation is served from the main domain here, keep its scripts sandboxed
+		w.Header().Set("Content-Type", contentType(p))
+		w.Header().Set("Content-Security-Policy", "sandbox")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		http.ServeContent(w, r, path.Base(p), info.ModTime(), file)
+	}
+}
+
+// Cleans the slash separated path relative to the project root. The root is ""
+func cleanFilePath(p string) string {
+	return strings.TrimPrefix(path.Clean("/"+p), "/")
+}
+
+func contentType(name string) string {
+	if t := mime.TypeByExtension(path.Ext(name)); t != "" {
+		return t
+	}
+	return "application/octet-stream"
+}
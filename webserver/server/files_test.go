@@ -0,0 +1,96 @@
+package server_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	. "private-sphinx-docs/server"
+	sf "private-sphinx-docs/services/staticfiles"
+)
+
+func TestProjectHandler_Files(t *testing.T) {
+	// extracted trees and served archives
+	for _, archives := range []bool{false, true} {
+		testFiles(t, archives)
+	}
+}
+
+func testFiles(t *testing.T, archives bool) {
+	assert := require.New(t)
+
+	fs, cleanup := NewTestFileSys(t, sf.FileSysOption{ServeArchives: archives})
+	defer cleanup()
+
+	content := zipFiles(t, map[string]string{
+		"index.html":       "<html>index</html>",
+		"_static/app.js":   "app",
+		"_static/logo.png": "png",
+		"api/module.html":  "<html>module</html>",
+	})
+	_, err := fs.Upload(bytes.NewReader(content), "project1", int64(len(content)))
+	assert.NoError(err)
+
+	srv := NewTestServer(t, Option{FileHandler: fs})
+
+	get := func(target string, auth bool, header map[string]string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "http://localhost/api/project/project1/"+target, nil)
+		if auth {
+			r.SetBasicAuth("admin", "password")
+		}
+		for key, value := range header {
+			r.Header.Set(key, value)
+		}
+		w := httptest.NewRecorder()
+		srv.Handler.ServeHTTP(w, r)
+		return w
+	}
+	list := func(dir string) []*FileEntry {
+		w := get("files?path="+dir, true, nil)
+		assert.Equal(http.StatusOK, w.Code, w.Body.String())
+
+		var entries []*FileEntry
+		assert.NoError(json.NewDecoder(w.Body).Decode(&entries))
+		return entries
+	}
+
+	entries := list("")
+	assert.Len(entries, 3)
+	assert.Equal("_static", entries[0].Name)
+	assert.True(entries[0].IsDir)
+	assert.Equal("index.html", entries[2].Path)
+	assert.EqualValues(18, entries[2].Size)
+	assert.Equal("text/html; charset=utf-8", entries[2].ContentType)
+	assert.False(entries[2].ModTime.IsZero())
+
+	entries = list("/_static/")
+	assert.Len(entries, 2)
+	assert.Equal("_static/app.js", entries[0].Path)
+	assert.Equal("image/png", entries[1].ContentType)
+
+	// paths cannot escape the project
+	assert.Len(list("../../api"), 1)
+
+	assert.Equal(http.StatusForbidden, get("files", false, nil).Code)
+	assert.Equal(http.StatusNotFound, get("files?path=missing", true, nil).Code)
+	assert.Equal(http.StatusBadRequest, get("files?path=index.html", true, nil).Code)
+
+	w := get("raw?path=api/module.html", true, nil)
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Equal("<html>module</html>", w.Body.String())
+	assert.Equal("text/html; charset=utf-8", w.Header().Get("Content-Type"))
+	assert.Equal("sandbox", w.Header().Get("Content-Security-Policy"))
+
+	w = get("raw?path=index.html", true, map[string]string{"Range": "bytes=6-10"})
+	assert.Equal(http.StatusPartialContent, w.Code)
+	assert.Equal("index", w.Body.String())
+
+	assert.Equal(http.StatusForbidden, get("raw?path=index.html", false, nil).Code)
+	assert.Equal(http.StatusBadRequest, get("raw", true, nil).Code)
+	assert.Equal(http.StatusNotFound, get("raw?path=_static", true, nil).Code)
+	assert.Equal(http.StatusNotFound, get("raw?path=missing.html", true, nil).Code)
+}
@@ -0,0 +1,94 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/middleware"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	db "private-sphinx-docs/services/database"
+)
+
+type contextKey string
+
+const (
+	impersonatorKey contextKey = "impersonator"
+	impersonatedKey contextKey = "impersonated"
+
+	// Request header used by admins to act as another account
+	ImpersonateHeader = "X-Impersonate"
+	// Response headers marking that the request was executed as another account
+	ImpersonatedByHeader   = "X-Impersonated-By"
+	ImpersonatedUserHeader = "X-Impersonated-User"
+)
+
+// Impersonate lets an admin act as another account by setting the X-Impersonate header
+// together with the admin's BasicAuth credentials. Requests that do not carry the header
+// are passed through untouched. Once accepted, every handler sees the impersonated account
+// as the requester (see authenticate) and the response is marked with the admin and the
+// impersonated username and the impersonation is recorded in the audit trail.
+func Impersonate(store IStore) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			username := strings.TrimSpace(r.Header.Get(ImpersonateHeader))
+			if username == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			admin, err := authenticate(store, r)
+			if err != nil || !admin.IsAdmin {
+				Forbid(w, r)
+				return
+			}
+
+			account, err := store.FetchAccount(username)
+			if err != nil {
+				BadRequest(w, errors.Wrapf(err, "could not impersonate account '%s'", username))
+				return
+			}
+
+			log.WithFields(log.Fields{
+				"admin":        admin.Username,
+				"impersonated": account.Username,
+				"method":       r.Method,
+				"path":         r.URL.Path,
+				"request_id":   middleware.GetReqID(r.Context()),
+			}).Warn("admin is impersonating account")
+
+			ctx := context.WithValue(r.Context(), impersonatorKey, admin)
+			ctx = context.WithValue(ctx, impersonatedKey, account)
+			r = r.WithContext(ctx)
+
+			recordAudit(store, r, admin, AuditAccountImpersonate, account.Username, nil, map[string]string{
+				"method": r.Method,
+				"path":   r.URL.Path,
+			})
+
+			w.Header().Set(ImpersonatedByHeader, admin.Username)
+			w.Header().Set(ImpersonatedUserHeader, account.Username)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Returns the admin account that is impersonating another account in this request
+// or nil if the request is not impersonated
+func impersonator(r *http.Request) *db.Account {
+	if account, ok := r.Context().Value(impersonatorKey).(*db.Account); ok {
+		return account
+	}
+	return nil
+}
+
+// Returns the account being impersonated in this request or nil if the request is
+// not impersonated
+func impersonated(r *http.Request) *db.Account {
+	if account, ok := r.Context().Value(impersonatedKey).(*db.Account); ok {
+		return account
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package server_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	. "private-sphinx-docs/server"
+	db "private-sphinx-docs/services/database"
+)
+
+func TestImpersonate(t *testing.T) {
+	t.Parallel()
+	assert := require.New(t)
+
+	store := NewMockStore()
+	_, err := store.CreateAccount("user1", "password", false)
+	assert.NoError(err)
+	_, err = store.CreateAccount("user2", "password", false)
+	assert.NoError(err)
+
+	handler := &AccountHandler{DB: store, FS: NewFileHandler()}
+	// only succeeds if the requester (as seen by the handler) is user1
+	next := Impersonate(store)(handler.ValidateAccount())
+
+	for _, s := range []struct {
+		Username     string
+		Password     string
+		Impersonate  string
+		StatusCode   int
+		Impersonated bool
+	}{
+		{"admin", "password", "user1", http.StatusOK, true},
+		{"admin", "badPwd", "user1", http.StatusForbidden, false},
+		{"user2", "password", "user1", http.StatusForbidden, false},
+		{"admin", "password", "DoesNotExist", http.StatusBadRequest, false},
+		{"user1", "password", "", http.StatusOK, false},
+	} {
+		r := NewTestRequest("GET", "/", nil, nil)
+		r.SetBasicAuth(s.Username, s.Password)
+		if s.Impersonate != "" {
+			r.Header.Set(ImpersonateHeader, s.Impersonate)
+		}
+		w := httptest.NewRecorder()
+
+		next.ServeHTTP(w, r)
+		assert.Equal(s.StatusCode, w.Code)
+
+		if s.Impersonated {
+			assert.Equal(s.Username, w.Header().Get(ImpersonatedByHeader))
+			assert.Equal(s.Impersonate, w.Header().Get(ImpersonatedUserHeader))
+		} else {
+			assert.Empty(w.Header().Get(ImpersonatedByHeader))
+		}
+	}
+
+	// every impersonated request is in the audit trail
+	events, err := store.FetchAuditEvents(db.AuditFilter{Action: AuditAccountImpersonate})
+	assert.NoError(err)
+	assert.Len(events, 1)
+	assert.Equal("admin", events[0].Actor)
+	assert.Equal("admin", events[0].Impersonator)
+	assert.Equal("user1", events[0].Target)
+}
+
+func TestImpersonate_Dashboard(t *testing.T) {
+	t.Parallel()
+	assert := require.New(t)
+
+	store := NewMockStore()
+	_, err := store.CreateAccount("user1", "password", false)
+	assert.NoError(err)
+	srv, err := New(Option{Store: store, FileHandler: NewFileHandler()})
+	assert.NoError(err)
+
+	r := httptest.NewRequest(http.MethodGet, "http://localhost/admin/", nil)
+	r.SetBasicAuth("admin", "password")
+	r.Header.Set(ImpersonateHeader, "user1")
+	w := httptest.NewRecorder()
+	srv.Handler.ServeHTTP(w, r)
+
+	assert.Equal(http.StatusOK, w.Code, w.Body.String())
+	assert.Equal("user1", w.Header().Get(ImpersonatedUserHeader))
+	// user1 is not an admin, the accounts are not managed from its dashboard
+	assert.NotContains(w.Body.String(), "Accounts")
+}
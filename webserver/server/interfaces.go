@@ -2,30 +2,112 @@ package server
 
 import (
 	"io"
+	"net/http"
+	"os"
 
 	db "private-sphinx-docs/services/database"
+	"private-sphinx-docs/services/search"
+	"private-sphinx-docs/services/staticfiles"
+	"private-sphinx-docs/services/uploads"
 )
 
 type IStore interface {
 	FetchAccount(username string) (*db.Account, error)
+	FetchAccountById(id int) (*db.Account, error)
 	FetchAccounts() ([]*db.Account, error)
 	CreateAccount(username, password string, isAdmin bool) (*db.Account, error)
 	UpdateAccount(account *db.Account) (*db.Account, error)
+	SetAccountAdmin(id int, isAdmin bool) error
 	DeleteAccount(username string) error
 
+	FetchProject(title string) (*db.Project, error)
 	FetchProjects() ([]*db.Project, error)
 	FetchProjectsByAccount(accountId int) ([]*db.Project, error)
+	QueryProjects(filter db.ProjectFilter) (*db.ProjectPage, error)
+	FetchProjectTags() ([]string, error)
+	FetchProjectOwners() ([]*db.Account, error)
 	CreateOrUpdateProject(accountId int, title string) (*db.Project, error)
+	UpdateProjectMetadata(project *db.Project) (*db.Project, error)
 	DeleteProject(title string) error
 	CanOwnProject(accountId int, title string) (bool, error)
+
+	FetchRevision(id int) (*db.Revision, error)
+	FetchRevisions(projectId int) ([]*db.Revision, error)
+	FetchLiveRevisions(projectIds []int) (map[int]*db.Revision, error)
+	CreateRevision(revision *db.Revision) (*db.Revision, error)
+	SetLiveRevision(projectId, revisionId int) error
+
+	SaveInventory(projectId int, version string, objects []*db.InventoryObject) (*db.Inventory, error)
+	FetchInventory(title, version string) (*db.Inventory, error)
+	FindInventoryObjects(filter db.XrefFilter) ([]*db.InventoryObject, error)
+
+	CreateAuditEvent(event *db.AuditEvent) (*db.AuditEvent, error)
+	FetchAuditEvents(filter db.AuditFilter) ([]*db.AuditEvent, error)
 }
 
 type IFileHandler interface {
-	// Decompresses the uplaoded zip file and saves it
-	Upload(r io.ReaderAt, name string, size int64) error
+	// Decompresses the uploaded archive (zip, tar, tar.gz or tar.zst) and saves it. Returns the
+	// statistics of the published files
+	Upload(r io.ReaderAt, name string, size int64) (*staticfiles.Stats, error)
+	// Creates a temporary file to buffer uploads
+	CreateTemp() (*os.File, error)
 	// Gets the destination path for the static files
 	Destination(name string) string
+	// Saves the uploaded artifact, returns the artifact key and its checksum
+	SaveArtifact(r io.ReaderAt, name string, size int64) (artifact, checksum string, err error)
+	// Replaces the project files with a previously saved artifact
+	Restore(name, artifact string) error
+	// Computes the sha256 checksum of every live file of the project keyed by its path
+	Manifest(name string) (map[string]string, error)
+	// Publishes the live files of the project with the deletions applied and the files in the
+	// (optional) archive added. Returns the artifact key, checksum and size of the new tree and
+	// the statistics of its files
+	Sync(name string, r io.ReaderAt, size int64, deletions []string) (artifact, checksum string, n int64, stats *staticfiles.Stats, err error)
 	// Remove the project files
 	Remove(name string) error
+	// Opens a file (or directory) of the live documentation of the project. path is slash
+	// separated and relative to the project root
+	Open(name, path string) (http.File, error)
+	// Opens the variant of a file of the live documentation precompressed with the content
+	// encoding (gzip or br) at publish time. Fails with os.ErrNotExist if there is none
+	OpenEncoded(name, path, encoding string) (http.File, error)
+	// Gets the sha256 checksum of a file of the live documentation of the project
+	Checksum(name, path string) (string, error)
+	// Describes a file (or directory) of the live documentation of the project
+	Stat(name, path string) (os.FileInfo, error)
+	// Lists a directory of the live documentation of the project sorted by name
+	List(name, path string) ([]os.FileInfo, error)
+	// Calls fn with every live file of the project, or every file of the saved artifact if one
+	// is given
+	Walk(name, artifact string, fn staticfiles.WalkFunc) error
+	// Local folder for working files
 	Source() string
 }
+
+type IUploadStore interface {
+	// Creates a new resumable upload of the given length
+	Create(accountId int, length int64, metadata map[string]string) (*uploads.Upload, error)
+	Fetch(id string) (*uploads.Upload, error)
+	// Appends a chunk at the given offset of the upload
+	Append(id string, offset int64, r io.Reader) (*uploads.Upload, error)
+	// Opens the assembled content of the upload
+	Open(id string) (*os.File, error)
+	Remove(id string) error
+}
+
+type ISearchIndex interface {
+	// Indexes the HTML pages of the project that changed. files are the checksums of the pages
+	// keyed by path, load reads the pages that need to be indexed
+	Update(project, version string, files map[string]string, load func(path string) (*search.Page, error)) (indexed, removed int, err error)
+	// Removes every page of the project from the index
+	Remove(project string) error
+	Search(query search.Query) (*search.Results, error)
+}
+
+type ISphinxIndex interface {
+	// Replaces the index of the project with its Sphinx searchindex.js. files are the files of the
+	// live documentation
+	Load(project, version string, index io.Reader, files map[string]string) error
+	Remove(project string) error
+	Search(query search.Query) (*search.SphinxResults, error)
+}
@@ -0,0 +1,207 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/go-chi/chi"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	db "private-sphinx-docs/services/database"
+	"private-sphinx-docs/services/intersphinx"
+	sf "private-sphinx-docs/services/staticfiles"
+)
+
+const (
+	// Intersphinx inventory of the Sphinx build
+	objectsInventory = "objects.inv"
+	// Version of the inventory endpoint standing for the latest published inventory
+	latestVersion = "latest"
+	maxXrefLimit  = 100
+)
+
+type InventoryHandler struct {
+	DB IStore
+}
+
+// An inventory object with a link to its documentation
+type xref struct {
+	Project     string `json:"project"`
+	Version     string `json:"version"`
+	Name        string `json:"name"`
+	Type        string `json:"type"`
+	DisplayName string `json:"displayName"`
+	URL         string `json:"url"`
+}
+
+// Looks up the objects with the name in the inventories of every project. The results can be
+// filtered with the type (e.g. py:method), project and version query parameters
+func (h *InventoryHandler) Lookup() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		values := r.URL.Query()
+		filter := db.XrefFilter{
+			Name:    values.Get("name"),
+			Type:    values.Get("type"),
+			Project: values.Get("project"),
+			Version: values.Get("version"),
+			Limit:   maxXrefLimit,
+		}
+		if filter.Name == "" {
+			BadRequest(w, errors.New("name of the object must be specified"))
+			return
+		}
+		if limit := values.Get("limit"); limit != "" {
+			n, err := strconv.Atoi(limit)
+			if err != nil || n <= 0 {
+				BadRequest(w, errors.Errorf("invalid limit '%s'", limit))
+				return
+			}
+			if n < maxXrefLimit {
+				filter.Limit = n
+			}
+		}
+
+		objects, err := h.DB.FindInventoryObjects(filter)
+		if err != nil {
+			BadRequest(w, err)
+			return
+		}
+
+		xrefs := make([]*xref, 0, len(objects))
+		for _, o := range objects {
+			object := toInventoryObject(o)
+			display := o.DisplayName
+			if display == "-" {
+				display = o.Name
+			}
+			xrefs = append(xrefs, &xref{
+				Project:     o.Project,
+				Version:     o.Version,
+				Name:        o.Name,
+				Type:        o.Type,
+				DisplayName: display,
+				URL:         projectURL(r, o.Project, object.Location()),
+			})
+		}
+		toJson(w, xrefs)
+	}
+}
+
+// Serves the objects.inv of a version of the project for intersphinx. The version "latest"
+// serves the inventory published last
+func (h *InventoryHandler) Download() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		version := chi.URLParam(r, "version")
+		if version == latestVersion {
+			version = ""
+		}
+
+		inv, err := h.DB.FetchInventory(chi.URLParam(r, "project"), version)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+
+		inventory := &intersphinx.Inventory{Project: inv.Project, Version: inv.Version}
+		for _, o := range inv.Objects {
+			inventory.Objects = append(inventory.Objects, toInventoryObject(o))
+		}
+
+		w.Header().Set("Content-Type", "application/octet-stream")
+		if err := inventory.Encode(w); err != nil {
+			log.Errorf("could not write inventory of '%s': %v", inv.Project, err)
+		}
+	}
+}
+
+func toInventoryObject(o *db.InventoryObject) *intersphinx.Object {
+	return &intersphinx.Object{
+		Name:        o.Name,
+		Type:        o.Type,
+		Priority:    o.Priority,
+		URI:         o.URI,
+		DisplayName: o.DisplayName,
+	}
+}
+
+// Saves the objects.inv of the live documentation of the project in the database
+func saveInventory(fs IFileHandler, store IStore, name string) error {
+	file, err := fs.Open(name, objectsInventory)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer func() { _ = file.Close() }()
+
+	inventory, err := intersphinx.Parse(io.Reader(file))
+	if err != nil {
+		return err
+	}
+
+	project, err := store.FetchProject(name)
+	if err != nil {
+		return err
+	}
+
+	objects := make([]*db.InventoryObject, 0, len(inventory.Objects))
+	for _, o := range inventory.Objects {
+		objects = append(objects, &db.InventoryObject{
+			Name:        o.Name,
+			Type:        o.Type,
+			Priority:    o.Priority,
+			URI:         o.URI,
+			DisplayName: o.DisplayName,
+		})
+	}
+
+	inv, err := store.SaveInventory(project.Id, inventory.Version, objects)
+	if err != nil {
+		return err
+	}
+	log.Debugf("saved %d inventory objects of '%s' version '%s'", len(objects), name, inv.Version)
+	return nil
+}
+
+// Saves the intersphinx inventory of a project whenever it is published. Errors are logged,
+// they never fail the upload. The inventories are removed with their project
+type inventoryFileHandler struct {
+	IFileHandler
+	store IStore
+}
+
+func (f *inventoryFileHandler) Upload(r io.ReaderAt, name string, size int64) (*sf.Stats, error) {
+	stats, err := f.IFileHandler.Upload(r, name, size)
+	if err != nil {
+		return nil, err
+	}
+	f.save(name)
+	return stats, nil
+}
+
+func (f *inventoryFileHandler) Restore(name, artifact string) error {
+	if err := f.IFileHandler.Restore(name, artifact); err != nil {
+		return err
+	}
+	f.save(name)
+	return nil
+}
+
+func (f *inventoryFileHandler) Sync(name string, r io.ReaderAt, size int64, deletions []string) (string, string, int64, *sf.Stats, error) {
+	artifact, checksum, n, stats, err := f.IFileHandler.Sync(name, r, size, deletions)
+	if err != nil {
+		return "", "", 0, nil, err
+	}
+	f.save(name)
+	return artifact, checksum, n, stats, nil
+}
+
+func (f *inventoryFileHandler) save(name string) {
+	if err := saveInventory(f.IFileHandler, f.store, name); err != nil {
+		log.Errorf("could not save the inventory of project '%s': %v", name, err)
+	}
+}
@@ -0,0 +1,85 @@
+package server_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	. "private-sphinx-docs/server"
+	"private-sphinx-docs/services/intersphinx"
+	sf "private-sphinx-docs/services/staticfiles"
+)
+
+func TestInventoryHandler(t *testing.T) {
+	assert := require.New(t)
+
+	fs, cleanup := NewTestFileSys(t, sf.FileSysOption{})
+	defer cleanup()
+	srv := NewTestServer(t, Option{FileHandler: fs})
+
+	get := func(url string, status int) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		srv.Handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, url, nil))
+		assert.Equal(status, w.Code, w.Body.String())
+		return w
+	}
+	publish := func(version string, objects ...*intersphinx.Object) {
+		var inv bytes.Buffer
+		assert.NoError((&intersphinx.Inventory{Project: "mypkg", Version: version, Objects: objects}).Encode(&inv))
+
+		srv.Publish("project1", map[string]string{"index.html": "docs", "objects.inv": inv.String()})
+	}
+
+	client := &intersphinx.Object{Name: "mypkg.Client", Type: "py:class", Priority: 1, URI: "api.html#$", DisplayName: "-"}
+	send := &intersphinx.Object{Name: "mypkg.Client.send", Type: "py:method", Priority: 1, URI: "api.html#$", DisplayName: "Client.send"}
+	publish("1.0", client)
+	publish("1.1", client, send)
+
+	type xref struct {
+		Project     string `json:"project"`
+		Version     string `json:"version"`
+		Name        string `json:"name"`
+		Type        string `json:"type"`
+		DisplayName string `json:"displayName"`
+		URL         string `json:"url"`
+	}
+	lookup := func(params string) []xref {
+		var xrefs []xref
+		assert.NoError(json.NewDecoder(get("http://localhost/api/xref?"+params, http.StatusOK).Body).Decode(&xrefs))
+		return xrefs
+	}
+
+	assert.Equal([]xref{{
+		Project:     "project1",
+		Version:     "1.1",
+		Name:        "mypkg.Client.send",
+		Type:        "py:method",
+		DisplayName: "Client.send",
+		URL:         "http://project1.localhost/api.html#mypkg.Client.send",
+	}}, lookup("name=mypkg.Client.send"))
+
+	// every version is kept, latest first
+	xrefs := lookup("name=mypkg.Client")
+	assert.Len(xrefs, 2)
+	assert.Equal([]string{"1.1", "1.0"}, []string{xrefs[0].Version, xrefs[1].Version})
+	assert.Equal("mypkg.Client", xrefs[0].DisplayName)
+	assert.Len(lookup("name=mypkg.Client&version=1.0"), 1)
+	assert.Len(lookup("name=mypkg.Client&limit=1"), 1)
+	assert.Empty(lookup("name=mypkg.Client&type=py:method"))
+	get("http://localhost/api/xref", http.StatusBadRequest)
+
+	// inventories are served for intersphinx
+	for version, expected := range map[string]int{"1.0": 1, "1.1": 2, "latest": 2} {
+		w := get("http://localhost/inventory/project1/"+version+"/objects.inv", http.StatusOK)
+		inv, err := intersphinx.Parse(w.Body)
+		assert.NoError(err)
+		assert.Equal("project1", inv.Project)
+		assert.Len(inv.Objects, expected)
+	}
+	get("http://localhost/inventory/project1/2.0/objects.inv", http.StatusNotFound)
+	get("http://localhost/inventory/unknown/latest/objects.inv", http.StatusNotFound)
+}
@@ -0,0 +1,139 @@
+package server
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"private-sphinx-docs/server/dto"
+	db "private-sphinx-docs/services/database"
+)
+
+const maxProjectLimit = 500
+
+// Values of the sort parameter of the project listings
+var projectSorts = map[string]db.ProjectSort{
+	"title":   db.SortByTitle,
+	"updated": db.SortByLastUpdate,
+}
+
+// Position of a page in a project listing. The cursor is the sort key of the last project of
+// the previous page, it stays valid when projects are added or removed in between
+type projectCursor struct {
+	Sort       db.ProjectSort `json:"s"`
+	Descending bool           `json:"d,omitempty"`
+	Title      string         `json:"t,omitempty"`
+	LastUpdate time.Time      `json:"u"`
+	Id         int            `json:"i"`
+}
+
+func encodeCursor(filter db.ProjectFilter, last *db.Project) string {
+	cursor := &projectCursor{Sort: filter.Sort, Descending: filter.Descending, Id: last.Id}
+	if filter.Sort == db.SortByLastUpdate {
+		cursor.LastUpdate = last.LastUpdate
+	} else {
+		cursor.Title = last.Title
+	}
+	b, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeCursor(filter db.ProjectFilter, value string) (*db.Project, error) {
+	var cursor projectCursor
+	b, err := base64.RawURLEncoding.DecodeString(value)
+	if err == nil {
+		err = json.Unmarshal(b, &cursor)
+	}
+	if err != nil {
+		return nil, errors.New("invalid cursor")
+	}
+	if cursor.Sort != filter.Sort || cursor.Descending != filter.Descending {
+		return nil, errors.New("cursor does not match the sort order")
+	}
+	return &db.Project{Id: cursor.Id, Title: cursor.Title, LastUpdate: cursor.LastUpdate}, nil
+}
+
+// Reads the filters, sort order and page of a project listing from the query
+func (h *ProjectHandler) parseProjectFilter(r *http.Request) (db.ProjectFilter, error) {
+	query := r.URL.Query()
+	filter := db.ProjectFilter{
+		Tags:        dto.NormalizeTags(query["tag"]),
+		TitlePrefix: strings.TrimSpace(query.Get("prefix")),
+		Sort:        db.SortByTitle,
+	}
+
+	if owner := strings.TrimSpace(query.Get("owner")); owner != "" {
+		acc, err := h.DB.FetchAccount(owner)
+		if err != nil {
+			return filter, err
+		}
+		filter.AccountId = acc.Id
+	}
+
+	if value := strings.TrimSpace(query.Get("updated_since")); value != "" {
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return filter, errors.Wrap(err, "'updated_since' must be an RFC3339 timestamp")
+		}
+		filter.UpdatedSince = t
+	}
+
+	if value := strings.TrimSpace(query.Get("sort")); value != "" {
+		sort, ok := projectSorts[value]
+		if !ok {
+			return filter, errors.Errorf("cannot sort projects by '%s', use 'title' or 'updated'", value)
+		}
+		filter.Sort = sort
+	}
+	// most recently updated projects come first unless asked otherwise
+	filter.Descending = filter.Sort == db.SortByLastUpdate
+	switch order := strings.TrimSpace(query.Get("order")); order {
+	case "":
+	case "asc":
+		filter.Descending = false
+	case "desc":
+		filter.Descending = true
+	default:
+		return filter, errors.Errorf("invalid order '%s', use 'asc' or 'desc'", order)
+	}
+
+	if value := strings.TrimSpace(query.Get("limit")); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit <= 0 {
+			return filter, errors.Errorf("invalid limit '%s'", value)
+		}
+		filter.Limit = limit
+		if limit > maxProjectLimit {
+			filter.Limit = maxProjectLimit
+		}
+	}
+
+	if value := strings.TrimSpace(query.Get("cursor")); value != "" {
+		after, err := decodeCursor(filter, value)
+		if err != nil {
+			return filter, err
+		}
+		filter.After = after
+	}
+
+	return filter, nil
+}
+
+// Sets the X-Total-Count header and, if there may be more projects, the Link header with the
+// url of the next page
+func setPageHeaders(w http.ResponseWriter, r *http.Request, filter db.ProjectFilter, page *db.ProjectPage) {
+	w.Header().Set("X-Total-Count", strconv.Itoa(page.Total))
+	if filter.Limit == 0 || len(page.Projects) < filter.Limit {
+		return
+	}
+
+	query := r.URL.Query()
+	query.Set("cursor", encodeCursor(filter, page.Projects[len(page.Projects)-1]))
+	w.Header().Set("Link", fmt.Sprintf(`<%s?%s>; rel="next"`, r.URL.Path, query.Encode()))
+}
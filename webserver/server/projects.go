@@ -1,50 +1,123 @@
 package server
 
 import (
+	"fmt"
+	"io"
+	"mime"
 	"net/http"
+	"os"
+	"strconv"
 	"strings"
 
 	"github.com/go-chi/chi"
 	"github.com/pkg/errors"
 
+	"private-sphinx-docs/server/dto"
 	db "private-sphinx-docs/services/database"
+	"private-sphinx-docs/services/search"
+	sf "private-sphinx-docs/services/staticfiles"
 )
 
 type ProjectHandler struct {
 	DB IStore
 	FS IFileHandler
+	// Staging area of resumable uploads
+	Uploads IUploadStore
+	// Maximum size of an uploaded artifact in bytes. 0 means there is no limit
+	MaxUploadSize int64
+}
+
+// Content types accepted by StreamProject
+var streamContentTypes = map[string]bool{
+	"application/zip":              true,
+	"application/x-zip-compressed": true,
+	"application/x-tar":            true,
+	"application/gzip":             true,
+	"application/x-gzip":           true,
+	"application/zstd":             true,
+	"application/x-zstd":           true,
+	"application/octet-stream":     true,
 }
 
 type DeleteProjectPayload struct {
 	Title string `json:"title"`
 }
 
+// Lists the projects. The projects can be filtered by owner, tag, title prefix and last update,
+// and are returned in pages when a limit is given. The total count is in the X-Total-Count
+// header and the url of the next page in the Link header
 func (h *ProjectHandler) FetchProjects() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		username := strings.TrimSpace(chi.URLParam(r, "username"))
+		filter, err := h.parseProjectFilter(r)
+		if err != nil {
+			BadRequest(w, err)
+			return
+		}
 
-		if username == "" {
-			projects, err := h.DB.FetchProjects()
-			if err != nil {
-				BadRequest(w, err)
-				return
-			}
+		page, err := h.DB.QueryProjects(filter)
+		if err != nil {
+			BadRequest(w, err)
+			return
+		}
 
-			toJson(w, projects)
-		} else {
-			acc, err := h.DB.FetchAccount(username)
-			if err != nil {
-				BadRequest(w, err)
-				return
-			}
+		setPageHeaders(w, r, filter, page)
+		toJson(w, page.Projects)
+	}
+}
+
+// Details of a project with the statistics of its live revision
+type ProjectDetail struct {
+	*db.Project
+	Owner    string `json:"owner"`
+	Uploader string `json:"uploader"`
+	// Version of the live documentation
+	Version string `json:"version"`
+	// Every version uploaded, latest first
+	Versions  []string `json:"versions"`
+	Revisions int      `json:"revisions"`
+	FileCount int      `json:"fileCount"`
+	TotalSize int64    `json:"totalSize"`
+	HasIndex  bool     `json:"hasIndex"`
+}
+
+// Describes the project and its live documentation
+func (h *ProjectHandler) FetchProject() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		title := chi.URLParam(r, "title")
+		project, err := h.DB.FetchProject(title)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("project '%s' does not exist", title), http.StatusNotFound)
+			return
+		}
+
+		detail := &ProjectDetail{Project: project, Versions: []string{}}
+		if owner, err := h.DB.FetchAccountById(project.AccountId); err == nil {
+			detail.Owner = owner.Username
+		}
 
-			projects, err := h.DB.FetchProjectsByAccount(acc.Id)
-			if err != nil {
-				BadRequest(w, err)
-				return
+		revisions, err := h.DB.FetchRevisions(project.Id)
+		if err != nil {
+			BadRequest(w, err)
+			return
+		}
+		detail.Revisions = len(revisions)
+
+		seen := make(map[string]bool)
+		for _, rev := range revisions {
+			if rev.Version != "" && !seen[rev.Version] {
+				seen[rev.Version] = true
+				detail.Versions = append(detail.Versions, rev.Version)
+			}
+			if project.RevisionId != nil && *project.RevisionId == rev.Id {
+				detail.Uploader = rev.Uploader
+				detail.Version = rev.Version
+				detail.FileCount = rev.FileCount
+				detail.TotalSize = rev.TotalSize
+				detail.HasIndex = rev.HasIndex
 			}
-			toJson(w, projects)
 		}
+
+		toJson(w, detail)
 	}
 }
 
@@ -56,8 +129,19 @@ func (h *ProjectHandler) UploadProject() http.HandlerFunc {
 			return
 		}
 
+		if h.tooLarge(r.ContentLength) {
+			http.Error(w, h.tooLargeMessage(), http.StatusRequestEntityTooLarge)
+			return
+		}
+		if h.MaxUploadSize > 0 {
+			r.Body = http.MaxBytesReader(w, r.Body, h.MaxUploadSize)
+		}
+
 		err = r.ParseMultipartForm(10 << 20)
-		if err != nil {
+		if bodyTooLarge(err) {
+			http.Error(w, h.tooLargeMessage(), http.StatusRequestEntityTooLarge)
+			return
+		} else if err != nil {
 			http.Error(w, err.Error(), 400)
 			return
 		}
@@ -69,9 +153,8 @@ func (h *ProjectHandler) UploadProject() http.HandlerFunc {
 			return
 		}
 
-		// save details in database
-		project, err := h.DB.CreateOrUpdateProject(account.Id, title)
-		if err != nil {
+		metadata := dto.NewProjectMetadata(r.MultipartForm.Value)
+		if err := metadata.Validate(); err != nil {
 			http.Error(w, err.Error(), 400)
 			return
 		}
@@ -84,7 +167,7 @@ func (h *ProjectHandler) UploadProject() http.HandlerFunc {
 		}
 		defer func() { _ = file.Close() }()
 
-		err = h.FS.Upload(file, title, header.Size)
+		project, err := h.publish(r, account, title, metadata, file, header.Size)
 		if err != nil {
 			http.Error(w, err.Error(), 400)
 			return
@@ -94,7 +177,10 @@ func (h *ProjectHandler) UploadProject() http.HandlerFunc {
 	}
 }
 
-func (h *ProjectHandler) DeleteProject() http.HandlerFunc {
+// Uploads a new project (or replaces it) with the artifact sent as the raw request body. The
+// body is streamed into a temporary file instead of being parsed as a multipart form. The
+// project metadata can be given in the query. A JSON body only updates the metadata
+func (h *ProjectHandler) StreamProject() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		account, err := authenticate(h.DB, r)
 		if err != nil {
@@ -109,22 +195,290 @@ func (h *ProjectHandler) DeleteProject() http.HandlerFunc {
 			return
 		}
 
-		err = h.DB.DeleteProject(title)
+		contentType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if contentType == "application/json" {
+			h.updateMetadata(w, r, account, title)
+			return
+		}
+
+		// reject early before anything is read
+		if !streamContentTypes[contentType] {
+			http.Error(w, fmt.Sprintf("unsupported content type '%s'", contentType), http.StatusUnsupportedMediaType)
+			return
+		}
+		if h.tooLarge(r.ContentLength) {
+			http.Error(w, h.tooLargeMessage(), http.StatusRequestEntityTooLarge)
+			return
+		}
+		metadata := dto.NewProjectMetadata(r.URL.Query())
+		if err := metadata.Validate(); err != nil {
+			BadRequest(w, err)
+			return
+		}
+
+		file, err := h.FS.CreateTemp()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer func() {
+			_ = file.Close()
+			_ = os.Remove(file.Name())
+		}()
+
+		body := r.Body
+		if h.MaxUploadSize > 0 {
+			body = http.MaxBytesReader(w, r.Body, h.MaxUploadSize)
+		}
+		size, err := io.Copy(file, body)
+		if bodyTooLarge(err) {
+			http.Error(w, h.tooLargeMessage(), http.StatusRequestEntityTooLarge)
+			return
+		} else if err != nil {
+			BadRequest(w, errors.Wrap(err, "could not read request body"))
+			return
+		}
+
+		project, err := h.publish(r, account, title, metadata, file, size)
 		if err != nil {
 			BadRequest(w, err)
 			return
 		}
 
-		err = h.FS.Remove(title)
+		toJson(w, project)
+	}
+}
+
+// Updates the metadata of an existing project from the JSON body. Fields left out are unchanged
+func (h *ProjectHandler) updateMetadata(w http.ResponseWriter, r *http.Request, account *db.Account, title string) {
+	project, err := h.DB.FetchProject(title)
+	if err != nil {
+		BadRequest(w, err)
+		return
+	}
+
+	var metadata dto.ProjectMetadata
+	if err := readJson(r, &metadata); err != nil {
+		BadRequest(w, err)
+		return
+	}
+	updated := *project
+	metadata.Apply(&updated)
+
+	if _, err := h.DB.UpdateProjectMetadata(&updated); err != nil {
+		BadRequest(w, err)
+		return
+	}
+	recordAudit(h.DB, r, account, AuditProjectUpdate, title, project, &updated)
+
+	toJson(w, &updated)
+}
+
+func (h *ProjectHandler) tooLarge(size int64) bool {
+	return h.MaxUploadSize > 0 && size > h.MaxUploadSize
+}
+
+func (h *ProjectHandler) tooLargeMessage() string {
+	return fmt.Sprintf("uploaded artifact exceeds the maximum size of %d bytes", h.MaxUploadSize)
+}
+
+// Checks if reading the request body failed because it was cut off at MaxUploadSize. The error
+// of http.MaxBytesReader has no type of its own, it is matched by its message
+func bodyTooLarge(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "http: request body too large")
+}
+
+// Publishes the uploaded artifact as a new revision of the project. The caller must have
+// checked that the account can manage the project.
+func (h *ProjectHandler) publish(r *http.Request, account *db.Account, title string, metadata *dto.ProjectMetadata, content io.ReaderAt, size int64) (*db.Project, error) {
+	return h.release(r, account, title, metadata, func() (*db.Revision, error) {
+		artifact, checksum, err := h.FS.SaveArtifact(content, title, size)
+		if err != nil {
+			return nil, err
+		}
+
+		stats, err := h.FS.Upload(content, title, size)
+		if err != nil {
+			return nil, err
+		}
+		return newRevision(artifact, checksum, size, stats), nil
+	})
+}
+
+// Records the files published by store as a new revision of the project. store returns the
+// artifact details of the revision. The metadata (if any) is saved with the project
+func (h *ProjectHandler) release(r *http.Request, account *db.Account, title string, metadata *dto.ProjectMetadata, store func() (*db.Revision, error)) (*db.Project, error) {
+	// existing project (if any) is only used for the audit trail
+	var previous *db.Project
+	if before, err := h.DB.FetchProject(title); err == nil {
+		copied := *before
+		previous = &copied
+	}
+
+	// save details in database
+	project, err := h.DB.CreateOrUpdateProject(account.Id, title)
+	if err != nil {
+		return nil, err
+	}
+	if !metadata.Empty() {
+		metadata.Apply(project)
+		if project, err = h.DB.UpdateProjectMetadata(project); err != nil {
+			return nil, err
+		}
+	}
+
+	revision, err := store()
+	if err != nil {
+		return nil, err
+	}
+	revision.Version = liveVersion(h.FS, title)
+
+	revision.ProjectId = project.Id
+	revision.AccountId = &account.Id
+	revision, err = h.DB.CreateRevision(revision)
+	if err != nil {
+		return nil, err
+	}
+	project.RevisionId = &revision.Id
+	reindex(h.FS, title)
+
+	recordAudit(h.DB, r, account, AuditProjectUpload, title, previous, project)
+	return project, nil
+}
+
+// Describes the saved artifact and the statistics of the files published from it
+func newRevision(artifact, checksum string, size int64, stats *sf.Stats) *db.Revision {
+	return &db.Revision{
+		Size:      size,
+		Checksum:  checksum,
+		Artifact:  artifact,
+		FileCount: stats.Files,
+		TotalSize: stats.Size,
+		HasIndex:  stats.HasIndex,
+	}
+}
+
+func (h *ProjectHandler) DeleteProject() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		account, err := authenticate(h.DB, r)
+		if err != nil {
+			Forbid(w, r)
+			return
+		}
+
+		title := chi.URLParam(r, "title")
+		err = h.canManageProject(account, title)
+		if err != nil {
+			Forbid(w, r)
+			return
+		}
+
+		project, err := h.deleteProject(title)
 		if err != nil {
 			BadRequest(w, err)
 			return
 		}
+		recordAudit(h.DB, r, account, AuditProjectDelete, title, project, nil)
 
 		Ok(w, r)
 	}
 }
 
+// Removes the project and its documentation. Returns the deleted project
+func (h *ProjectHandler) deleteProject(title string) (*db.Project, error) {
+	project, err := h.DB.FetchProject(title)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := h.DB.DeleteProject(title); err != nil {
+		return nil, err
+	}
+	if err := h.FS.Remove(title); err != nil {
+		return nil, err
+	}
+	return project, nil
+}
+
+// Lists all uploaded revisions of the project, latest revision first
+func (h *ProjectHandler) FetchRevisions() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		project, err := h.DB.FetchProject(chi.URLParam(r, "title"))
+		if err != nil {
+			BadRequest(w, err)
+			return
+		}
+
+		revisions, err := h.DB.FetchRevisions(project.Id)
+		if err != nil {
+			BadRequest(w, err)
+			return
+		}
+
+		for _, rev := range revisions {
+			rev.Live = project.RevisionId != nil && *project.RevisionId == rev.Id
+		}
+		toJson(w, revisions)
+	}
+}
+
+// Restores the live documentation of the project to an earlier revision
+func (h *ProjectHandler) RollbackProject() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		account, err := authenticate(h.DB, r)
+		if err != nil {
+			Forbid(w, r)
+			return
+		}
+
+		title := chi.URLParam(r, "title")
+		err = h.canManageProject(account, title)
+		if err != nil {
+			Forbid(w, r)
+			return
+		}
+
+		project, err := h.DB.FetchProject(title)
+		if err != nil {
+			BadRequest(w, err)
+			return
+		}
+		previous := *project
+
+		id, err := strconv.Atoi(chi.URLParam(r, "id"))
+		if err != nil {
+			BadRequest(w, errors.Wrap(err, "invalid revision id"))
+			return
+		}
+
+		revision, err := h.DB.FetchRevision(id)
+		if err != nil {
+			BadRequest(w, err)
+			return
+		} else if revision.ProjectId != project.Id {
+			BadRequest(w, errors.Errorf("revision %d does not belong to project %s", id, title))
+			return
+		}
+
+		err = h.FS.Restore(title, revision.Artifact)
+		if err != nil {
+			BadRequest(w, err)
+			return
+		}
+
+		err = h.DB.SetLiveRevision(project.Id, revision.Id)
+		if err != nil {
+			BadRequest(w, err)
+			return
+		}
+		project.RevisionId = &revision.Id
+		reindex(h.FS, title)
+		recordAudit(h.DB, r, account, AuditProjectRollback, title, &previous, project)
+
+		toJson(w, project)
+	}
+}
+
 // check if the user can create, update or delete project
 func (h *ProjectHandler) canManageProject(account *db.Account, title string) error {
 	if account.IsAdmin {
@@ -140,3 +494,13 @@ func (h *ProjectHandler) canManageProject(account *db.Account, title string) err
 	}
 	return nil
 }
+
+// Version of the live documentation, empty if it is not a Sphinx build
+func liveVersion(fs IFileHandler, name string) string {
+	file, err := fs.Open(name, documentationOptions)
+	if err != nil {
+		return ""
+	}
+	defer func() { _ = file.Close() }()
+	return search.DetectVersion(file)
+}
@@ -8,12 +8,16 @@ import (
 	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 
 	. "private-sphinx-docs/server"
 	db "private-sphinx-docs/services/database"
+	sf "private-sphinx-docs/services/staticfiles"
 )
 
 func NewProjectHandler() *ProjectHandler {
@@ -42,9 +46,7 @@ func TestProjectHandler_FetchProjects(t *testing.T) {
 		{"user1", 1, http.StatusOK},
 		{"user2", 0, http.StatusBadRequest},
 	} {
-		r := NewTestRequest("DELETE", "/", nil, map[string]string{
-			"username": s.Username,
-		})
+		r := NewTestRequest("GET", "/?owner="+s.Username, nil, nil)
 
 		w := httptest.NewRecorder()
 		handler.FetchProjects()(w, r)
@@ -61,6 +63,87 @@ func TestProjectHandler_FetchProjects(t *testing.T) {
 	}
 }
 
+func TestProjectHandler_FetchProjectsPage(t *testing.T) {
+	t.Parallel()
+	assert := require.New(t)
+	handler := NewProjectHandler()
+
+	user, err := handler.DB.CreateAccount("user1", "password", false)
+	assert.NoError(err)
+	for i, title := range []string{"gamma", "alpha", "beta"} {
+		p, err := handler.DB.CreateOrUpdateProject(user.Id, title)
+		assert.NoError(err)
+		p.LastUpdate = time.Date(2021, 1, 3-i, 0, 0, 0, 0, time.UTC)
+	}
+
+	fetch := func(target string) ([]string, string, *httptest.ResponseRecorder) {
+		w := httptest.NewRecorder()
+		handler.FetchProjects()(w, NewTestRequest("GET", target, nil, nil))
+		if w.Code != http.StatusOK {
+			return nil, "", w
+		}
+
+		var projects []*db.Project
+		assert.NoError(json.NewDecoder(w.Result().Body).Decode(&projects))
+		titles := []string{}
+		for _, p := range projects {
+			titles = append(titles, p.Title)
+		}
+
+		next := ""
+		if link := w.Header().Get("Link"); link != "" {
+			assert.True(strings.HasSuffix(link, `>; rel="next"`), link)
+			next = strings.TrimSuffix(strings.TrimPrefix(link, "<"), `>; rel="next"`)
+		}
+		return titles, next, w
+	}
+
+	// follows the cursors to the last page
+	titles, next, w := fetch("/?limit=2")
+	assert.Equal([]string{"alpha", "beta"}, titles)
+	assert.Equal("4", w.Header().Get("X-Total-Count"))
+	titles, next, _ = fetch(next)
+	assert.Equal([]string{"gamma", "project1"}, titles)
+	titles, next, w = fetch(next)
+	assert.Empty(titles)
+	assert.Empty(next)
+	assert.Equal("4", w.Header().Get("X-Total-Count"))
+
+	titles, next, _ = fetch("/?sort=updated&limit=3")
+	assert.Equal([]string{"gamma", "alpha", "beta"}, titles)
+	titles, _, _ = fetch(next)
+	assert.Equal([]string{"project1"}, titles)
+
+	for _, s := range []struct {
+		Query  string
+		Titles []string
+	}{
+		{"?sort=updated&order=asc", []string{"project1", "beta", "alpha", "gamma"}},
+		{"?order=desc", []string{"project1", "gamma", "beta", "alpha"}},
+		{"?owner=user1&prefix=G", []string{"gamma"}},
+		{"?prefix=project", []string{"project1"}},
+		{"?updated_since=2021-01-02T00:00:00Z", []string{"alpha", "gamma"}},
+	} {
+		titles, next, _ := fetch("/" + s.Query)
+		assert.Equal(s.Titles, titles, s.Query)
+		assert.Empty(next)
+	}
+
+	_, titleCursor, _ := fetch("/?limit=1")
+	for _, query := range []string{
+		"?sort=size",
+		"?order=up",
+		"?limit=0",
+		"?cursor=garbage",
+		"?updated_since=2021-01-02",
+		"?owner=unknown",
+		"?sort=updated&" + titleCursor[strings.Index(titleCursor, "cursor="):],
+	} {
+		_, _, w := fetch("/" + query)
+		assert.Equal(http.StatusBadRequest, w.Code, query)
+	}
+}
+
 func TestProjectHandler_UploadProject(t *testing.T) {
 	t.Parallel()
 	assert := require.New(t)
@@ -102,6 +185,19 @@ func TestProjectHandler_UploadProject(t *testing.T) {
 			assert.IsType(&db.Project{}, project)
 		}
 	}
+
+	// body size is also enforced when the content length is not known up front
+	handler.MaxUploadSize = 10
+	body, contentType, err := createUploadPackagePayload("NewProject")
+	assert.NoError(err)
+	r := NewTestRequest("POST", "/", body, nil)
+	r.ContentLength = -1
+	r.Header.Set("Content-Type", contentType)
+	r.SetBasicAuth("user1", "password")
+	w := httptest.NewRecorder()
+
+	handler.UploadProject()(w, r)
+	assert.Equal(http.StatusRequestEntityTooLarge, w.Code, w.Body.String())
 }
 
 func createUploadPackagePayload(title string) (io.ReadWriter, string, error) {
@@ -161,3 +257,252 @@ func TestProjectHandler_DeleteProject(t *testing.T) {
 		assert.Equal(s.StatusCode, w.Code)
 	}
 }
+
+func TestProjectHandler_RollbackProject(t *testing.T) {
+	t.Parallel()
+	assert := require.New(t)
+	handler := NewProjectHandler()
+
+	user, err := handler.DB.CreateAccount("user1", "password", false)
+	assert.NoError(err)
+
+	// publish 2 revisions of the project
+	for i := 0; i < 2; i++ {
+		body, contentType, err := createUploadPackagePayload("project2")
+		assert.NoError(err)
+
+		r := NewTestRequest("POST", "/", body, nil)
+		r.Header.Set("Content-Type", contentType)
+		r.SetBasicAuth(user.Username, "password")
+		w := httptest.NewRecorder()
+		handler.UploadProject()(w, r)
+		assert.Equal(http.StatusOK, w.Code)
+	}
+
+	fetchRevisions := func(title string) []*db.Revision {
+		r := NewTestRequest("GET", "/", nil, map[string]string{"title": title})
+		w := httptest.NewRecorder()
+		handler.FetchRevisions()(w, r)
+		assert.Equal(http.StatusOK, w.Code)
+
+		var revisions []*db.Revision
+		assert.NoError(json.NewDecoder(w.Result().Body).Decode(&revisions))
+		return revisions
+	}
+
+	revisions := fetchRevisions("project2")
+	assert.Len(revisions, 2)
+	assert.True(revisions[0].Live)
+	assert.False(revisions[1].Live)
+	assert.Equal(user.Username, revisions[0].Uploader)
+
+	for _, s := range []struct {
+		Username   string
+		Title      string
+		Id         string
+		StatusCode int
+	}{
+		{"user1", "project2", strconv.Itoa(revisions[1].Id), http.StatusOK},
+		{"user1", "project1", strconv.Itoa(revisions[1].Id), http.StatusForbidden},
+		{"admin", "project1", strconv.Itoa(revisions[1].Id), http.StatusBadRequest},
+		{"user1", "project2", "999", http.StatusBadRequest},
+		{"user1", "project2", "abc", http.StatusBadRequest},
+	} {
+		r := NewTestRequest("POST", "/", nil, map[string]string{
+			"title": s.Title,
+			"id":    s.Id,
+		})
+		r.SetBasicAuth(s.Username, "password")
+		w := httptest.NewRecorder()
+
+		handler.RollbackProject()(w, r)
+		assert.Equal(s.StatusCode, w.Code)
+	}
+
+	revisions = fetchRevisions("project2")
+	assert.False(revisions[0].Live)
+	assert.True(revisions[1].Live)
+}
+
+func TestProjectHandler_StreamProject(t *testing.T) {
+	t.Parallel()
+	assert := require.New(t)
+	handler := NewProjectHandler()
+	handler.MaxUploadSize = 10
+
+	_, err := handler.DB.CreateAccount("user1", "password", false)
+	assert.NoError(err)
+
+	for _, s := range []struct {
+		Username    string
+		Title       string
+		ContentType string
+		Body        string
+		StatusCode  int
+	}{
+		{"user1", "NewProject", "application/zip", "content", http.StatusOK},
+		{"user1", "project1", "application/zip", "content", http.StatusForbidden},
+		{"user1", "NewProject", "text/plain", "content", http.StatusUnsupportedMediaType},
+		{"user1", "NewProject", "application/zip", "content too large", http.StatusRequestEntityTooLarge},
+	} {
+		r := NewTestRequest("PUT", "/", bytes.NewBufferString(s.Body), map[string]string{
+			"title": s.Title,
+		})
+		r.Header.Set("Content-Type", s.ContentType)
+		r.SetBasicAuth(s.Username, "password")
+		w := httptest.NewRecorder()
+
+		handler.StreamProject()(w, r)
+		assert.Equal(s.StatusCode, w.Code)
+
+		if s.StatusCode == http.StatusOK {
+			var project *db.Project
+			assert.NoError(json.NewDecoder(w.Result().Body).Decode(&project))
+			assert.Equal(s.Title, project.Title)
+			assert.NotNil(project.RevisionId)
+		}
+	}
+
+	// body size is also enforced when the content length is not known up front
+	r := NewTestRequest("PUT", "/", bytes.NewBufferString("content too large"), map[string]string{
+		"title": "NewProject",
+	})
+	r.ContentLength = -1
+	r.Header.Set("Content-Type", "application/zip")
+	r.SetBasicAuth("user1", "password")
+	w := httptest.NewRecorder()
+
+	handler.StreamProject()(w, r)
+	assert.Equal(http.StatusRequestEntityTooLarge, w.Code)
+}
+
+func TestProjectHandler_ProjectMetadata(t *testing.T) {
+	t.Parallel()
+	assert := require.New(t)
+	handler := NewProjectHandler()
+
+	_, err := handler.DB.CreateAccount("user1", "password", false)
+	assert.NoError(err)
+
+	put := func(target, contentType, body string) (*db.Project, int) {
+		r := NewTestRequest("PUT", target, bytes.NewBufferString(body), map[string]string{
+			"title": "NewProject",
+		})
+		r.Header.Set("Content-Type", contentType)
+		r.SetBasicAuth("user1", "password")
+		w := httptest.NewRecorder()
+
+		handler.StreamProject()(w, r)
+		var project *db.Project
+		if w.Code == http.StatusOK {
+			assert.NoError(json.NewDecoder(w.Result().Body).Decode(&project))
+		}
+		return project, w.Code
+	}
+
+	// metadata of the project cannot be updated before it is uploaded
+	_, code := put("/", "application/json", `{"description": "Docs"}`)
+	assert.Equal(http.StatusBadRequest, code)
+
+	_, code = put("/?repository_url=ftp://example.com", "application/zip", "content")
+	assert.Equal(http.StatusBadRequest, code)
+
+	project, code := put("/?tags=Python,docs,python&repository_url=https://example.com/repo", "application/zip", "content")
+	assert.Equal(http.StatusOK, code)
+	assert.EqualValues([]string{"python", "docs"}, project.Tags)
+	assert.Equal("https://example.com/repo", project.RepositoryURL)
+
+	// fields left out are unchanged
+	project, code = put("/", "application/json", `{"description": "# Docs", "contacts": ["jane@example.com"]}`)
+	assert.Equal(http.StatusOK, code)
+	assert.Equal("# Docs", project.Description)
+	assert.EqualValues([]string{"jane@example.com"}, project.Contacts)
+	assert.EqualValues([]string{"python", "docs"}, project.Tags)
+	assert.Equal("https://example.com/repo", project.RepositoryURL)
+
+	_, code = put("/", "application/json", `{"tags": ["not a tag"]}`)
+	assert.Equal(http.StatusBadRequest, code)
+
+	for _, s := range []struct {
+		Query string
+		Count int
+	}{
+		{"", 2},
+		{"?tag=python", 1},
+		{"?tag=Python&tag=docs", 1},
+		{"?tag=python,go", 0},
+	} {
+		w := httptest.NewRecorder()
+		handler.FetchProjects()(w, NewTestRequest("GET", "/"+s.Query, nil, nil))
+		assert.Equal(http.StatusOK, w.Code)
+
+		var projects []*db.Project
+		assert.NoError(json.NewDecoder(w.Result().Body).Decode(&projects))
+		assert.Len(projects, s.Count, s.Query)
+	}
+}
+
+func TestProjectHandler_FetchProject(t *testing.T) {
+	assert := require.New(t)
+
+	fs, cleanup := NewTestFileSys(t, sf.FileSysOption{})
+	defer cleanup()
+	srv := NewTestServer(t, Option{FileHandler: fs})
+
+	get := func(name string, status int, v interface{}) {
+		w := httptest.NewRecorder()
+		srv.Handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://localhost/api/project/"+name, nil))
+		assert.Equal(status, w.Code, w.Body.String())
+		if v != nil {
+			assert.NoError(json.NewDecoder(w.Body).Decode(v))
+		}
+	}
+
+	// never published
+	var detail ProjectDetail
+	get("project1", http.StatusOK, &detail)
+	assert.Equal("project1", detail.Title)
+	assert.Equal("admin", detail.Owner)
+	assert.Empty(detail.Versions)
+	assert.Zero(detail.FileCount)
+
+	srv.Publish("project1", map[string]string{
+		"index.html":                       "<html></html>",
+		"_static/documentation_options.js": `var DOCUMENTATION_OPTIONS = { VERSION: '1.0' };`,
+	})
+	srv.Publish("project1", map[string]string{
+		"page.html":                        "<html></html>",
+		"_static/app.js":                   "app",
+		"_static/documentation_options.js": `var DOCUMENTATION_OPTIONS = { VERSION: '2.0' };`,
+	})
+
+	detail = ProjectDetail{}
+	get("project1", http.StatusOK, &detail)
+	assert.Equal("admin", detail.Uploader)
+	assert.Equal("2.0", detail.Version)
+	assert.Equal([]string{"2.0", "1.0"}, detail.Versions)
+	assert.Equal(2, detail.Revisions)
+	assert.Equal(3, detail.FileCount)
+	assert.EqualValues(13+3+47, detail.TotalSize)
+	assert.False(detail.HasIndex)
+
+	// the statistics follow the live revision
+	var revisions []*db.Revision
+	get("project1/revisions", http.StatusOK, &revisions)
+	assert.True(revisions[1].HasIndex)
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "http://localhost/api/project/project1/revisions/"+strconv.Itoa(revisions[1].Id)+"/rollback", nil)
+	r.SetBasicAuth("admin", "password")
+	srv.Handler.ServeHTTP(w, r)
+	assert.Equal(http.StatusOK, w.Code, w.Body.String())
+
+	detail = ProjectDetail{}
+	get("project1", http.StatusOK, &detail)
+	assert.Equal("1.0", detail.Version)
+	assert.Equal(2, detail.FileCount)
+	assert.True(detail.HasIndex)
+
+	// only projects are described
+	get("admin", http.StatusNotFound, nil)
+	get("unknown", http.StatusNotFound, nil)
+}
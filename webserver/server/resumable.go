@@ -0,0 +1,243 @@
+package server
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi"
+	"github.com/pkg/errors"
+
+	"private-sphinx-docs/server/dto"
+	db "private-sphinx-docs/services/database"
+	"private-sphinx-docs/services/uploads"
+)
+
+// Resumable uploads implement the core tus protocol (https://tus.io/protocols/resumable-upload.html)
+// with the creation, termination and expiration extensions. The project title is given in the
+// Upload-Metadata header when the upload is created. Once the last chunk is received, the
+// assembled artifact is published like any other upload.
+const (
+	tusVersion    = "1.0.0"
+	tusExtensions = "creation,termination,expiration"
+	tusExpires    = "Mon, 02 Jan 2006 15:04:05 GMT"
+)
+
+// Advertises the tus capabilities of the server
+func (h *ProjectHandler) TusOptions() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Tus-Resumable", tusVersion)
+		w.Header().Set("Tus-Version", tusVersion)
+		w.Header().Set("Tus-Extension", tusExtensions)
+		if h.MaxUploadSize > 0 {
+			w.Header().Set("Tus-Max-Size", strconv.FormatInt(h.MaxUploadSize, 10))
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// Creates a new resumable upload. The length of the artifact must be given in the Upload-Length
+// header and the project title in the Upload-Metadata header
+func (h *ProjectHandler) CreateUpload() http.HandlerFunc {
+	return h.tus(func(w http.ResponseWriter, r *http.Request, account *db.Account) {
+		metadata, err := parseTusMetadata(r.Header.Get("Upload-Metadata"))
+		if err != nil {
+			BadRequest(w, err)
+			return
+		}
+
+		title := strings.TrimSpace(metadata["title"])
+		if title == "" {
+			BadRequest(w, errors.New("project title must be given in the Upload-Metadata header"))
+			return
+		}
+		if err := h.canManageProject(account, title); err != nil {
+			Forbid(w, r)
+			return
+		}
+		if err := tusProjectMetadata(metadata).Validate(); err != nil {
+			BadRequest(w, err)
+			return
+		}
+
+		length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+		if err != nil || length <= 0 {
+			BadRequest(w, errors.New("Upload-Length header must be a positive integer"))
+			return
+		} else if h.tooLarge(length) {
+			http.Error(w, h.tooLargeMessage(), http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		upload, err := h.Uploads.Create(account.Id, length, metadata)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Location", strings.TrimSuffix(r.URL.Path, "/")+"/"+upload.Id)
+		w.Header().Set("Upload-Expires", upload.Expires.UTC().Format(tusExpires))
+		w.WriteHeader(http.StatusCreated)
+	})
+}
+
+// Returns the current offset of the upload so the client knows where to resume
+func (h *ProjectHandler) UploadOffset() http.HandlerFunc {
+	return h.tusUpload(func(w http.ResponseWriter, r *http.Request, account *db.Account, upload *uploads.Upload) {
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+		w.Header().Set("Upload-Length", strconv.FormatInt(upload.Length, 10))
+		w.Header().Set("Upload-Expires", upload.Expires.UTC().Format(tusExpires))
+		w.WriteHeader(http.StatusOK)
+	})
+}
+
+// Appends a chunk to the upload. The project is published once the last chunk is received
+func (h *ProjectHandler) AppendUpload() http.HandlerFunc {
+	return h.tusUpload(func(w http.ResponseWriter, r *http.Request, account *db.Account, upload *uploads.Upload) {
+		if r.Header.Get("Content-Type") != "application/offset+octet-stream" {
+			http.Error(w, "content type must be application/offset+octet-stream", http.StatusUnsupportedMediaType)
+			return
+		}
+
+		offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+		if err != nil || offset < 0 {
+			BadRequest(w, errors.New("Upload-Offset header must be a non-negative integer"))
+			return
+		}
+
+		upload, err = h.Uploads.Append(upload.Id, offset, r.Body)
+		switch {
+		case err == uploads.ErrOffsetMismatch:
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		case err == uploads.ErrNotFound:
+			// expired or terminated since it was fetched, the client has to start over
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		case err != nil:
+			BadRequest(w, err)
+			return
+		}
+
+		if upload.Complete() {
+			if err := h.publishUpload(r, account, upload); err != nil {
+				BadRequest(w, err)
+				return
+			}
+		}
+
+		w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+		w.Header().Set("Upload-Expires", upload.Expires.UTC().Format(tusExpires))
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// Cancels the upload and removes the chunks received so far
+func (h *ProjectHandler) TerminateUpload() http.HandlerFunc {
+	return h.tusUpload(func(w http.ResponseWriter, r *http.Request, account *db.Account, upload *uploads.Upload) {
+		if err := h.Uploads.Remove(upload.Id); err != nil {
+			BadRequest(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// Publishes the assembled artifact. The upload is removed whether or not it could be published
+// since the client cannot fix a bad artifact by resuming the upload.
+func (h *ProjectHandler) publishUpload(r *http.Request, account *db.Account, upload *uploads.Upload) error {
+	defer func() { _ = h.Uploads.Remove(upload.Id) }()
+
+	file, err := h.Uploads.Open(upload.Id)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = file.Close() }()
+
+	// permissions could have changed since the upload was created
+	title := upload.Metadata["title"]
+	if err := h.canManageProject(account, title); err != nil {
+		return err
+	}
+
+	_, err = h.publish(r, account, title, tusProjectMetadata(upload.Metadata), file, upload.Length)
+	return err
+}
+
+// Reads the project metadata (description, tags...) given with the upload
+func tusProjectMetadata(metadata map[string]string) *dto.ProjectMetadata {
+	values := make(url.Values, len(metadata))
+	for key, value := range metadata {
+		values.Set(key, value)
+	}
+	return dto.NewProjectMetadata(values)
+}
+
+// Authenticates the request and checks the tus version before calling fn
+func (h *ProjectHandler) tus(fn func(w http.ResponseWriter, r *http.Request, account *db.Account)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Tus-Resumable", tusVersion)
+		if r.Header.Get("Tus-Resumable") != tusVersion {
+			w.Header().Set("Tus-Version", tusVersion)
+			http.Error(w, "unsupported tus version", http.StatusPreconditionFailed)
+			return
+		}
+
+		account, err := authenticate(h.DB, r)
+		if err != nil {
+			Forbid(w, r)
+			return
+		}
+
+		fn(w, r, account)
+	}
+}
+
+// Like tus but also fetches the upload given in the url. Only the account that created the
+// upload (or an admin) can access it
+func (h *ProjectHandler) tusUpload(fn func(w http.ResponseWriter, r *http.Request, account *db.Account, upload *uploads.Upload)) http.HandlerFunc {
+	return h.tus(func(w http.ResponseWriter, r *http.Request, account *db.Account) {
+		upload, err := h.Uploads.Fetch(chi.URLParam(r, "id"))
+		if err == uploads.ErrNotFound {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		} else if err != nil {
+			BadRequest(w, err)
+			return
+		}
+
+		if !(account.IsAdmin || account.Id == upload.AccountId) {
+			Forbid(w, r)
+			return
+		}
+
+		fn(w, r, account, upload)
+	})
+}
+
+// Parses the Upload-Metadata header which consists of comma separated key value pairs. The
+// key and value are separated by a space and the value is base64 encoded
+func parseTusMetadata(header string) (map[string]string, error) {
+	metadata := make(map[string]string)
+	for _, pair := range strings.Split(header, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		parts := strings.SplitN(pair, " ", 2)
+		value := ""
+		if len(parts) == 2 {
+			decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(parts[1]))
+			if err != nil {
+				return nil, errors.Wrapf(err, "invalid Upload-Metadata value for key '%s'", parts[0])
+			}
+			value = string(decoded)
+		}
+		metadata[parts[0]] = value
+	}
+	return metadata, nil
+}
@@ -0,0 +1,187 @@
+package server_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	. "private-sphinx-docs/server"
+	"private-sphinx-docs/services/uploads"
+)
+
+func NewResumableHandler(t *testing.T) (*ProjectHandler, func()) {
+	folder, err := ioutil.TempDir("", "uploads-")
+	require.NoError(t, err)
+
+	store, err := uploads.New(folder, 0)
+	require.NoError(t, err)
+
+	handler := NewProjectHandler()
+	handler.Uploads = store
+	handler.MaxUploadSize = 100
+
+	return handler, func() { _ = os.RemoveAll(folder) }
+}
+
+func TestProjectHandler_ResumableUpload(t *testing.T) {
+	t.Parallel()
+	assert := require.New(t)
+	handler, cleanup := NewResumableHandler(t)
+	defer cleanup()
+
+	_, err := handler.DB.CreateAccount("user1", "password", false)
+	assert.NoError(err)
+
+	tusRequest := func(method, username string, body []byte, headers map[string]string, id string) *httptest.ResponseRecorder {
+		r := NewTestRequest(method, "/api/upload/", bytes.NewReader(body), map[string]string{"id": id})
+		r.SetBasicAuth(username, "password")
+		r.Header.Set("Tus-Resumable", "1.0.0")
+		for key, value := range headers {
+			r.Header.Set(key, value)
+		}
+		w := httptest.NewRecorder()
+
+		switch method {
+		case "POST":
+			handler.CreateUpload()(w, r)
+		case "HEAD":
+			handler.UploadOffset()(w, r)
+		case "PATCH":
+			handler.AppendUpload()(w, r)
+		case "DELETE":
+			handler.TerminateUpload()(w, r)
+		}
+		return w
+	}
+	metadata := func(title string) string {
+		return "title " + base64.StdEncoding.EncodeToString([]byte(title))
+	}
+
+	// creation
+	for _, s := range []struct {
+		Username   string
+		Metadata   string
+		Length     string
+		StatusCode int
+	}{
+		{"user1", metadata("project1"), "10", http.StatusForbidden},
+		{"user1", "", "10", http.StatusBadRequest},
+		{"user1", metadata("NewProject"), "", http.StatusBadRequest},
+		{"user1", metadata("NewProject"), "101", http.StatusRequestEntityTooLarge},
+		{"user1", metadata("NewProject"), "10", http.StatusCreated},
+	} {
+		w := tusRequest("POST", s.Username, nil, map[string]string{
+			"Upload-Metadata": s.Metadata,
+			"Upload-Length":   s.Length,
+		}, "")
+		assert.Equal(s.StatusCode, w.Code)
+	}
+
+	w := tusRequest("POST", "user1", nil, map[string]string{
+		"Upload-Metadata": metadata("NewProject"),
+		"Upload-Length":   "10",
+	}, "")
+	assert.Equal(http.StatusCreated, w.Code)
+	id := path.Base(w.Header().Get("Location"))
+
+	// chunks
+	chunk := map[string]string{"Content-Type": "application/offset+octet-stream", "Upload-Offset": "0"}
+	w = tusRequest("PATCH", "user1", []byte("01234"), chunk, id)
+	assert.Equal(http.StatusNoContent, w.Code)
+	assert.Equal("5", w.Header().Get("Upload-Offset"))
+
+	// resending from a stale offset is rejected
+	w = tusRequest("PATCH", "user1", []byte("01234"), chunk, id)
+	assert.Equal(http.StatusConflict, w.Code)
+
+	// only the creator or an admin can access the upload
+	_, err = handler.DB.CreateAccount("user2", "password", false)
+	assert.NoError(err)
+	w = tusRequest("HEAD", "user2", nil, nil, id)
+	assert.Equal(http.StatusForbidden, w.Code)
+
+	w = tusRequest("HEAD", "user1", nil, nil, id)
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Equal("5", w.Header().Get("Upload-Offset"))
+
+	// last chunk publishes the project and removes the upload
+	chunk["Upload-Offset"] = "5"
+	w = tusRequest("PATCH", "user1", []byte("56789"), chunk, id)
+	assert.Equal(http.StatusNoContent, w.Code)
+	assert.Equal("10", w.Header().Get("Upload-Offset"))
+
+	project, err := handler.DB.FetchProject("NewProject")
+	assert.NoError(err)
+	assert.NotNil(project.RevisionId)
+
+	w = tusRequest("HEAD", "user1", nil, nil, id)
+	assert.Equal(http.StatusNotFound, w.Code)
+}
+
+func TestProjectHandler_TerminateUpload(t *testing.T) {
+	t.Parallel()
+	assert := require.New(t)
+	handler, cleanup := NewResumableHandler(t)
+	defer cleanup()
+
+	upload, err := handler.Uploads.Create(1, 10, map[string]string{"title": "project1"})
+	assert.NoError(err)
+
+	for _, s := range []struct {
+		Version    string
+		StatusCode int
+	}{
+		{"0.2.0", http.StatusPreconditionFailed},
+		{"1.0.0", http.StatusNoContent},
+		{"1.0.0", http.StatusNotFound},
+	} {
+		r := NewTestRequest("DELETE", "/", nil, map[string]string{"id": upload.Id})
+		r.SetBasicAuth("admin", "password")
+		r.Header.Set("Tus-Resumable", s.Version)
+		w := httptest.NewRecorder()
+
+		handler.TerminateUpload()(w, r)
+		assert.Equal(s.StatusCode, w.Code)
+	}
+}
+
+// Removes the upload right before appending to it, as if it expired in between
+type expiringUploads struct {
+	IUploadStore
+}
+
+func (s *expiringUploads) Append(id string, offset int64, r io.Reader) (*uploads.Upload, error) {
+	if err := s.IUploadStore.Remove(id); err != nil {
+		return nil, err
+	}
+	return s.IUploadStore.Append(id, offset, r)
+}
+
+func TestProjectHandler_AppendExpiredUpload(t *testing.T) {
+	t.Parallel()
+	assert := require.New(t)
+	handler, cleanup := NewResumableHandler(t)
+	defer cleanup()
+
+	upload, err := handler.Uploads.Create(1, 10, map[string]string{"title": "project1"})
+	assert.NoError(err)
+	handler.Uploads = &expiringUploads{handler.Uploads}
+
+	r := NewTestRequest("PATCH", "/", bytes.NewReader([]byte("chunk")), map[string]string{"id": upload.Id})
+	r.SetBasicAuth("admin", "password")
+	r.Header.Set("Tus-Resumable", "1.0.0")
+	r.Header.Set("Content-Type", "application/offset+octet-stream")
+	r.Header.Set("Upload-Offset", "0")
+	w := httptest.NewRecorder()
+
+	handler.AppendUpload()(w, r)
+	assert.Equal(http.StatusNotFound, w.Code, w.Body.String())
+}
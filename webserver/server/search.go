@@ -0,0 +1,305 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"private-sphinx-docs/services/search"
+)
+
+const (
+	// File of the Sphinx build holding the version of the documentation
+	documentationOptions = "_static/documentation_options.js"
+	// Index Sphinx builds for its client side search
+	sphinxSearchIndex = "searchindex.js"
+)
+
+type SearchHandler struct {
+	Index  ISearchIndex
+	Sphinx ISphinxIndex
+}
+
+// Searches the text of every hosted page. The results can be filtered with the project,
+// version and phrase (the text is matched as a whole) query parameters and paged with limit
+// and offset
+func (h *SearchHandler) Search() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if h.Index == nil {
+			http.Error(w, "search is not enabled", http.StatusNotFound)
+			return
+		}
+
+		q, err := parseQuery(r)
+		if err != nil {
+			BadRequest(w, err)
+			return
+		}
+
+		results, err := h.Index.Search(q)
+		if err != nil {
+			BadRequest(w, err)
+			return
+		}
+		toJson(w, results)
+	}
+}
+
+// Serves the federated search of the searchindex.js files Sphinx builds with every project.
+// Takes the same query parameters as Search, except phrase. Hits link to the page on the
+// subdomain of its project
+func (h *SearchHandler) Federated() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if h.Sphinx == nil {
+			http.Error(w, "federated search is not enabled", http.StatusNotFound)
+			return
+		}
+
+		q, err := parseQuery(r)
+		if err != nil {
+			BadRequest(w, err)
+			return
+		}
+
+		results, err := h.Sphinx.Search(q)
+		if err != nil {
+			BadRequest(w, err)
+			return
+		}
+
+		for _, hit := range results.Hits {
+			location := hit.Path
+			if hit.Anchor != "" {
+				location += "#" + hit.Anchor
+			}
+			hit.URL = projectURL(r, hit.Project, location)
+		}
+		toJson(w, results)
+	}
+}
+
+func parseQuery(r *http.Request) (search.Query, error) {
+	values := r.URL.Query()
+	q := search.Query{
+		Text:    strings.TrimSpace(values.Get("q")),
+		Project: values.Get("project"),
+		Version: values.Get("version"),
+	}
+	if q.Text == "" {
+		return q, errors.New("search text (q) must be specified")
+	}
+
+	var err error
+	if phrase := values.Get("phrase"); phrase != "" {
+		if q.Phrase, err = strconv.ParseBool(phrase); err != nil {
+			return q, errors.Wrap(err, "invalid phrase")
+		}
+	}
+	for key, value := range map[string]*int{"limit": &q.Limit, "offset": &q.Offset} {
+		if v := values.Get(key); v != "" {
+			if *value, err = strconv.Atoi(v); err != nil || *value < 0 {
+				return q, errors.Errorf("invalid %s '%s'", key, v)
+			}
+		}
+	}
+	return q, nil
+}
+
+// Brings the search indices up to date with the live documentation of the project. Unchanged
+// pages are not indexed again
+func indexProject(fs IFileHandler, index ISearchIndex, sphinx ISphinxIndex, name string) error {
+	manifest, err := fs.Manifest(name)
+	if err != nil {
+		return err
+	}
+
+	version := ""
+	if file, err := fs.Open(name, documentationOptions); err == nil {
+		version = search.DetectVersion(file)
+		_ = file.Close()
+	}
+
+	if index != nil {
+		if err := indexPages(fs, index, name, version, manifest); err != nil {
+			return err
+		}
+	}
+	if sphinx != nil {
+		if err := loadSphinxIndex(fs, sphinx, name, version, manifest); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func indexPages(fs IFileHandler, index ISearchIndex, name, version string, manifest map[string]string) error {
+	pages := make(map[string]string)
+	for p, checksum := range manifest {
+		if ext := strings.ToLower(path.Ext(p)); ext == ".html" || ext == ".htm" {
+			pages[p] = checksum
+		}
+	}
+
+	indexed, removed, err := index.Update(name, version, pages, func(p string) (*search.Page, error) {
+		file, err := fs.Open(name, p)
+		if err != nil {
+			return nil, err
+		}
+		defer func() { _ = file.Close() }()
+
+		title, content, err := search.ParseHTML(io.Reader(file))
+		if err != nil {
+			return nil, err
+		}
+		return &search.Page{Title: title, Content: content}, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Debugf("indexed %d pages of '%s', removed %d", indexed, name, removed)
+	return nil
+}
+
+// Loads the searchindex.js of the project. Projects without one are dropped from the index
+func loadSphinxIndex(fs IFileHandler, sphinx ISphinxIndex, name, version string, manifest map[string]string) error {
+	if _, ok := manifest[sphinxSearchIndex]; !ok {
+		return sphinx.Remove(name)
+	}
+
+	file, err := fs.Open(name, sphinxSearchIndex)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = file.Close() }()
+
+	return sphinx.Load(name, version, file, manifest)
+}
+
+// Indexes the live documentation of every project. Pages that are already indexed are skipped
+func IndexProjects(store IStore, fs IFileHandler, index ISearchIndex, sphinx ISphinxIndex) {
+	projects, err := store.FetchProjects()
+	if err != nil {
+		log.Errorf("could not list projects to index: %v", err)
+		return
+	}
+
+	for _, project := range projects {
+		if err := indexProject(fs, index, sphinx, project.Title); err != nil {
+			log.Errorf("could not index project '%s': %v", project.Title, err)
+		}
+	}
+}
+
+// Updates the search indices whenever a project is published or removed. Projects are indexed
+// by a background worker so indexing never delays (or fails) the upload. Published projects
+// are queued by the handlers once their revision is committed (see reindex), removed projects
+// as soon as their files are gone. Either index can be nil
+type indexingFileHandler struct {
+	IFileHandler
+	index  ISearchIndex
+	sphinx ISphinxIndex
+
+	mu      sync.Mutex
+	pending []string        // projects waiting to be indexed, in the order they changed
+	removed map[string]bool // whether the pending project was removed, the latest change wins
+	wake    chan struct{}
+}
+
+func newIndexingFileHandler(fs IFileHandler, index ISearchIndex, sphinx ISphinxIndex) *indexingFileHandler {
+	f := &indexingFileHandler{
+		IFileHandler: fs,
+		index:        index,
+		sphinx:       sphinx,
+		removed:      make(map[string]bool),
+		wake:         make(chan struct{}, 1),
+	}
+	go f.run()
+	return f
+}
+
+func (f *indexingFileHandler) Remove(name string) error {
+	if err := f.IFileHandler.Remove(name); err != nil {
+		return err
+	}
+	f.enqueue(name, true)
+	return nil
+}
+
+// Queues the live documentation of the project for the search indices, if they are enabled.
+// Called once the revision of the published files is committed
+func reindex(fs IFileHandler, name string) {
+	if f, ok := fs.(*indexingFileHandler); ok {
+		f.enqueue(name, false)
+	}
+}
+
+// Queues the project for the worker. A project that is already pending keeps its place
+func (f *indexingFileHandler) enqueue(name string, removed bool) {
+	f.mu.Lock()
+	if _, ok := f.removed[name]; !ok {
+		f.pending = append(f.pending, name)
+	}
+	f.removed[name] = removed
+	f.mu.Unlock()
+
+	select {
+	case f.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (f *indexingFileHandler) next() (string, bool, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if len(f.pending) == 0 {
+		return "", false, false
+	}
+	name := f.pending[0]
+	f.pending = f.pending[1:]
+	removed := f.removed[name]
+	delete(f.removed, name)
+	return name, removed, true
+}
+
+func (f *indexingFileHandler) run() {
+	for range f.wake {
+		for {
+			name, removed, ok := f.next()
+			if !ok {
+				break
+			}
+			if removed {
+				f.remove(name)
+			} else {
+				f.update(name)
+			}
+		}
+	}
+}
+
+func (f *indexingFileHandler) update(name string) {
+	if err := indexProject(f.IFileHandler, f.index, f.sphinx, name); err != nil {
+		log.Errorf("could not index project '%s': %v", name, err)
+	}
+}
+
+func (f *indexingFileHandler) remove(name string) {
+	if f.index != nil {
+		if err := f.index.Remove(name); err != nil {
+			log.Errorf("could not remove project '%s' from the search index: %v", name, err)
+		}
+	}
+	if f.sphinx != nil {
+		if err := f.sphinx.Remove(name); err != nil {
+			log.Errorf("could not remove project '%s' from the federated search: %v", name, err)
+		}
+	}
+}
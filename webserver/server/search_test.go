@@ -0,0 +1,186 @@
+package server_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+
+	. "private-sphinx-docs/server"
+	db "private-sphinx-docs/services/database"
+	"private-sphinx-docs/services/search"
+	sf "private-sphinx-docs/services/staticfiles"
+)
+
+func TestSearchHandler(t *testing.T) {
+	assert := require.New(t)
+
+	fs, cleanup := NewTestFileSys(t, sf.FileSysOption{})
+	defer cleanup()
+	index, err := search.New(filepath.Join(fs.Source(), ".search"))
+	assert.NoError(err)
+	defer func() { _ = index.Close() }()
+
+	srv := NewTestServer(t, Option{FileHandler: fs, Search: index})
+
+	query := func(params string, status int) *search.Results {
+		w := httptest.NewRecorder()
+		srv.Handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://localhost/api/search?"+params, nil))
+		assert.Equal(status, w.Code, w.Body.String())
+		if status != http.StatusOK {
+			return nil
+		}
+
+		var results search.Results
+		assert.NoError(json.NewDecoder(w.Body).Decode(&results))
+		return &results
+	}
+
+	// the index is updated when a project is published
+	srv.Publish("project1", map[string]string{
+		"index.html":                       `<html><body><div role="main"><h1>Welcome</h1><p>Getting started</p></div></body></html>`,
+		"install.html":                     `<html><body><div role="main"><h1>Installation</h1><p>Run pip install</p></div></body></html>`,
+		"_static/documentation_options.js": `var DOCUMENTATION_OPTIONS = { VERSION: '1.0' };`,
+		"_static/style.css":                `body { color: black; }`,
+	})
+
+	// projects are indexed in the background
+	indexed := func(q string, total uint64) {
+		assert.Eventually(func() bool { return query(q, http.StatusOK).Total == total }, 5*time.Second, 10*time.Millisecond, q)
+	}
+	indexed("q=install", 1)
+
+	results := query("q=install", http.StatusOK)
+	assert.EqualValues(1, results.Total)
+	assert.Equal("project1", results.Hits[0].Project)
+	assert.Equal("1.0", results.Hits[0].Version)
+	assert.Equal("install.html", results.Hits[0].Path)
+	assert.Equal("Installation", results.Hits[0].Title)
+
+	assert.EqualValues(1, query("q=install&project=project1&version=1.0", http.StatusOK).Total)
+	assert.EqualValues(0, query("q=install&version=2.0", http.StatusOK).Total)
+	assert.EqualValues(1, query("q=run+pip&phrase=true", http.StatusOK).Total)
+	assert.EqualValues(0, query("q=pip+run&phrase=true", http.StatusOK).Total)
+	assert.EqualValues(0, query("q=color", http.StatusOK).Total)
+
+	query("q=", http.StatusBadRequest)
+	query("q=install&limit=-1", http.StatusBadRequest)
+	query("q=install&phrase=maybe", http.StatusBadRequest)
+
+	// removed pages are dropped from the index
+	srv.Publish("project1", map[string]string{
+		"index.html": `<html><body><div role="main"><h1>Welcome</h1><p>Getting started</p></div></body></html>`,
+	})
+	indexed("q=install", 0)
+	assert.EqualValues(1, query("q=welcome", http.StatusOK).Total)
+
+	r := httptest.NewRequest(http.MethodDelete, "http://localhost/api/project/project1", nil)
+	r.SetBasicAuth("admin", "password")
+	w := httptest.NewRecorder()
+	srv.Handler.ServeHTTP(w, r)
+	assert.Equal(http.StatusOK, w.Code, w.Body.String())
+	indexed("q=welcome", 0)
+}
+
+// Fails to record the revisions of project2
+type failingRevisionStore struct {
+	*MockStore
+}
+
+func (m *failingRevisionStore) CreateRevision(revision *db.Revision) (*db.Revision, error) {
+	if project, err := m.FetchProject("project2"); err == nil && project.Id == revision.ProjectId {
+		return nil, errors.New("could not save revision")
+	}
+	return m.MockStore.CreateRevision(revision)
+}
+
+func TestSearchHandler_UncommittedRevision(t *testing.T) {
+	assert := require.New(t)
+
+	fs, cleanup := NewTestFileSys(t, sf.FileSysOption{})
+	defer cleanup()
+	index, err := search.New(filepath.Join(fs.Source(), ".search"))
+	assert.NoError(err)
+	defer func() { _ = index.Close() }()
+
+	srv := NewTestServer(t, Option{Store: &failingRevisionStore{NewMockStore()}, FileHandler: fs, Search: index})
+
+	query := func(q string) uint64 {
+		w := httptest.NewRecorder()
+		srv.Handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://localhost/api/search?q="+q, nil))
+		assert.Equal(http.StatusOK, w.Code, w.Body.String())
+
+		var results search.Results
+		assert.NoError(json.NewDecoder(w.Body).Decode(&results))
+		return results.Total
+	}
+
+	// the files are published but the revision is not committed
+	r := httptest.NewRequest(http.MethodPut, "http://localhost/api/project/project2", bytes.NewReader(zipFiles(t, map[string]string{
+		"index.html": `<html><body><div role="main"><h1>Uncommitted</h1></div></body></html>`,
+	})))
+	r.SetBasicAuth("admin", "password")
+	r.Header.Set("Content-Type", "application/zip")
+	w := httptest.NewRecorder()
+	srv.Handler.ServeHTTP(w, r)
+	assert.Equal(http.StatusBadRequest, w.Code, w.Body.String())
+
+	// projects are indexed in the order they were queued
+	srv.Publish("project1", map[string]string{
+		"index.html": `<html><body><div role="main"><h1>Committed</h1></div></body></html>`,
+	})
+	assert.Eventually(func() bool { return query("committed") == 1 }, 5*time.Second, 10*time.Millisecond)
+	assert.EqualValues(0, query("uncommitted"))
+}
+
+func TestSearchHandler_Federated(t *testing.T) {
+	assert := require.New(t)
+
+	fs, cleanup := NewTestFileSys(t, sf.FileSysOption{})
+	defer cleanup()
+
+	srv := NewTestServer(t, Option{FileHandler: fs, Sphinx: search.NewSphinxIndex()})
+
+	query := func(params string, status int) *search.SphinxResults {
+		w := httptest.NewRecorder()
+		srv.Handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://localhost/api/search/federated?"+params, nil))
+		assert.Equal(status, w.Code, w.Body.String())
+		if status != http.StatusOK {
+			return nil
+		}
+
+		var results search.SphinxResults
+		assert.NoError(json.NewDecoder(w.Body).Decode(&results))
+		return &results
+	}
+	srv.Publish("project1", map[string]string{
+		"config.html": "<html></html>",
+		"searchindex.js": `Search.setIndex({"alltitles": {"Server configuration": [[0, "server-configuration"]]}, ` +
+			`"docnames": ["config"], "terms": {"port": 0}, "titles": ["Configuration"], "titleterms": {"configur": 0}})`,
+		"_static/documentation_options.js": `var DOCUMENTATION_OPTIONS = { VERSION: '1.0' };`,
+	})
+
+	assert.Eventually(func() bool { return query("q=server+configuration", http.StatusOK).Total == 1 }, 5*time.Second, 10*time.Millisecond)
+	results := query("q=server+configuration", http.StatusOK)
+	assert.Equal(1, results.Total)
+	assert.Equal("1.0", results.Hits[0].Version)
+	assert.Equal("Server configuration", results.Hits[0].Section)
+	assert.Equal("http://project1.localhost/config.html#server-configuration", results.Hits[0].URL)
+	assert.Equal("http://project1.localhost/config.html", query("q=port", http.StatusOK).Hits[0].URL)
+	query("q=", http.StatusBadRequest)
+
+	// projects published without a search index are dropped
+	srv.Publish("project1", map[string]string{"config.html": "<html></html>"})
+	assert.Eventually(func() bool { return query("q=port", http.StatusOK).Total == 0 }, 5*time.Second, 10*time.Millisecond)
+
+	// full text search is not enabled
+	w := httptest.NewRecorder()
+	srv.Handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://localhost/api/search?q=port", nil))
+	assert.Equal(http.StatusNotFound, w.Code)
+}
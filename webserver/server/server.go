@@ -21,6 +21,21 @@ type Option struct {
 	Port        int
 	Store       IStore
 	FileHandler IFileHandler
+	Uploads     IUploadStore
+	// Maximum size of an uploaded artifact in bytes. 0 means there is no limit
+	MaxUploadSize int64
+	// How long the served documentation can be cached. Projects without a policy in
+	// ProjectCache use CachePolicy
+	CachePolicy  CachePolicy
+	ProjectCache map[string]CachePolicy
+	// Optional in-memory cache of small documentation files
+	FileCache *FileCache
+	// Optional full text index of the documentation, updated whenever a project is published
+	Search ISearchIndex
+	// Optional federated search of the Sphinx searchindex.js of every project
+	Sphinx ISphinxIndex
+	// Tasks admins can run from the dashboard, besides the tasks of the server itself
+	Maintenance []MaintenanceTask
 }
 
 type SubDomains map[subdomain]http.Handler
@@ -38,6 +53,15 @@ func (s SubDomains) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 }
 
 func New(option Option) (*http.Server, error) {
+	option.FileHandler = &inventoryFileHandler{IFileHandler: option.FileHandler, store: option.Store}
+	if option.FileCache != nil {
+		option.FileHandler = &invalidatingFileHandler{IFileHandler: option.FileHandler, cache: option.FileCache}
+	}
+	if option.Search != nil || option.Sphinx != nil {
+		option.FileHandler = newIndexingFileHandler(option.FileHandler, option.Search, option.Sphinx)
+	}
+	option.Maintenance = append(option.Maintenance, serverTasks(option)...)
+
 	subdomains := make(SubDomains)
 	subdomains[main] = apiRouter(option)
 	subdomains[docs] = docRouter(option)
@@ -50,7 +74,6 @@ func New(option Option) (*http.Server, error) {
 
 func attachMiddleware(r *chi.Mux) {
 	r.Use(middleware.RequestID,
-		middleware.Compress(5),
 		middleware.Recoverer,
 		middleware.RealIP,
 		middleware.Logger,
@@ -63,8 +86,20 @@ func apiRouter(option Option) *chi.Mux {
 	fs := option.FileHandler
 
 	attachMiddleware(r)
+	r.Use(middleware.Compress(5))
+	// admins can act as another account on the api and the dashboard alike
+	r.Use(Impersonate(store))
 	r.Get("/__status", StatusCheck(option.Version))
 
+	catalog := CatalogHandler{DB: store}
+	r.Get("/", catalog.Index()) // HTML catalog of the hosted projects
+
+	admin := AdminHandler{DB: store, FS: fs, MaxUploadSize: option.MaxUploadSize, Tasks: option.Maintenance}
+	r.Mount(adminPath, admin.Routes()) // HTML dashboard to manage accounts and projects
+
+	inventory := InventoryHandler{DB: store}
+	r.Get("/inventory/{project}/{version}/objects.inv", inventory.Download()) // intersphinx inventory of a project version
+
 	r.Route("/api", func(r chi.Router) {
 		r.Route("/account", func(r chi.Router) {
 			handler := AccountHandler{DB: store, FS: fs}
@@ -76,11 +111,44 @@ func apiRouter(option Option) *chi.Mux {
 		})
 
 		r.Route("/project", func(r chi.Router) {
-			handler := ProjectHandler{DB: store, FS: fs}
+			handler := ProjectHandler{DB: store, FS: fs, Uploads: option.Uploads, MaxUploadSize: option.MaxUploadSize}
 			r.Get("/", handler.FetchProjects())           // get all projects
-			r.Get("/{username}", handler.FetchProjects()) // get all user projects
+			r.Get("/{title}", handler.FetchProject())     // get project details
 			r.Post("/", handler.UploadProject())          // upload new project (create / update)
+			r.Put("/{title}", handler.StreamProject())    // upload new project from the raw request body
 			r.Delete("/{title}", handler.DeleteProject()) // removes project
+
+			r.Get("/{title}/revisions", handler.FetchRevisions())                 // list uploaded revisions
+			r.Post("/{title}/revisions/{id}/rollback", handler.RollbackProject()) // restore an earlier revision
+			r.Post("/{title}/manifest", handler.CompareManifest())                // list files missing for an incremental upload
+			r.Post("/{title}/sync", handler.SyncProject())                        // publish an incremental upload
+			r.Get("/{title}/files", handler.ListFiles())                          // list a directory of the live documentation
+			r.Get("/{title}/raw", handler.RawFile())                              // raw bytes of a file of the live documentation
+			r.Get("/{title}/archive", handler.DownloadArchive())                  // download the documentation as zip or tar.gz
+		})
+
+		r.Get("/xref", inventory.Lookup()) // find documented objects by name
+
+		search := SearchHandler{Index: option.Search, Sphinx: option.Sphinx}
+		r.Get("/search", search.Search())              // full text search of the documentation
+		r.Get("/search/federated", search.Federated()) // search of the Sphinx search indices
+
+		r.Route("/upload", func(r chi.Router) {
+			// resumable uploads with the tus protocol
+			handler := ProjectHandler{DB: store, FS: fs, Uploads: option.Uploads, MaxUploadSize: option.MaxUploadSize}
+			r.Options("/", handler.TusOptions())
+			r.Post("/", handler.CreateUpload())
+			r.Head("/{id}", handler.UploadOffset())
+			r.Patch("/{id}", handler.AppendUpload())
+			r.Delete("/{id}", handler.TerminateUpload())
+		})
+
+		r.Route("/admin", func(r chi.Router) {
+			handler := AuditHandler{DB: store}
+			r.Get("/audit", handler.FetchEvents()) // query audit trail
+
+			cache := CacheHandler{DB: store, Cache: option.FileCache}
+			r.Get("/cache", cache.FetchStats()) // file cache hit and miss counters
 		})
 	})
 
@@ -89,15 +157,47 @@ func apiRouter(option Option) *chi.Mux {
 
 func docRouter(option Option) *chi.Mux {
 	r := chi.NewRouter()
+	// the documentation is compressed once at publish time, see DocumentationHandler
 	attachMiddleware(r)
 
-	handler := DocumentationHandler{option.FileHandler.Source()}
-	handler.MustInit()
+	handler := DocumentationHandler{
+		FS:           option.FileHandler,
+		CachePolicy:  option.CachePolicy,
+		ProjectCache: option.ProjectCache,
+		Cache:        option.FileCache,
+	}
 	r.Handle("/*", handler.FileServer())
 
 	return r
 }
 
+// Maintenance tasks of the caches and indices of the server
+func serverTasks(option Option) []MaintenanceTask {
+	var tasks []MaintenanceTask
+	if option.FileCache != nil {
+		tasks = append(tasks, MaintenanceTask{
+			Id:          "clear-file-cache",
+			Name:        "Clear file cache",
+			Description: "Drops every file kept in memory",
+			Run: func() (string, error) {
+				return fmt.Sprintf("dropped %d files", option.FileCache.Clear()), nil
+			},
+		})
+	}
+	if option.Search != nil || option.Sphinx != nil {
+		tasks = append(tasks, MaintenanceTask{
+			Id:          "reindex",
+			Name:        "Update search indices",
+			Description: "Indexes the live documentation of every project again",
+			Run: func() (string, error) {
+				IndexProjects(option.Store, option.FileHandler, option.Search, option.Sphinx)
+				return "indexed every project", nil
+			},
+		})
+	}
+	return tasks
+}
+
 func StatusCheck(version string) http.HandlerFunc {
 	return func(w http.ResponseWriter, _ *http.Request) {
 		toJson(w, struct {
@@ -1,16 +1,26 @@
 package server_test
 
 import (
+	"archive/zip"
+	"bytes"
 	"context"
 	"errors"
 	"io"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"sort"
+	"strings"
+	"testing"
 	"time"
 
 	"github.com/go-chi/chi"
+	"github.com/stretchr/testify/require"
 
+	. "private-sphinx-docs/server"
 	db "private-sphinx-docs/services/database"
+	sf "private-sphinx-docs/services/staticfiles"
 )
 
 func NewMockStore() *MockStore {
@@ -37,8 +47,11 @@ func NewMockStore() *MockStore {
 }
 
 type MockStore struct {
-	accounts map[string]*db.Account
-	projects map[string]*db.Project
+	accounts    map[string]*db.Account
+	projects    map[string]*db.Project
+	revisions   []*db.Revision
+	events      []*db.AuditEvent
+	inventories []*db.Inventory
 }
 
 func (m *MockStore) FetchAccount(username string) (*db.Account, error) {
@@ -49,6 +62,10 @@ func (m *MockStore) FetchAccount(username string) (*db.Account, error) {
 	return acc, nil
 }
 
+func (m *MockStore) FetchAccountById(id int) (*db.Account, error) {
+	return m.fetchAccount(id)
+}
+
 func (m *MockStore) FetchAccounts() ([]*db.Account, error) {
 	var accounts []*db.Account
 	for _, a := range m.accounts {
@@ -83,11 +100,13 @@ func (m *MockStore) CreateAccount(username, password string, isAdmin bool) (*db.
 }
 
 func (m *MockStore) UpdateAccount(account *db.Account) (*db.Account, error) {
-	err := account.Validate()
+	acc, err := m.fetchAccount(account.Id)
 	if err != nil {
 		return nil, err
 	}
-	acc, err := m.fetchAccount(account.Id)
+	if err = account.Validate(); err == nil {
+		err = account.SaltPassword()
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -98,6 +117,15 @@ func (m *MockStore) UpdateAccount(account *db.Account) (*db.Account, error) {
 	return account, nil
 }
 
+func (m *MockStore) SetAccountAdmin(id int, isAdmin bool) error {
+	acc, err := m.fetchAccount(id)
+	if err != nil {
+		return err
+	}
+	acc.IsAdmin = isAdmin
+	return nil
+}
+
 func (m *MockStore) fetchAccount(id int) (*db.Account, error) {
 	for _, acc := range m.accounts {
 		if acc.Id == id {
@@ -117,6 +145,10 @@ func (m *MockStore) DeleteAccount(username string) error {
 	return nil
 }
 
+func (m *MockStore) FetchProject(title string) (*db.Project, error) {
+	return m.fetchProject(title)
+}
+
 func (m *MockStore) FetchProjects() ([]*db.Project, error) {
 	var projects []*db.Project
 
@@ -135,6 +167,92 @@ func (m *MockStore) FetchProjectsByAccount(accountId int) ([]*db.Project, error)
 	return acc.Projects, nil
 }
 
+func (m *MockStore) QueryProjects(filter db.ProjectFilter) (*db.ProjectPage, error) {
+	// compares the sort keys of the projects, ties are broken by id
+	less := func(a, b *db.Project) bool {
+		if filter.Sort == db.SortByLastUpdate && !a.LastUpdate.Equal(b.LastUpdate) {
+			return (a.LastUpdate.Before(b.LastUpdate)) != filter.Descending
+		} else if filter.Sort != db.SortByLastUpdate && a.Title != b.Title {
+			return (a.Title < b.Title) != filter.Descending
+		}
+		return a.Id != b.Id && (a.Id < b.Id) != filter.Descending
+	}
+
+	page := &db.ProjectPage{}
+	for _, p := range m.projects {
+		if (filter.AccountId > 0 && p.AccountId != filter.AccountId) ||
+			!hasTags(p, filter.Tags) ||
+			!strings.HasPrefix(strings.ToLower(p.Title), strings.ToLower(filter.TitlePrefix)) ||
+			p.LastUpdate.Before(filter.UpdatedSince) {
+			continue
+		}
+		page.Total++
+		if filter.After == nil || less(filter.After, p) {
+			page.Projects = append(page.Projects, p)
+		}
+	}
+
+	sort.Slice(page.Projects, func(i, j int) bool { return less(page.Projects[i], page.Projects[j]) })
+	if filter.Limit > 0 && len(page.Projects) > filter.Limit {
+		page.Projects = page.Projects[:filter.Limit]
+	}
+	return page, nil
+}
+
+func (m *MockStore) FetchProjectTags() ([]string, error) {
+	seen := make(map[string]bool)
+	var tags []string
+	for _, p := range m.projects {
+		for _, t := range p.Tags {
+			if !seen[t] {
+				seen[t] = true
+				tags = append(tags, t)
+			}
+		}
+	}
+	sort.Strings(tags)
+	return tags, nil
+}
+
+func (m *MockStore) FetchProjectOwners() ([]*db.Account, error) {
+	var owners []*db.Account
+	for _, acc := range m.accounts {
+		for _, p := range m.projects {
+			if p.AccountId == acc.Id {
+				owners = append(owners, &db.Account{Id: acc.Id, Username: acc.Username})
+				break
+			}
+		}
+	}
+	sort.Slice(owners, func(i, j int) bool { return owners[i].Username < owners[j].Username })
+	return owners, nil
+}
+
+func hasTags(p *db.Project, tags []string) bool {
+	for _, tag := range tags {
+		found := false
+		for _, t := range p.Tags {
+			found = found || t == tag
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func (m *MockStore) UpdateProjectMetadata(project *db.Project) (*db.Project, error) {
+	if err := project.ValidateMetadata(); err != nil {
+		return nil, err
+	}
+	p, err := m.fetchProject(project.Title)
+	if err != nil {
+		return nil, err
+	}
+	*p = *project
+	return p, nil
+}
+
 func (m *MockStore) fetchProject(title string) (*db.Project, error) {
 	p, exist := m.projects[title]
 	if !exist {
@@ -202,6 +320,13 @@ func (m *MockStore) DeleteProject(title string) error {
 	if _, err := m.fetchProject(title); err != nil {
 		return err
 	}
+	var inventories []*db.Inventory
+	for _, inv := range m.inventories {
+		if inv.Project != title {
+			inventories = append(inventories, inv)
+		}
+	}
+	m.inventories = inventories
 	delete(m.projects, title)
 	return nil
 }
@@ -214,6 +339,162 @@ func (m *MockStore) CanOwnProject(accountId int, title string) (bool, error) {
 	return p.AccountId == accountId, nil
 }
 
+func (m *MockStore) FetchRevision(id int) (*db.Revision, error) {
+	for _, rev := range m.revisions {
+		if rev.Id == id {
+			return rev, nil
+		}
+	}
+	return nil, errors.New("revision does not exist")
+}
+
+func (m *MockStore) FetchRevisions(projectId int) ([]*db.Revision, error) {
+	var revisions []*db.Revision
+	for i := len(m.revisions) - 1; i >= 0; i-- {
+		if rev := m.revisions[i]; rev.ProjectId == projectId {
+			revisions = append(revisions, rev)
+		}
+	}
+	return revisions, nil
+}
+
+func (m *MockStore) FetchLiveRevisions(projectIds []int) (map[int]*db.Revision, error) {
+	live := make(map[int]*db.Revision)
+	for _, id := range projectIds {
+		for _, p := range m.projects {
+			if p.Id != id || p.RevisionId == nil {
+				continue
+			}
+			if rev, err := m.FetchRevision(*p.RevisionId); err == nil {
+				live[id] = rev
+			}
+		}
+	}
+	return live, nil
+}
+
+func (m *MockStore) CreateRevision(revision *db.Revision) (*db.Revision, error) {
+	if err := revision.Validate(); err != nil {
+		return nil, err
+	}
+	revision.Id = len(m.revisions) + 1
+	revision.CreatedAt = time.Now()
+	if revision.AccountId != nil {
+		if acc, err := m.fetchAccount(*revision.AccountId); err == nil {
+			revision.Uploader = acc.Username
+		}
+	}
+	m.revisions = append(m.revisions, revision)
+
+	return revision, m.SetLiveRevision(revision.ProjectId, revision.Id)
+}
+
+func (m *MockStore) SetLiveRevision(projectId, revisionId int) error {
+	rev, err := m.FetchRevision(revisionId)
+	if err != nil {
+		return err
+	}
+	for _, p := range m.projects {
+		if p.Id == projectId && rev.ProjectId == projectId {
+			p.RevisionId = &rev.Id
+			p.LastUpdate = time.Now()
+			return nil
+		}
+	}
+	return errors.New("project does not exist")
+}
+
+func (m *MockStore) SaveInventory(projectId int, version string, objects []*db.InventoryObject) (*db.Inventory, error) {
+	var project *db.Project
+	for _, p := range m.projects {
+		if p.Id == projectId {
+			project = p
+		}
+	}
+	if project == nil {
+		return nil, errors.New("project does not exist")
+	}
+
+	// the latest inventory is kept last
+	for i, inv := range m.inventories {
+		if inv.ProjectId == projectId && inv.Version == version {
+			m.inventories = append(m.inventories[:i], m.inventories[i+1:]...)
+			break
+		}
+	}
+	inv := &db.Inventory{
+		Id:        len(m.inventories) + 1,
+		ProjectId: projectId,
+		Project:   project.Title,
+		Version:   version,
+		UpdatedAt: time.Now(),
+		Objects:   objects,
+	}
+	for _, o := range objects {
+		o.InventoryId, o.Project, o.Version = inv.Id, project.Title, version
+	}
+	m.inventories = append(m.inventories, inv)
+	return inv, nil
+}
+
+func (m *MockStore) FetchInventory(title, version string) (*db.Inventory, error) {
+	for i := len(m.inventories) - 1; i >= 0; i-- {
+		if inv := m.inventories[i]; inv.Project == title && (version == "" || inv.Version == version) {
+			return inv, nil
+		}
+	}
+	return nil, errors.New("inventory does not exist")
+}
+
+func (m *MockStore) FindInventoryObjects(filter db.XrefFilter) ([]*db.InventoryObject, error) {
+	var objects []*db.InventoryObject
+	for i := len(m.inventories) - 1; i >= 0; i-- {
+		inv := m.inventories[i]
+		if (filter.Project != "" && inv.Project != filter.Project) ||
+			(filter.Version != "" && inv.Version != filter.Version) {
+			continue
+		}
+		for _, o := range inv.Objects {
+			if o.Name == filter.Name && (filter.Type == "" || o.Type == filter.Type) {
+				objects = append(objects, o)
+			}
+		}
+	}
+	if filter.Limit > 0 && len(objects) > filter.Limit {
+		objects = objects[:filter.Limit]
+	}
+	return objects, nil
+}
+
+func (m *MockStore) CreateAuditEvent(event *db.AuditEvent) (*db.AuditEvent, error) {
+	if err := event.Validate(); err != nil {
+		return nil, err
+	}
+	event.Id = len(m.events) + 1
+	event.CreatedAt = time.Now()
+	m.events = append(m.events, event)
+	return event, nil
+}
+
+func (m *MockStore) FetchAuditEvents(filter db.AuditFilter) ([]*db.AuditEvent, error) {
+	var events []*db.AuditEvent
+	for i := len(m.events) - 1; i >= 0; i-- {
+		e := m.events[i]
+		if (filter.Actor != "" && e.Actor != filter.Actor) ||
+			(filter.Action != "" && e.Action != filter.Action) ||
+			(filter.Target != "" && e.Target != filter.Target) ||
+			(!filter.Since.IsZero() && e.CreatedAt.Before(filter.Since)) ||
+			(!filter.Until.IsZero() && !e.CreatedAt.Before(filter.Until)) {
+			continue
+		}
+		events = append(events, e)
+		if filter.Limit > 0 && len(events) == filter.Limit {
+			break
+		}
+	}
+	return events, nil
+}
+
 func NewFileHandler() *MockFileHandler {
 	return &MockFileHandler{}
 }
@@ -221,18 +502,65 @@ func NewFileHandler() *MockFileHandler {
 type MockFileHandler struct {
 }
 
-func (m *MockFileHandler) Upload(r io.ReaderAt, name string, size int64) error {
-	return nil
+func (m *MockFileHandler) Upload(r io.ReaderAt, name string, size int64) (*sf.Stats, error) {
+	return &sf.Stats{Files: 2, Size: 1024, HasIndex: true}, nil
+}
+
+func (m *MockFileHandler) CreateTemp() (*os.File, error) {
+	return ioutil.TempFile("", "upload-")
 }
 
 func (m *MockFileHandler) Destination(name string) string {
 	return ""
 }
 
+func (m *MockFileHandler) SaveArtifact(r io.ReaderAt, name string, size int64) (string, string, error) {
+	return "artifact", "checksum", nil
+}
+
+func (m *MockFileHandler) Restore(name, artifact string) error {
+	return nil
+}
+
+func (m *MockFileHandler) Manifest(name string) (map[string]string, error) {
+	return map[string]string{
+		"index.html":         "index",
+		"_static/styles.css": "styles",
+	}, nil
+}
+
+func (m *MockFileHandler) Sync(name string, r io.ReaderAt, size int64, deletions []string) (string, string, int64, *sf.Stats, error) {
+	return "artifact", "checksum", size, &sf.Stats{Files: 2, Size: 1024, HasIndex: true}, nil
+}
+
 func (m *MockFileHandler) Remove(name string) error {
 	return nil
 }
 
+func (m *MockFileHandler) Open(name, path string) (http.File, error) {
+	return nil, os.ErrNotExist
+}
+
+func (m *MockFileHandler) OpenEncoded(name, path, encoding string) (http.File, error) {
+	return nil, os.ErrNotExist
+}
+
+func (m *MockFileHandler) Checksum(name, path string) (string, error) {
+	return "", os.ErrNotExist
+}
+
+func (m *MockFileHandler) Stat(name, path string) (os.FileInfo, error) {
+	return nil, os.ErrNotExist
+}
+
+func (m *MockFileHandler) List(name, path string) ([]os.FileInfo, error) {
+	return nil, os.ErrNotExist
+}
+
+func (m *MockFileHandler) Walk(name, artifact string, fn sf.WalkFunc) error {
+	return os.ErrNotExist
+}
+
 func (m *MockFileHandler) Source() string {
 	return "source"
 }
@@ -251,3 +579,55 @@ func NewTestRequest(method, target string, body io.Reader, routeParams map[strin
 
 	return r
 }
+
+// Creates a FileSys in a temporary folder. Call the returned function to remove the folder
+func NewTestFileSys(t *testing.T, option sf.FileSysOption) (*sf.FileSys, func()) {
+	root, err := ioutil.TempDir("", "psd-")
+	require.NoError(t, err)
+
+	option.Root = root
+	fs, err := sf.NewFileSys(&option)
+	if err != nil {
+		_ = os.RemoveAll(root)
+	}
+	require.NoError(t, err)
+	return fs, func() { _ = os.RemoveAll(root) }
+}
+
+// Server for the tests, the store defaults to a MockStore
+type TestServer struct {
+	*http.Server
+	t *testing.T
+}
+
+func NewTestServer(t *testing.T, option Option) *TestServer {
+	if option.Store == nil {
+		option.Store = NewMockStore()
+	}
+	srv, err := New(option)
+	require.NoError(t, err)
+	return &TestServer{Server: srv, t: t}
+}
+
+// Publishes the files as a new revision of the project, uploaded by the admin
+func (s *TestServer) Publish(name string, files map[string]string) {
+	r := httptest.NewRequest(http.MethodPut, "http://localhost/api/project/"+name, bytes.NewReader(zipFiles(s.t, files)))
+	r.SetBasicAuth("admin", "password")
+	r.Header.Set("Content-Type", "application/zip")
+	w := httptest.NewRecorder()
+	s.Handler.ServeHTTP(w, r)
+	require.Equal(s.t, http.StatusOK, w.Code, w.Body.String())
+}
+
+func zipFiles(t *testing.T, files map[string]string) []byte {
+	buf := new(bytes.Buffer)
+	zw := zip.NewWriter(buf)
+	for name, content := range files {
+		w, err := zw.Create(name)
+		require.NoError(t, err)
+		_, err = w.Write([]byte(content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, zw.Close())
+	return buf.Bytes()
+}
@@ -0,0 +1,165 @@
+package server
+
+import (
+	"net/http"
+	"sort"
+
+	"github.com/go-chi/chi"
+	"github.com/pkg/errors"
+
+	"private-sphinx-docs/server/dto"
+	db "private-sphinx-docs/services/database"
+	sf "private-sphinx-docs/services/staticfiles"
+)
+
+// Incremental uploads let clients send only the files that changed since the last upload.
+// The client first posts a manifest of its build (path to sha256 checksum) and the server
+// replies with the files it does not have. The client then uploads an archive of those files
+// together with the paths to delete, and the server assembles the new revision from the
+// current tree.
+
+type SyncManifest struct {
+	// sha256 checksum (hex encoded) of each file keyed by its slash separated path relative
+	// to the project root
+	Files map[string]string `json:"files"`
+}
+
+type SyncPlan struct {
+	// Files that are missing or differ on the server and must be uploaded
+	Missing []string `json:"missing"`
+	// Files on the server that are not in the manifest. These should be deleted unless the
+	// client wants to keep them
+	Extra []string `json:"extra"`
+}
+
+// Compares the client manifest with the live files of the project
+func (h *ProjectHandler) CompareManifest() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		account, err := authenticate(h.DB, r)
+		if err != nil {
+			Forbid(w, r)
+			return
+		}
+
+		title := chi.URLParam(r, "title")
+		if err := h.canManageProject(account, title); err != nil {
+			Forbid(w, r)
+			return
+		}
+		if _, err := h.DB.FetchProject(title); err != nil {
+			BadRequest(w, err)
+			return
+		}
+
+		var manifest SyncManifest
+		if err := readJson(r, &manifest); err != nil {
+			BadRequest(w, err)
+			return
+		}
+
+		current, err := h.FS.Manifest(title)
+		if err != nil {
+			BadRequest(w, err)
+			return
+		}
+
+		toJson(w, compareManifests(manifest.Files, current))
+	}
+}
+
+// Publishes a new revision built from the live files of the project. The multipart form may
+// contain an archive of new or changed files as "content" and any number of "delete" fields
+// with the paths to remove. Deletions are applied before the archive is extracted.
+func (h *ProjectHandler) SyncProject() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		account, err := authenticate(h.DB, r)
+		if err != nil {
+			Forbid(w, r)
+			return
+		}
+
+		title := chi.URLParam(r, "title")
+		if err := h.canManageProject(account, title); err != nil {
+			Forbid(w, r)
+			return
+		}
+		if _, err := h.DB.FetchProject(title); err != nil {
+			BadRequest(w, err)
+			return
+		}
+
+		if h.tooLarge(r.ContentLength) {
+			http.Error(w, h.tooLargeMessage(), http.StatusRequestEntityTooLarge)
+			return
+		}
+		if h.MaxUploadSize > 0 {
+			r.Body = http.MaxBytesReader(w, r.Body, h.MaxUploadSize)
+		}
+
+		if err := r.ParseMultipartForm(10 << 20); bodyTooLarge(err) {
+			http.Error(w, h.tooLargeMessage(), http.StatusRequestEntityTooLarge)
+			return
+		} else if err != nil {
+			BadRequest(w, errors.Wrap(err, "could not parse form"))
+			return
+		}
+		deletions := r.MultipartForm.Value["delete"]
+		metadata := dto.NewProjectMetadata(r.MultipartForm.Value)
+		if err := metadata.Validate(); err != nil {
+			BadRequest(w, err)
+			return
+		}
+
+		var size int64
+		file, header, err := r.FormFile("content")
+		if err == nil {
+			defer func() { _ = file.Close() }()
+			size = header.Size
+		} else if err != http.ErrMissingFile {
+			BadRequest(w, errors.Wrap(err, "error retrieving file"))
+			return
+		}
+
+		if file == nil && len(deletions) == 0 {
+			BadRequest(w, errors.New("nothing to sync, upload changed files or paths to delete"))
+			return
+		}
+
+		project, err := h.release(r, account, title, metadata, func() (*db.Revision, error) {
+			artifact, checksum, n, stats, err := h.FS.Sync(title, file, size, deletions)
+			if err != nil {
+				return nil, err
+			}
+			return newRevision(artifact, checksum, n, stats), nil
+		})
+		if errors.Cause(err) == sf.ErrConflict {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		} else if err != nil {
+			BadRequest(w, err)
+			return
+		}
+
+		toJson(w, project)
+	}
+}
+
+// Works out which files the client has to upload (missing) and which files it may want to
+// delete (extra) to make the server match its manifest
+func compareManifests(client, server map[string]string) *SyncPlan {
+	plan := &SyncPlan{Missing: []string{}, Extra: []string{}}
+	for path, checksum := range client {
+		if server[path] != checksum {
+			plan.Missing = append(plan.Missing, path)
+		}
+	}
+	for path := range server {
+		if _, ok := client[path]; !ok {
+			plan.Extra = append(plan.Extra, path)
+		}
+	}
+
+	sort.Strings(plan.Missing)
+	sort.Strings(plan.Extra)
+	return plan
+}
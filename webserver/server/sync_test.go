@@ -0,0 +1,134 @@
+package server_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	. "private-sphinx-docs/server"
+	db "private-sphinx-docs/services/database"
+	sf "private-sphinx-docs/services/staticfiles"
+)
+
+func TestProjectHandler_CompareManifest(t *testing.T) {
+	t.Parallel()
+	assert := require.New(t)
+	handler := NewProjectHandler()
+
+	_, err := handler.DB.CreateAccount("user1", "password", false)
+	assert.NoError(err)
+
+	manifest := `{"files": {"index.html": "index", "_static/styles.css": "changed", "new.html": "new"}}`
+	for _, s := range []struct {
+		Username   string
+		Title      string
+		StatusCode int
+	}{
+		{"admin", "project1", http.StatusOK},
+		{"user1", "project1", http.StatusForbidden},
+		{"admin", "unknown", http.StatusBadRequest},
+	} {
+		r := NewTestRequest("POST", "/", bytes.NewBufferString(manifest), map[string]string{
+			"title": s.Title,
+		})
+		r.SetBasicAuth(s.Username, "password")
+		w := httptest.NewRecorder()
+
+		handler.CompareManifest()(w, r)
+		assert.Equal(s.StatusCode, w.Code)
+
+		if s.StatusCode == http.StatusOK {
+			var plan *SyncPlan
+			assert.NoError(json.NewDecoder(w.Result().Body).Decode(&plan))
+			assert.Equal([]string{"_static/styles.css", "new.html"}, plan.Missing)
+			assert.Empty(plan.Extra)
+		}
+	}
+}
+
+func TestProjectHandler_SyncProject(t *testing.T) {
+	t.Parallel()
+	assert := require.New(t)
+	handler := NewProjectHandler()
+
+	_, err := handler.DB.CreateAccount("user1", "password", false)
+	assert.NoError(err)
+
+	for _, s := range []struct {
+		Username   string
+		Title      string
+		Content    bool
+		Deletions  []string
+		StatusCode int
+	}{
+		{"admin", "project1", true, nil, http.StatusOK},
+		{"admin", "project1", false, []string{"old.html"}, http.StatusOK},
+		{"admin", "project1", false, nil, http.StatusBadRequest},
+		{"admin", "unknown", true, nil, http.StatusBadRequest},
+		{"user1", "project1", true, nil, http.StatusForbidden},
+	} {
+		body := &bytes.Buffer{}
+		writer := multipart.NewWriter(body)
+		if s.Content {
+			part, err := writer.CreateFormFile("content", "changes.zip")
+			assert.NoError(err)
+			_, err = part.Write([]byte("Random Content"))
+			assert.NoError(err)
+		}
+		for _, path := range s.Deletions {
+			assert.NoError(writer.WriteField("delete", path))
+		}
+		assert.NoError(writer.Close())
+
+		r := NewTestRequest("POST", "/", body, map[string]string{
+			"title": s.Title,
+		})
+		r.Header.Set("Content-Type", writer.FormDataContentType())
+		r.SetBasicAuth(s.Username, "password")
+		w := httptest.NewRecorder()
+
+		handler.SyncProject()(w, r)
+		assert.Equal(s.StatusCode, w.Code)
+
+		if s.StatusCode == http.StatusOK {
+			var project *db.Project
+			assert.NoError(json.NewDecoder(w.Result().Body).Decode(&project))
+			assert.NotNil(project.RevisionId)
+		}
+	}
+}
+
+// Fails every sync as if another upload was published in the meantime
+type conflictingFileHandler struct {
+	MockFileHandler
+}
+
+func (m *conflictingFileHandler) Sync(name string, r io.ReaderAt, size int64, deletions []string) (string, string, int64, *sf.Stats, error) {
+	return "", "", 0, nil, sf.ErrConflict
+}
+
+func TestProjectHandler_SyncConflict(t *testing.T) {
+	t.Parallel()
+	assert := require.New(t)
+	handler := NewProjectHandler()
+	handler.FS = &conflictingFileHandler{}
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	assert.NoError(writer.WriteField("delete", "old.html"))
+	assert.NoError(writer.Close())
+
+	r := NewTestRequest("POST", "/", body, map[string]string{"title": "project1"})
+	r.Header.Set("Content-Type", writer.FormDataContentType())
+	r.SetBasicAuth("admin", "password")
+	w := httptest.NewRecorder()
+
+	handler.SyncProject()(w, r)
+	assert.Equal(http.StatusConflict, w.Code)
+}
@@ -0,0 +1,79 @@
+package server
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// The templates of the HTML pages are kept in the binary. Every page defines a "title" and a
+// "content" template rendered in the layout
+
+var templateFuncs = template.FuncMap{
+	"date": func(t time.Time) string {
+		if t.IsZero() {
+			return "-"
+		}
+		return t.Format("2006-01-02 15:04")
+	},
+	"bytes": func(n int64) string {
+		const unit = 1024
+		if n < unit {
+			return fmt.Sprintf("%d B", n)
+		}
+		div, exp := int64(unit), 0
+		for m := n / unit; m >= unit; m /= unit {
+			div *= unit
+			exp++
+		}
+		return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+	},
+}
+
+const layoutTemplate = `<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <meta name="viewport" content="width=device-width, initial-scale=1">
+  <title>{{template "title" .}}</title>
+  <style>
+    body { font-family: -apple-system, "Segoe UI", Helvetica, Arial, sans-serif; margin: 0; color: #24292e; background: #f6f8fa; }
+    header { background: #24292e; color: #fff; padding: 0.75rem 2rem; }
+    header a { color: #fff; text-decoration: none; margin-right: 1.5rem; }
+    main { max-width: 1100px; margin: 1.5rem auto; padding: 0 1rem; }
+    h1 { font-size: 1.5rem; }
+    form.filters { display: flex; flex-wrap: wrap; gap: 0.5rem; margin-bottom: 1rem; }
+    input, select, button { font: inherit; padding: 0.35rem 0.5rem; }
+    table { width: 100%; border-collapse: collapse; background: #fff; }
+    th, td { text-align: left; padding: 0.5rem 0.75rem; border-bottom: 1px solid #e1e4e8; }
+    th { background: #f1f3f5; }
+    .muted { color: #6a737d; }
+    .message { background: #fff; border-left: 4px solid #0366d6; padding: 0.5rem 1rem; }
+    .error { border-left-color: #d73a49; }
+    form.inline { display: flex; gap: 0.5rem; align-items: center; }
+    a.tag { display: inline-block; font-size: 0.8rem; background: #f1f8ff; color: #0366d6; border-radius: 1em; padding: 0 0.6em; margin-right: 0.25rem; text-decoration: none; }
+  </style>
+</head>
+<body>
+<header><a href="/"><strong>Documentation</strong></a><a href="/admin/">Administration</a></header>
+<main>
+{{template "content" .}}
+</main>
+</body>
+</html>`
+
+// Parses the page in the layout
+func newPageTemplate(page string) *template.Template {
+	t := template.Must(template.New("layout").Funcs(templateFuncs).Parse(layoutTemplate))
+	return template.Must(t.Parse(page))
+}
+
+func renderHTML(w http.ResponseWriter, t *template.Template, data interface{}) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := t.Execute(w, data); err != nil {
+		log.Errorf("could not render page: %v", err)
+	}
+}
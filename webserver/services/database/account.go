@@ -66,6 +66,20 @@ func (d *Database) FetchAccount(username string) (*Account, error) {
 	return acc, nil
 }
 
+func (d *Database) FetchAccountById(id int) (*Account, error) {
+	var err error
+	tx := d.MustBegin()
+	defer tx.Close(err)
+
+	acc := &Account{}
+	err = tx.Get(acc, "select * from ACCOUNT where ID = $1", id)
+	if err != nil {
+		return nil, err
+	}
+
+	return acc, nil
+}
+
 func (d *Database) FetchAccounts() ([]*Account, error) {
 	var err error
 	tx := d.MustBegin()
@@ -146,6 +160,22 @@ WHERE id = :id;
 	return account, nil
 }
 
+// Grants or revokes the admin rights of the account, its password is kept
+func (d *Database) SetAccountAdmin(id int, isAdmin bool) error {
+	var err error
+	tx := d.MustBegin()
+	defer tx.Close(err)
+
+	n, err := tx.Exec("UPDATE account SET is_admin = $1 WHERE id = $2", isAdmin, id)
+	if err != nil {
+		return err
+	} else if n == 0 {
+		return errors.Errorf("no account with id: %d", id)
+	}
+
+	return nil
+}
+
 func (d *Database) DeleteAccount(username string) error {
 	var err error
 	tx := d.MustBegin()
@@ -173,6 +173,28 @@ func TestDatabase_UpdateAccount(t *testing.T) {
 	})
 }
 
+func TestDatabase_SetAccountAdmin(t *testing.T) {
+	t.Parallel()
+	assert := require.New(t)
+
+	dktest.Run(t, imageName, postgresImageOptions, func(t *testing.T, info dktest.ContainerInfo) {
+		db, err := newTestDb(info, seedAccounts)
+		assert.NoError(err)
+		defer closeDb(db)
+
+		acc, err := db.CreateAccount("user1", "password", false)
+		assert.NoError(err)
+		assert.NoError(db.SetAccountAdmin(acc.Id, true))
+
+		acc, err = db.FetchAccountById(acc.Id)
+		assert.NoError(err)
+		assert.True(acc.IsAdmin)
+		assert.True(acc.HasValidPassword("password"))
+
+		assert.Error(db.SetAccountAdmin(999, true))
+	})
+}
+
 func TestDatabase_DeleteAccount(t *testing.T) {
 	t.Parallel()
 	assert := require.New(t)
@@ -0,0 +1,114 @@
+package database
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+type AuditEvent struct {
+	Id           int             `json:"id"`
+	Actor        string          `json:"actor"`
+	Impersonator string          `json:"impersonator,omitempty"`
+	Action       string          `json:"action"`
+	Target       string          `json:"target"`
+	IP           string          `json:"ip" db:"ip"`
+	RequestId    string          `json:"requestId" db:"request_id"`
+	Diff         json.RawMessage `json:"diff"`
+	CreatedAt    time.Time       `json:"createdAt" db:"created_at"`
+}
+
+// Filters used when querying the audit events. Zero values are ignored
+type AuditFilter struct {
+	Actor  string
+	Action string
+	Target string
+	Since  time.Time
+	Until  time.Time
+	Limit  int
+}
+
+func (e *AuditEvent) Validate() error {
+	if strings.TrimSpace(e.Actor) == "" {
+		return errors.New("audit event must have an actor")
+	} else if strings.TrimSpace(e.Action) == "" {
+		return errors.New("audit event must have an action")
+	}
+	return nil
+}
+
+func (d *Database) CreateAuditEvent(event *AuditEvent) (*AuditEvent, error) {
+	err := event.Validate()
+	if err != nil {
+		return nil, err
+	}
+	if len(event.Diff) == 0 {
+		event.Diff = json.RawMessage("{}")
+	}
+
+	tx := d.MustBegin()
+	defer tx.Close(err)
+
+	event.CreatedAt = time.Now()
+	rows, err := tx.NamedQuery(`
+INSERT INTO audit_event (actor, impersonator, action, target, ip, request_id, diff, created_at)
+VALUES (:actor, :impersonator, :action, :target, :ip, :request_id, :diff, :created_at)
+RETURNING id
+`, event)
+	if err != nil {
+		return nil, err
+	}
+	event.Id = mustGetId(rows)
+
+	return event, nil
+}
+
+// Fetches the audit events matching the filter, latest events first
+func (d *Database) FetchAuditEvents(filter AuditFilter) ([]*AuditEvent, error) {
+	var err error
+	tx := d.MustBegin()
+	defer tx.Close(err)
+
+	var conditions []string
+	var args []interface{}
+	addCondition := func(condition string, arg interface{}) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+
+	if filter.Actor != "" {
+		addCondition("actor = $%d", filter.Actor)
+	}
+	if filter.Action != "" {
+		addCondition("action = $%d", filter.Action)
+	}
+	if filter.Target != "" {
+		addCondition("target = $%d", filter.Target)
+	}
+	if !filter.Since.IsZero() {
+		addCondition("created_at >= $%d", filter.Since)
+	}
+	if !filter.Until.IsZero() {
+		addCondition("created_at < $%d", filter.Until)
+	}
+
+	query := "SELECT * FROM audit_event"
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += " ORDER BY created_at DESC, id DESC"
+	if filter.Limit > 0 {
+		query += fmt.Sprintf(" LIMIT %d", filter.Limit)
+	}
+
+	var events []*AuditEvent
+	err = tx.Select(&events, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
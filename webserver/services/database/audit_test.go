@@ -0,0 +1,80 @@
+package database_test
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/dhui/dktest"
+	"github.com/stretchr/testify/require"
+
+	. "private-sphinx-docs/services/database"
+)
+
+func TestDatabase_CreateAuditEvent(t *testing.T) {
+	t.Parallel()
+	assert := require.New(t)
+
+	dktest.Run(t, imageName, postgresImageOptions, func(t *testing.T, info dktest.ContainerInfo) {
+		db, err := newTestDb(info)
+		assert.NoError(err)
+		defer closeDb(db)
+
+		for _, r := range []struct {
+			Event    *AuditEvent
+			HasError bool
+		}{
+			{&AuditEvent{Actor: admin, Action: "project.delete", Target: project1}, false},
+			{&AuditEvent{Actor: admin, Action: "account.update", Diff: json.RawMessage(`{"username":{"old":"a","new":"b"}}`)}, false},
+			{&AuditEvent{Actor: "", Action: "project.delete"}, true},
+			{&AuditEvent{Actor: admin, Action: ""}, true},
+		} {
+			event, err := db.CreateAuditEvent(r.Event)
+			if r.HasError {
+				assert.Error(err)
+			} else {
+				assert.NoError(err)
+				assert.Greater(event.Id, 0)
+			}
+		}
+	})
+}
+
+func TestDatabase_FetchAuditEvents(t *testing.T) {
+	t.Parallel()
+	assert := require.New(t)
+
+	dktest.Run(t, imageName, postgresImageOptions, func(t *testing.T, info dktest.ContainerInfo) {
+		db, err := newTestDb(info, seedAuditEvents)
+		assert.NoError(err)
+		defer closeDb(db)
+
+		for _, r := range []struct {
+			Filter AuditFilter
+			Count  int
+		}{
+			{AuditFilter{}, 3},
+			{AuditFilter{Actor: admin}, 2},
+			{AuditFilter{Action: "project.upload", Target: project1}, 1},
+			{AuditFilter{Limit: 1}, 1},
+			{AuditFilter{Since: time.Now().Add(time.Hour)}, 0},
+		} {
+			events, err := db.FetchAuditEvents(r.Filter)
+			assert.NoError(err)
+			assert.Len(events, r.Count)
+		}
+	})
+}
+
+func seedAuditEvents(db *Database) error {
+	for _, e := range []*AuditEvent{
+		{Actor: admin, Action: "account.create", Target: user1},
+		{Actor: admin, Action: "project.upload", Target: project1},
+		{Actor: user1, Action: "project.upload", Target: "Project2"},
+	} {
+		if _, err := db.CreateAuditEvent(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
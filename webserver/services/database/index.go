@@ -129,8 +129,11 @@ func (t *Tx) Close(err error) {
 }
 
 // Use this after inserting data into the database. The query should have a
-// "RETURNING id" at the end
+// "RETURNING id" at the end. The rows are closed so the transaction can run
+// further statements
 func mustGetId(rows *sqlx.Rows) int {
+	defer func() { _ = rows.Close() }()
+
 	var id int
 	for rows.Next() {
 		err := rows.Scan(&id)
@@ -0,0 +1,172 @@
+package database
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+)
+
+// The intersphinx inventory (objects.inv) published with a version of a project
+type Inventory struct {
+	Id        int                `json:"id"`
+	ProjectId int                `json:"projectId" db:"project_id"`
+	Project   string             `json:"project"`
+	Version   string             `json:"version"`
+	UpdatedAt time.Time          `json:"updatedAt" db:"updated_at"`
+	Objects   []*InventoryObject `json:"-" db:"-"`
+}
+
+type InventoryObject struct {
+	Id          int    `json:"-"`
+	InventoryId int    `json:"-" db:"inventory_id"`
+	Project     string `json:"project"`
+	Version     string `json:"version"`
+	Name        string `json:"name"`
+	Type        string `json:"type"`
+	Priority    int    `json:"priority"`
+	URI         string `json:"uri" db:"uri"`
+	DisplayName string `json:"displayName" db:"display_name"`
+}
+
+// Filters used when looking up inventory objects. Zero values are ignored except for the name
+type XrefFilter struct {
+	Name    string
+	Type    string
+	Project string
+	Version string
+	Limit   int
+}
+
+const inventoryQuery = `
+SELECT i.*, p.title AS project
+FROM inventory i
+         INNER JOIN project p ON p.id = i.project_id
+`
+
+// Replaces the objects of the inventory of the project version
+func (d *Database) SaveInventory(projectId int, version string, objects []*InventoryObject) (inv *Inventory, err error) {
+	if projectId <= 0 {
+		return nil, errors.New("inventory must have valid project Id")
+	}
+
+	tx := d.MustBegin()
+	// the objects are replaced with several statements, none of them must be kept on errors
+	defer func() { tx.Close(err) }()
+
+	inv = &Inventory{ProjectId: projectId, Version: version, UpdatedAt: time.Now()}
+	rows, err := tx.NamedQuery(`
+INSERT INTO inventory (project_id, version, updated_at)
+VALUES (:project_id, :version, :updated_at)
+ON CONFLICT (project_id, version) DO UPDATE SET updated_at = excluded.updated_at
+RETURNING id
+`, inv)
+	if err != nil {
+		return nil, err
+	}
+	inv.Id = mustGetId(rows)
+
+	if _, err = tx.Exec(`DELETE FROM inventory_object WHERE inventory_id = $1`, inv.Id); err != nil {
+		return nil, err
+	}
+
+	stmt, err := tx.Prepare(pq.CopyIn("inventory_object", "inventory_id", "name", "type", "priority", "uri", "display_name"))
+	if err != nil {
+		return nil, err
+	}
+	for _, o := range objects {
+		if _, err = stmt.Exec(inv.Id, o.Name, o.Type, o.Priority, o.URI, o.DisplayName); err != nil {
+			_ = stmt.Close()
+			return nil, err
+		}
+		o.InventoryId = inv.Id
+	}
+	if _, err = stmt.Exec(); err != nil {
+		_ = stmt.Close()
+		return nil, err
+	}
+	if err = stmt.Close(); err != nil {
+		return nil, err
+	}
+
+	inv.Objects = objects
+	return inv, nil
+}
+
+// Fetches the inventory of the project version with its objects. An empty version fetches the
+// latest published inventory
+func (d *Database) FetchInventory(title, version string) (*Inventory, error) {
+	var err error
+	tx := d.MustBegin()
+	defer tx.Close(err)
+
+	inv := &Inventory{}
+	if version == "" {
+		err = tx.Get(inv, inventoryQuery+"WHERE p.title = $1 ORDER BY i.updated_at DESC, i.id DESC LIMIT 1", title)
+	} else {
+		err = tx.Get(inv, inventoryQuery+"WHERE p.title = $1 AND i.version = $2", title, version)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Select(&inv.Objects, "SELECT * FROM inventory_object WHERE inventory_id = $1 ORDER BY id", inv.Id)
+	if err != nil {
+		return nil, err
+	}
+	for _, o := range inv.Objects {
+		o.Project, o.Version = inv.Project, inv.Version
+	}
+
+	return inv, nil
+}
+
+// Finds the inventory objects with the name, latest version of each project first
+func (d *Database) FindInventoryObjects(filter XrefFilter) ([]*InventoryObject, error) {
+	if strings.TrimSpace(filter.Name) == "" {
+		return nil, errors.New("name of the object must be specified")
+	}
+
+	var err error
+	tx := d.MustBegin()
+	defer tx.Close(err)
+
+	var conditions []string
+	var args []interface{}
+	addCondition := func(condition string, arg interface{}) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+
+	addCondition("o.name = $%d", filter.Name)
+	if filter.Type != "" {
+		addCondition("o.type = $%d", filter.Type)
+	}
+	if filter.Project != "" {
+		addCondition("p.title = $%d", filter.Project)
+	}
+	if filter.Version != "" {
+		addCondition("i.version = $%d", filter.Version)
+	}
+
+	query := `
+SELECT o.*, p.title AS project, i.version
+FROM inventory_object o
+         INNER JOIN inventory i ON i.id = o.inventory_id
+         INNER JOIN project p ON p.id = i.project_id
+WHERE ` + strings.Join(conditions, " AND ") + `
+ORDER BY p.title, i.updated_at DESC, o.priority, o.id`
+	if filter.Limit > 0 {
+		query += fmt.Sprintf(" LIMIT %d", filter.Limit)
+	}
+
+	var objects []*InventoryObject
+	err = tx.Select(&objects, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	return objects, nil
+}
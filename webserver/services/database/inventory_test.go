@@ -0,0 +1,94 @@
+package database_test
+
+import (
+	"testing"
+
+	"github.com/dhui/dktest"
+	"github.com/stretchr/testify/require"
+
+	. "private-sphinx-docs/services/database"
+)
+
+func TestDatabase_SaveInventory(t *testing.T) {
+	t.Parallel()
+	assert := require.New(t)
+
+	dktest.Run(t, imageName, postgresImageOptions, func(t *testing.T, info dktest.ContainerInfo) {
+		db, err := newTestDb(info, seedAccounts, seedProjects)
+		assert.NoError(err)
+		defer closeDb(db)
+
+		proj, err := db.FetchProject(project1)
+		assert.NoError(err)
+
+		_, err = db.SaveInventory(0, "1.0", nil)
+		assert.Error(err)
+
+		for _, objects := range [][]*InventoryObject{
+			{{Name: "pkg.a", Type: "py:function", Priority: 1, URI: "api.html#$", DisplayName: "-"}},
+			{{Name: "pkg.b", Type: "py:function", Priority: 1, URI: "api.html#$", DisplayName: "-"}},
+		} {
+			inv, err := db.SaveInventory(proj.Id, "1.0", objects)
+			assert.NoError(err)
+			assert.Greater(inv.Id, 0)
+		}
+
+		// saving a version again replaces its objects
+		inv, err := db.FetchInventory(project1, "1.0")
+		assert.NoError(err)
+		assert.Equal(project1, inv.Project)
+		assert.Len(inv.Objects, 1)
+		assert.Equal("pkg.b", inv.Objects[0].Name)
+		assert.Equal("1.0", inv.Objects[0].Version)
+
+		_, err = db.FetchInventory(project1, "2.0")
+		assert.Error(err)
+	})
+}
+
+func TestDatabase_FindInventoryObjects(t *testing.T) {
+	t.Parallel()
+	assert := require.New(t)
+
+	dktest.Run(t, imageName, postgresImageOptions, func(t *testing.T, info dktest.ContainerInfo) {
+		db, err := newTestDb(info, seedAccounts, seedProjects)
+		assert.NoError(err)
+		defer closeDb(db)
+
+		proj, err := db.FetchProject(project1)
+		assert.NoError(err)
+
+		for _, version := range []string{"1.0", "2.0"} {
+			_, err := db.SaveInventory(proj.Id, version, []*InventoryObject{
+				{Name: "pkg.Client", Type: "py:class", Priority: 1, URI: "api.html#$", DisplayName: "-"},
+				{Name: "pkg.Client", Type: "std:label", Priority: -1, URI: "client.html", DisplayName: "Client"},
+			})
+			assert.NoError(err)
+		}
+
+		// the latest published version comes first
+		objects, err := db.FindInventoryObjects(XrefFilter{Name: "pkg.Client"})
+		assert.NoError(err)
+		assert.Len(objects, 4)
+		assert.Equal("2.0", objects[0].Version)
+		assert.Equal(project1, objects[0].Project)
+
+		for _, r := range []struct {
+			Filter   XrefFilter
+			Expected int
+		}{
+			{XrefFilter{Name: "pkg.Client", Type: "py:class"}, 2},
+			{XrefFilter{Name: "pkg.Client", Version: "1.0"}, 2},
+			{XrefFilter{Name: "pkg.Client", Project: "unknown"}, 0},
+			{XrefFilter{Name: "pkg.Client", Limit: 1}, 1},
+			{XrefFilter{Name: "pkg.Server"}, 0},
+		} {
+			objects, err := db.FindInventoryObjects(r.Filter)
+			assert.NoError(err)
+			assert.Len(objects, r.Expected)
+		}
+
+		_, err = db.FindInventoryObjects(XrefFilter{})
+		assert.Error(err)
+	})
+}
@@ -42,6 +42,77 @@ CREATE TABLE project
     last_update TIMESTAMP DEFAULT NOW(),
     account_id  INT REFERENCES account (id) ON UPDATE CASCADE ON DELETE CASCADE
 );
+`,
+		"02_audit": `CREATE TABLE audit_event
+(
+    id           SERIAL PRIMARY KEY,
+    actor        VARCHAR(255) NOT NULL,
+    impersonator VARCHAR(255) NOT NULL DEFAULT '',
+    action       VARCHAR(64)  NOT NULL,
+    target       VARCHAR(255) NOT NULL DEFAULT '',
+    ip           VARCHAR(64)  NOT NULL DEFAULT '',
+    request_id   VARCHAR(255) NOT NULL DEFAULT '',
+    diff         JSONB        NOT NULL DEFAULT '{}',
+    created_at   TIMESTAMP             DEFAULT NOW()
+);
+
+CREATE INDEX audit_event_created_at_idx ON audit_event (created_at);
+CREATE INDEX audit_event_actor_idx ON audit_event (actor);
+CREATE INDEX audit_event_target_idx ON audit_event (target);
+`,
+		"03_revisions": `CREATE TABLE revision
+(
+    id         SERIAL PRIMARY KEY,
+    project_id INT REFERENCES project (id) ON UPDATE CASCADE ON DELETE CASCADE NOT NULL,
+    account_id INT REFERENCES account (id) ON UPDATE CASCADE ON DELETE SET NULL,
+    size       BIGINT       NOT NULL,
+    checksum   VARCHAR(64)  NOT NULL,
+    artifact   VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP DEFAULT NOW()
+);
+
+CREATE INDEX revision_project_id_idx ON revision (project_id);
+
+ALTER TABLE project
+    ADD COLUMN revision_id INT REFERENCES revision (id) ON UPDATE CASCADE ON DELETE SET NULL;
+`,
+		"04_inventories": `CREATE TABLE inventory
+(
+    id         SERIAL PRIMARY KEY,
+    project_id INT REFERENCES project (id) ON UPDATE CASCADE ON DELETE CASCADE NOT NULL,
+    version    VARCHAR(255) NOT NULL,
+    updated_at TIMESTAMP DEFAULT NOW(),
+    UNIQUE (project_id, version)
+);
+
+CREATE TABLE inventory_object
+(
+    id           SERIAL PRIMARY KEY,
+    inventory_id INT REFERENCES inventory (id) ON UPDATE CASCADE ON DELETE CASCADE NOT NULL,
+    name         TEXT         NOT NULL,
+    type         VARCHAR(255) NOT NULL,
+    priority     INT          NOT NULL,
+    uri          TEXT         NOT NULL,
+    display_name TEXT         NOT NULL
+);
+
+CREATE INDEX inventory_object_inventory_id_idx ON inventory_object (inventory_id);
+CREATE INDEX inventory_object_name_idx ON inventory_object (name);
+`,
+		"05_project_metadata": `ALTER TABLE project
+    ADD COLUMN description       TEXT          NOT NULL DEFAULT '',
+    ADD COLUMN repository_url    VARCHAR(2048) NOT NULL DEFAULT '',
+    ADD COLUMN issue_tracker_url VARCHAR(2048) NOT NULL DEFAULT '',
+    ADD COLUMN contacts          TEXT[]        NOT NULL DEFAULT '{}',
+    ADD COLUMN tags              TEXT[]        NOT NULL DEFAULT '{}';
+
+CREATE INDEX project_tags_idx ON project USING GIN (tags);
+`,
+		"06_revision_stats": `ALTER TABLE revision
+    ADD COLUMN file_count INT          NOT NULL DEFAULT 0,
+    ADD COLUMN total_size BIGINT       NOT NULL DEFAULT 0,
+    ADD COLUMN has_index  BOOLEAN      NOT NULL DEFAULT FALSE,
+    ADD COLUMN version    VARCHAR(255) NOT NULL DEFAULT '';
 `,
 	}
 
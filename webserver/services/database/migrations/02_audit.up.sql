@@ -0,0 +1,16 @@
+CREATE TABLE audit_event
+(
+    id           SERIAL PRIMARY KEY,
+    actor        VARCHAR(255) NOT NULL,
+    impersonator VARCHAR(255) NOT NULL DEFAULT '',
+    action       VARCHAR(64)  NOT NULL,
+    target       VARCHAR(255) NOT NULL DEFAULT '',
+    ip           VARCHAR(64)  NOT NULL DEFAULT '',
+    request_id   VARCHAR(255) NOT NULL DEFAULT '',
+    diff         JSONB        NOT NULL DEFAULT '{}',
+    created_at   TIMESTAMP             DEFAULT NOW()
+);
+
+CREATE INDEX audit_event_created_at_idx ON audit_event (created_at);
+CREATE INDEX audit_event_actor_idx ON audit_event (actor);
+CREATE INDEX audit_event_target_idx ON audit_event (target);
@@ -0,0 +1,3 @@
+ALTER TABLE project
+    DROP COLUMN IF EXISTS revision_id;
+DROP TABLE IF EXISTS revision;
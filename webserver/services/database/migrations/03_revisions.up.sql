@@ -0,0 +1,15 @@
+CREATE TABLE revision
+(
+    id         SERIAL PRIMARY KEY,
+    project_id INT REFERENCES project (id) ON UPDATE CASCADE ON DELETE CASCADE NOT NULL,
+    account_id INT REFERENCES account (id) ON UPDATE CASCADE ON DELETE SET NULL,
+    size       BIGINT       NOT NULL,
+    checksum   VARCHAR(64)  NOT NULL,
+    artifact   VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP DEFAULT NOW()
+);
+
+CREATE INDEX revision_project_id_idx ON revision (project_id);
+
+ALTER TABLE project
+    ADD COLUMN revision_id INT REFERENCES revision (id) ON UPDATE CASCADE ON DELETE SET NULL;
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS inventory_object;
+DROP TABLE IF EXISTS inventory;
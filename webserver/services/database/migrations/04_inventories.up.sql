@@ -0,0 +1,22 @@
+CREATE TABLE inventory
+(
+    id         SERIAL PRIMARY KEY,
+    project_id INT REFERENCES project (id) ON UPDATE CASCADE ON DELETE CASCADE NOT NULL,
+    version    VARCHAR(255) NOT NULL,
+    updated_at TIMESTAMP DEFAULT NOW(),
+    UNIQUE (project_id, version)
+);
+
+CREATE TABLE inventory_object
+(
+    id           SERIAL PRIMARY KEY,
+    inventory_id INT REFERENCES inventory (id) ON UPDATE CASCADE ON DELETE CASCADE NOT NULL,
+    name         TEXT         NOT NULL,
+    type         VARCHAR(255) NOT NULL,
+    priority     INT          NOT NULL,
+    uri          TEXT         NOT NULL,
+    display_name TEXT         NOT NULL
+);
+
+CREATE INDEX inventory_object_inventory_id_idx ON inventory_object (inventory_id);
+CREATE INDEX inventory_object_name_idx ON inventory_object (name);
@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS project_tags_idx;
+ALTER TABLE project
+    DROP COLUMN IF EXISTS description,
+    DROP COLUMN IF EXISTS repository_url,
+    DROP COLUMN IF EXISTS issue_tracker_url,
+    DROP COLUMN IF EXISTS contacts,
+    DROP COLUMN IF EXISTS tags;
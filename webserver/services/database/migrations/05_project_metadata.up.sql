@@ -0,0 +1,8 @@
+ALTER TABLE project
+    ADD COLUMN description       TEXT          NOT NULL DEFAULT '',
+    ADD COLUMN repository_url    VARCHAR(2048) NOT NULL DEFAULT '',
+    ADD COLUMN issue_tracker_url VARCHAR(2048) NOT NULL DEFAULT '',
+    ADD COLUMN contacts          TEXT[]        NOT NULL DEFAULT '{}',
+    ADD COLUMN tags              TEXT[]        NOT NULL DEFAULT '{}';
+
+CREATE INDEX project_tags_idx ON project USING GIN (tags);
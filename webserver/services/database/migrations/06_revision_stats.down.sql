@@ -0,0 +1,5 @@
+ALTER TABLE revision
+    DROP COLUMN IF EXISTS file_count,
+    DROP COLUMN IF EXISTS total_size,
+    DROP COLUMN IF EXISTS has_index,
+    DROP COLUMN IF EXISTS version;
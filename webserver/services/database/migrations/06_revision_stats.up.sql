@@ -0,0 +1,5 @@
+ALTER TABLE revision
+    ADD COLUMN file_count INT          NOT NULL DEFAULT 0,
+    ADD COLUMN total_size BIGINT       NOT NULL DEFAULT 0,
+    ADD COLUMN has_index  BOOLEAN      NOT NULL DEFAULT FALSE,
+    ADD COLUMN version    VARCHAR(255) NOT NULL DEFAULT '';
@@ -2,24 +2,128 @@ package database
 
 import (
 	"database/sql"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
 	"time"
 
+	"github.com/lib/pq"
 	"github.com/pkg/errors"
 )
 
+const (
+	maxDescriptionLength = 65536
+	maxTagLength         = 50
+	maxTags              = 20
+	maxContacts          = 20
+)
+
+var tagPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9._+-]*$`)
+
 type Project struct {
 	Id         int       `json:"id"`
 	Title      string    `json:"title"`
 	LastUpdate time.Time `json:"lastUpdate" db:"last_update"`
 	AccountId  int       `json:"-" db:"account_id"`
+	RevisionId *int      `json:"revisionId" db:"revision_id"`
+
+	// Markdown description of the project
+	Description     string         `json:"description"`
+	RepositoryURL   string         `json:"repositoryUrl" db:"repository_url"`
+	IssueTrackerURL string         `json:"issueTrackerUrl" db:"issue_tracker_url"`
+	Contacts        pq.StringArray `json:"contacts"`
+	Tags            pq.StringArray `json:"tags"`
+}
+
+type ProjectSort string
+
+const (
+	SortByTitle      ProjectSort = "title"
+	SortByLastUpdate ProjectSort = "last_update"
+)
+
+// Filters used when querying the projects. Zero values are ignored
+type ProjectFilter struct {
+	AccountId int
+	// Projects must have every tag
+	Tags []string
+	// Case insensitive prefix of the title
+	TitlePrefix string
+	// Projects updated at or after
+	UpdatedSince time.Time
+
+	// Sorted by title unless specified
+	Sort       ProjectSort
+	Descending bool
+	// Cursor of the page, only the projects sorted after this one are fetched
+	After *Project
+	Limit int
+}
+
+// Projects matching a filter
+type ProjectPage struct {
+	Projects []*Project
+	// Number of projects matching the filter on every page
+	Total int
 }
 
+// Escapes the wildcards of LIKE patterns
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
 func (p *Project) Validate() error {
 	if len(p.Title) < 2 {
 		return errors.New("project title must have 2 or more characters")
 	} else if p.AccountId <= 0 {
 		return errors.New("project must have valid account Id")
 	}
+	return p.ValidateMetadata()
+}
+
+// Validates the optional metadata of the project
+func (p *Project) ValidateMetadata() error {
+	if len(p.Description) > maxDescriptionLength {
+		return errors.Errorf("project description must not exceed %d characters", maxDescriptionLength)
+	}
+	if err := validateURL(p.RepositoryURL); err != nil {
+		return errors.Wrap(err, "invalid repository url")
+	}
+	if err := validateURL(p.IssueTrackerURL); err != nil {
+		return errors.Wrap(err, "invalid issue tracker url")
+	}
+
+	if len(p.Contacts) > maxContacts {
+		return errors.Errorf("project must not have more than %d contacts", maxContacts)
+	}
+	for _, c := range p.Contacts {
+		if strings.TrimSpace(c) == "" {
+			return errors.New("project contacts must not be empty")
+		}
+	}
+
+	if len(p.Tags) > maxTags {
+		return errors.Errorf("project must not have more than %d tags", maxTags)
+	}
+	for _, t := range p.Tags {
+		if len(t) > maxTagLength || !tagPattern.MatchString(t) {
+			return errors.Errorf("invalid tag '%s', tags are lowercase letters, digits, '.', '_', '+' or '-'", t)
+		}
+	}
+	return nil
+}
+
+// Only absolute http(s) urls are accepted so they can be linked safely. Empty urls are valid
+func validateURL(value string) error {
+	if value == "" {
+		return nil
+	}
+	u, err := url.Parse(value)
+	if err != nil {
+		return err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.Errorf("'%s' is not an absolute http(s) url", value)
+	}
 	return nil
 }
 
@@ -65,6 +169,41 @@ func (d *Database) FetchProjects() ([]*Project, error) {
 	return projects, nil
 }
 
+// Fetches every tag used by a project, sorted
+func (d *Database) FetchProjectTags() ([]string, error) {
+	var err error
+	tx := d.MustBegin()
+	defer tx.Close(err)
+
+	var tags []string
+	err = tx.Select(&tags, "SELECT DISTINCT unnest(tags) AS tag FROM project ORDER BY tag")
+	if err != nil {
+		return nil, err
+	}
+
+	return tags, nil
+}
+
+// Fetches the accounts owning at least one project, sorted by username. Only the id and
+// username of the accounts are loaded
+func (d *Database) FetchProjectOwners() ([]*Account, error) {
+	var err error
+	tx := d.MustBegin()
+	defer tx.Close(err)
+
+	var owners []*Account
+	err = tx.Select(&owners, `
+SELECT DISTINCT a.id, a.username
+FROM account a
+         JOIN project p ON p.account_id = a.id
+ORDER BY a.username`)
+	if err != nil {
+		return nil, err
+	}
+
+	return owners, nil
+}
+
 func (d *Database) FetchProjectsByAccount(accountId int) ([]*Project, error) {
 	var err error
 	tx := d.MustBegin()
@@ -82,6 +221,81 @@ func (d *Database) FetchProjectsByAccount(accountId int) ([]*Project, error) {
 	return projects, nil
 }
 
+// Fetches a page of the projects matching the filter. The total counts every matching project
+// regardless of the cursor and limit
+func (d *Database) QueryProjects(filter ProjectFilter) (*ProjectPage, error) {
+	column := string(filter.Sort)
+	switch filter.Sort {
+	case "":
+		column = string(SortByTitle)
+	case SortByTitle, SortByLastUpdate:
+	default:
+		return nil, errors.Errorf("cannot sort projects by '%s'", filter.Sort)
+	}
+
+	var err error
+	tx := d.MustBegin()
+	defer tx.Close(err)
+
+	var conditions []string
+	var args []interface{}
+	addCondition := func(condition string, arg interface{}) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+
+	if filter.AccountId > 0 {
+		addCondition("account_id = $%d", filter.AccountId)
+	}
+	if len(filter.Tags) > 0 {
+		addCondition("tags @> $%d", pq.StringArray(filter.Tags))
+	}
+	if filter.TitlePrefix != "" {
+		addCondition("title ILIKE $%d", likeEscaper.Replace(filter.TitlePrefix)+"%")
+	}
+	if !filter.UpdatedSince.IsZero() {
+		// last_update holds the local time of the server without time zone
+		addCondition("last_update >= $%d", filter.UpdatedSince.In(time.Local))
+	}
+
+	where := ""
+	if len(conditions) > 0 {
+		where = " WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	page := &ProjectPage{}
+	err = tx.Get(&page.Total, "SELECT COUNT(*) FROM project"+where, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	direction, compare := "ASC", ">"
+	if filter.Descending {
+		direction, compare = "DESC", "<"
+	}
+	if filter.After != nil {
+		var value interface{} = filter.After.Title
+		if column == string(SortByLastUpdate) {
+			value = filter.After.LastUpdate
+		}
+		args = append(args, value, filter.After.Id)
+		conditions = append(conditions, fmt.Sprintf("(%s, id) %s ($%d, $%d)", column, compare, len(args)-1, len(args)))
+		where = " WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	query := "SELECT * FROM project" + where + fmt.Sprintf(" ORDER BY %[1]s %[2]s, id %[2]s", column, direction)
+	if filter.Limit > 0 {
+		query += fmt.Sprintf(" LIMIT %d", filter.Limit)
+	}
+
+	err = tx.Select(&page.Projects, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	return page, nil
+}
+
 func (d *Database) CreateOrUpdateProject(accountId int, title string) (*Project, error) {
 	var err error
 
@@ -118,9 +332,10 @@ func (d *Database) CreateProject(project *Project) (*Project, error) {
 	defer tx.Close(err)
 
 	project.LastUpdate = time.Now()
+	project.normalize()
 	rows, err := tx.NamedQuery(`
-INSERT INTO project (title, last_update, account_id) 
-VALUES (:title, :last_update, :account_id)
+INSERT INTO project (title, last_update, account_id, description, repository_url, issue_tracker_url, contacts, tags)
+VALUES (:title, :last_update, :account_id, :description, :repository_url, :issue_tracker_url, :contacts, :tags)
 RETURNING id
 `, project)
 	if err != nil {
@@ -158,6 +373,46 @@ WHERE id = :id
 	return project, nil
 }
 
+// Updates the metadata (description, links, contacts and tags) of the project. The last
+// update is left as is since the documentation did not change
+func (d *Database) UpdateProjectMetadata(project *Project) (*Project, error) {
+	err := project.ValidateMetadata()
+	if err != nil {
+		return nil, err
+	}
+
+	tx := d.MustBegin()
+	defer tx.Close(err)
+
+	project.normalize()
+	n, err := tx.NamedExec(`
+UPDATE project
+SET description = :description,
+    repository_url = :repository_url,
+    issue_tracker_url = :issue_tracker_url,
+    contacts = :contacts,
+    tags = :tags
+WHERE id = :id
+`, project)
+	if err != nil {
+		return nil, err
+	} else if n == 0 {
+		return nil, errors.Errorf("no project with id: %d", project.Id)
+	}
+
+	return project, nil
+}
+
+// Arrays are stored empty rather than NULL
+func (p *Project) normalize() {
+	if p.Contacts == nil {
+		p.Contacts = pq.StringArray{}
+	}
+	if p.Tags == nil {
+		p.Tags = pq.StringArray{}
+	}
+}
+
 func (d *Database) DeleteProject(title string) error {
 	proj, err := d.FetchProject(title)
 	if err != nil {
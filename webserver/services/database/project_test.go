@@ -2,6 +2,7 @@ package database_test
 
 import (
 	"testing"
+	"time"
 
 	"github.com/dhui/dktest"
 	"github.com/stretchr/testify/require"
@@ -151,6 +152,117 @@ func TestDatabase_UpdateProject(t *testing.T) {
 	})
 }
 
+func TestDatabase_UpdateProjectMetadata(t *testing.T) {
+	t.Parallel()
+	assert := require.New(t)
+
+	dktest.Run(t, imageName, postgresImageOptions, func(t *testing.T, info dktest.ContainerInfo) {
+		db, err := newTestDb(info, seedAccounts, seedProjects)
+		assert.NoError(err)
+		defer closeDb(db)
+
+		proj, err := db.FetchProject(project1)
+		assert.NoError(err)
+		assert.Empty(proj.Tags)
+
+		proj.Description = "# Project 1"
+		proj.RepositoryURL = "https://example.com/project1"
+		proj.Contacts = []string{"jane@example.com"}
+		proj.Tags = []string{"python", "docs"}
+		_, err = db.UpdateProjectMetadata(proj)
+		assert.NoError(err)
+
+		proj, err = db.FetchProject(project1)
+		assert.NoError(err)
+		assert.Equal("# Project 1", proj.Description)
+		assert.Equal("https://example.com/project1", proj.RepositoryURL)
+		assert.EqualValues([]string{"jane@example.com"}, proj.Contacts)
+		assert.EqualValues([]string{"python", "docs"}, proj.Tags)
+
+		tags, err := db.FetchProjectTags()
+		assert.NoError(err)
+		assert.EqualValues([]string{"docs", "python"}, tags)
+
+		owners, err := db.FetchProjectOwners()
+		assert.NoError(err)
+		assert.Len(owners, 1)
+		assert.Equal(admin, owners[0].Username)
+		assert.Empty(owners[0].Password)
+
+		proj.IssueTrackerURL = "javascript:alert(1)"
+		_, err = db.UpdateProjectMetadata(proj)
+		assert.Error(err, "only http(s) urls")
+
+		for _, r := range []struct {
+			Filter ProjectFilter
+			Count  int
+		}{
+			{ProjectFilter{}, 2},
+			{ProjectFilter{Tags: []string{"python"}}, 1},
+			{ProjectFilter{Tags: []string{"python", "docs"}, AccountId: proj.AccountId}, 1},
+			{ProjectFilter{Tags: []string{"python", "go"}}, 0},
+		} {
+			page, err := db.QueryProjects(r.Filter)
+			assert.NoError(err)
+			assert.Len(page.Projects, r.Count)
+		}
+	})
+}
+
+func TestDatabase_QueryProjects(t *testing.T) {
+	t.Parallel()
+	assert := require.New(t)
+
+	dktest.Run(t, imageName, postgresImageOptions, func(t *testing.T, info dktest.ContainerInfo) {
+		db, err := newTestDb(info, seedAccounts, seedProjects)
+		assert.NoError(err)
+		defer closeDb(db)
+
+		acc, err := db.FetchAccount(admin)
+		assert.NoError(err)
+		_, err = db.CreateProject(&Project{Title: "project_3", AccountId: acc.Id})
+		assert.NoError(err)
+
+		titles := func(page *ProjectPage) []string {
+			var titles []string
+			for _, p := range page.Projects {
+				titles = append(titles, p.Title)
+			}
+			return titles
+		}
+
+		// pages through the projects with the cursor
+		filter := ProjectFilter{Limit: 2}
+		page, err := db.QueryProjects(filter)
+		assert.NoError(err)
+		assert.Equal(3, page.Total)
+		assert.Equal([]string{project1, "Project2"}, titles(page))
+
+		filter.After = page.Projects[1]
+		page, err = db.QueryProjects(filter)
+		assert.NoError(err)
+		assert.Equal(3, page.Total)
+		assert.Equal([]string{"project_3"}, titles(page))
+
+		page, err = db.QueryProjects(ProjectFilter{Sort: SortByLastUpdate, Descending: true})
+		assert.NoError(err)
+		assert.Equal([]string{"project_3", "Project2", project1}, titles(page))
+
+		// wildcards of the prefix are matched literally
+		page, err = db.QueryProjects(ProjectFilter{TitlePrefix: "project_"})
+		assert.NoError(err)
+		assert.Equal([]string{"project_3"}, titles(page))
+		assert.Equal(1, page.Total)
+
+		page, err = db.QueryProjects(ProjectFilter{UpdatedSince: time.Now().Add(time.Hour)})
+		assert.NoError(err)
+		assert.Empty(page.Projects)
+
+		_, err = db.QueryProjects(ProjectFilter{Sort: "size"})
+		assert.Error(err)
+	})
+}
+
 func TestDatabase_CreateOrUpdateProject(t *testing.T) {
 	t.Parallel()
 	assert := require.New(t)
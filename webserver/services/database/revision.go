@@ -0,0 +1,148 @@
+package database
+
+import (
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+)
+
+// An immutable record of an uploaded artifact. The artifact itself is kept by the file
+// handler and is referenced by its key
+type Revision struct {
+	Id        int       `json:"id"`
+	ProjectId int       `json:"projectId" db:"project_id"`
+	AccountId *int      `json:"-" db:"account_id"`
+	Uploader  string    `json:"uploader"`
+	Size      int64     `json:"size"`
+	Checksum  string    `json:"checksum"`
+	Artifact  string    `json:"-"`
+	CreatedAt time.Time `json:"createdAt" db:"created_at"`
+	Live      bool      `json:"live" db:"-"`
+
+	// Statistics of the published files computed at publish time
+	FileCount int    `json:"fileCount" db:"file_count"`
+	TotalSize int64  `json:"totalSize" db:"total_size"`
+	HasIndex  bool   `json:"hasIndex" db:"has_index"`
+	Version   string `json:"version"`
+}
+
+func (r *Revision) Validate() error {
+	if r.ProjectId <= 0 {
+		return errors.New("revision must have valid project Id")
+	} else if strings.TrimSpace(r.Checksum) == "" {
+		return errors.New("revision must have a checksum")
+	} else if strings.TrimSpace(r.Artifact) == "" {
+		return errors.New("revision must reference an artifact")
+	}
+	return nil
+}
+
+const revisionQuery = `
+SELECT r.*, COALESCE(a.username, '') AS uploader
+FROM revision r
+         LEFT JOIN account a ON a.id = r.account_id
+`
+
+func (d *Database) FetchRevision(id int) (*Revision, error) {
+	var err error
+	tx := d.MustBegin()
+	defer tx.Close(err)
+
+	rev := &Revision{}
+	err = tx.Get(rev, revisionQuery+"WHERE r.id = $1", id)
+	if err != nil {
+		return nil, err
+	}
+
+	return rev, nil
+}
+
+// Fetches all revisions of the project, latest revision first
+func (d *Database) FetchRevisions(projectId int) ([]*Revision, error) {
+	var err error
+	tx := d.MustBegin()
+	defer tx.Close(err)
+
+	var revisions []*Revision
+	err = tx.Select(&revisions, revisionQuery+"WHERE r.project_id = $1 ORDER BY r.created_at DESC, r.id DESC", projectId)
+	if err != nil {
+		return nil, err
+	}
+
+	return revisions, nil
+}
+
+// Fetches the live revision of each of the projects in a single query, keyed by the project id.
+// Projects without a live revision are left out
+func (d *Database) FetchLiveRevisions(projectIds []int) (map[int]*Revision, error) {
+	var err error
+	tx := d.MustBegin()
+	defer tx.Close(err)
+
+	var revisions []*Revision
+	err = tx.Select(&revisions, revisionQuery+"JOIN project p ON p.revision_id = r.id WHERE p.id = ANY($1)", pq.Array(projectIds))
+	if err != nil {
+		return nil, err
+	}
+
+	live := make(map[int]*Revision, len(revisions))
+	for _, rev := range revisions {
+		rev.Live = true
+		live[rev.ProjectId] = rev
+	}
+	return live, nil
+}
+
+// Creates the revision and marks it as the live revision of its project
+func (d *Database) CreateRevision(revision *Revision) (_ *Revision, err error) {
+	if err = revision.Validate(); err != nil {
+		return nil, err
+	}
+
+	tx := d.MustBegin()
+	// the revision must not be kept if it cannot be made live
+	defer func() { tx.Close(err) }()
+
+	revision.CreatedAt = time.Now()
+	rows, err := tx.NamedQuery(`
+INSERT INTO revision (project_id, account_id, size, checksum, artifact, created_at, file_count, total_size, has_index, version)
+VALUES (:project_id, :account_id, :size, :checksum, :artifact, :created_at, :file_count, :total_size, :has_index, :version)
+RETURNING id
+`, revision)
+	if err != nil {
+		return nil, err
+	}
+	revision.Id = mustGetId(rows)
+
+	_, err = tx.Exec(`UPDATE project SET revision_id = $1 WHERE id = $2`, revision.Id, revision.ProjectId)
+	if err != nil {
+		return nil, err
+	}
+	revision.Live = true
+
+	return revision, nil
+}
+
+// Marks the revision as the live revision of the project
+func (d *Database) SetLiveRevision(projectId, revisionId int) error {
+	var err error
+	tx := d.MustBegin()
+	defer tx.Close(err)
+
+	n, err := tx.Exec(`
+UPDATE project
+SET revision_id = $1,
+    last_update = $2
+WHERE id = $3
+  AND EXISTS(SELECT 1 FROM revision WHERE id = $1 AND project_id = $3)
+`, revisionId, time.Now(), projectId)
+	if err != nil {
+		return err
+	} else if n == 0 {
+		return errors.Errorf("no revision %d for project with id: %d", revisionId, projectId)
+	}
+
+	return nil
+}
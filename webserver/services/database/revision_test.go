@@ -0,0 +1,206 @@
+package database_test
+
+import (
+	"testing"
+
+	"github.com/dhui/dktest"
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/require"
+
+	. "private-sphinx-docs/services/database"
+)
+
+func TestDatabase_CreateRevision(t *testing.T) {
+	t.Parallel()
+	assert := require.New(t)
+
+	dktest.Run(t, imageName, postgresImageOptions, func(t *testing.T, info dktest.ContainerInfo) {
+		db, err := newTestDb(info, seedAccounts, seedProjects)
+		assert.NoError(err)
+		defer closeDb(db)
+
+		proj, err := db.FetchProject(project1)
+		assert.NoError(err)
+
+		for _, r := range []struct {
+			Revision *Revision
+			HasError bool
+		}{
+			{&Revision{ProjectId: proj.Id, AccountId: &proj.AccountId, Size: 10, Checksum: "abc", Artifact: "1-abc",
+				FileCount: 3, TotalSize: 30, HasIndex: true, Version: "1.0"}, false},
+			{&Revision{ProjectId: proj.Id, Size: 10, Checksum: "abc", Artifact: "2-abc"}, false},
+			{&Revision{ProjectId: proj.Id, Size: 10, Checksum: "", Artifact: "3-abc"}, true},
+			{&Revision{ProjectId: 0, Size: 10, Checksum: "abc", Artifact: "4-abc"}, true},
+		} {
+			rev, err := db.CreateRevision(r.Revision)
+			if r.HasError {
+				assert.Error(err)
+			} else {
+				assert.NoError(err)
+				assert.True(rev.Live)
+
+				proj, err := db.FetchProject(project1)
+				assert.NoError(err)
+				assert.Equal(rev.Id, *proj.RevisionId)
+
+				saved, err := db.FetchRevision(rev.Id)
+				assert.NoError(err)
+				assert.Equal(r.Revision.FileCount, saved.FileCount)
+				assert.Equal(r.Revision.TotalSize, saved.TotalSize)
+				assert.Equal(r.Revision.HasIndex, saved.HasIndex)
+				assert.Equal(r.Revision.Version, saved.Version)
+			}
+		}
+	})
+}
+
+func TestDatabase_CreateRevisionRollback(t *testing.T) {
+	t.Parallel()
+	assert := require.New(t)
+
+	dktest.Run(t, imageName, postgresImageOptions, func(t *testing.T, info dktest.ContainerInfo) {
+		db, err := newTestDb(info, seedAccounts, seedProjects)
+		assert.NoError(err)
+		defer closeDb(db)
+
+		// the project cannot be updated, so the revision cannot be made live
+		option, err := getDbOption(info)
+		assert.NoError(err)
+		conn, err := sqlx.Connect("postgres", option.ConnectionString(false))
+		assert.NoError(err)
+		defer func() { _ = conn.Close() }()
+		_, err = conn.Exec(`
+CREATE FUNCTION reject_update() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'project is read only';
+END
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER reject_update BEFORE UPDATE ON project FOR EACH ROW EXECUTE PROCEDURE reject_update();
+`)
+		assert.NoError(err)
+
+		proj, err := db.FetchProject(project1)
+		assert.NoError(err)
+		_, err = db.CreateRevision(&Revision{ProjectId: proj.Id, Size: 10, Checksum: "abc", Artifact: "1-abc"})
+		assert.Error(err)
+
+		revisions, err := db.FetchRevisions(proj.Id)
+		assert.NoError(err)
+		assert.Empty(revisions)
+	})
+}
+
+func TestDatabase_FetchRevisions(t *testing.T) {
+	t.Parallel()
+	assert := require.New(t)
+
+	dktest.Run(t, imageName, postgresImageOptions, func(t *testing.T, info dktest.ContainerInfo) {
+		db, err := newTestDb(info, seedAccounts, seedProjects, seedRevisions)
+		assert.NoError(err)
+		defer closeDb(db)
+
+		proj, err := db.FetchProject(project1)
+		assert.NoError(err)
+
+		revisions, err := db.FetchRevisions(proj.Id)
+		assert.NoError(err)
+		assert.Len(revisions, 2)
+		assert.Equal(admin, revisions[0].Uploader)
+
+		rev, err := db.FetchRevision(revisions[1].Id)
+		assert.NoError(err)
+		assert.Equal(revisions[1].Checksum, rev.Checksum)
+
+		_, err = db.FetchRevision(999)
+		assert.Error(err)
+	})
+}
+
+func TestDatabase_FetchLiveRevisions(t *testing.T) {
+	t.Parallel()
+	assert := require.New(t)
+
+	dktest.Run(t, imageName, postgresImageOptions, func(t *testing.T, info dktest.ContainerInfo) {
+		db, err := newTestDb(info, seedAccounts, seedProjects, seedRevisions)
+		assert.NoError(err)
+		defer closeDb(db)
+
+		proj, err := db.FetchProject(project1)
+		assert.NoError(err)
+		other, err := db.FetchProject("Project2")
+		assert.NoError(err)
+
+		live, err := db.FetchLiveRevisions([]int{proj.Id, other.Id})
+		assert.NoError(err)
+		assert.Len(live, 1)
+		assert.Equal(*proj.RevisionId, live[proj.Id].Id)
+		assert.Equal(admin, live[proj.Id].Uploader)
+		assert.True(live[proj.Id].Live)
+
+		live, err = db.FetchLiveRevisions(nil)
+		assert.NoError(err)
+		assert.Empty(live)
+	})
+}
+
+func TestDatabase_SetLiveRevision(t *testing.T) {
+	t.Parallel()
+	assert := require.New(t)
+
+	dktest.Run(t, imageName, postgresImageOptions, func(t *testing.T, info dktest.ContainerInfo) {
+		db, err := newTestDb(info, seedAccounts, seedProjects, seedRevisions)
+		assert.NoError(err)
+		defer closeDb(db)
+
+		proj, err := db.FetchProject(project1)
+		assert.NoError(err)
+		other, err := db.FetchProject("Project2")
+		assert.NoError(err)
+
+		revisions, err := db.FetchRevisions(proj.Id)
+		assert.NoError(err)
+
+		for _, r := range []struct {
+			ProjectId  int
+			RevisionId int
+			HasError   bool
+		}{
+			{proj.Id, revisions[1].Id, false},
+			{other.Id, revisions[1].Id, true}, // revision belongs to another project
+			{proj.Id, 999, true},
+		} {
+			err := db.SetLiveRevision(r.ProjectId, r.RevisionId)
+			if r.HasError {
+				assert.Error(err)
+			} else {
+				assert.NoError(err)
+			}
+		}
+
+		proj, err = db.FetchProject(project1)
+		assert.NoError(err)
+		assert.Equal(revisions[1].Id, *proj.RevisionId)
+	})
+}
+
+func seedRevisions(db *Database) error {
+	proj, err := db.FetchProject(project1)
+	if err != nil {
+		return err
+	}
+
+	for _, artifact := range []string{"1-abc", "2-def"} {
+		_, err := db.CreateRevision(&Revision{
+			ProjectId: proj.Id,
+			AccountId: &proj.AccountId,
+			Size:      100,
+			Checksum:  artifact[2:],
+			Artifact:  artifact,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+package intersphinx
+
+import (
+	"bufio"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	header = "# Sphinx inventory version 2"
+	// limit of the decompressed objects, protects against zip bombs
+	maxSize = 64 << 20
+)
+
+// name domain:role priority uri dispname. The name can contain spaces
+var linePattern = regexp.MustCompile(`^(.+?)\s+(\S+:\S+)\s+(-?\d+)\s+(\S*)\s+(.*)$`)
+
+// Objects documented by a Sphinx project, as listed in its objects.inv
+type Inventory struct {
+	Project string
+	Version string
+	Objects []*Object
+}
+
+type Object struct {
+	Name string `json:"name"`
+	// domain and role, e.g. py:class
+	Type     string `json:"type"`
+	Priority int    `json:"priority"`
+	// location relative to the root of the documentation. A trailing $ stands for the name
+	URI string `json:"uri"`
+	// name shown in links, - if it is the same as the name
+	DisplayName string `json:"displayName"`
+}
+
+// Location of the object relative to the root of the documentation
+func (o *Object) Location() string {
+	if strings.HasSuffix(o.URI, "$") {
+		return strings.TrimSuffix(o.URI, "$") + o.Name
+	}
+	return o.URI
+}
+
+// Parses a version 2 inventory
+func Parse(r io.Reader) (*Inventory, error) {
+	br := bufio.NewReader(r)
+	readHeader := func() (string, error) {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			return "", errors.Wrap(err, "could not read inventory header")
+		}
+		return strings.TrimRight(line, "\r\n"), nil
+	}
+
+	line, err := readHeader()
+	if err != nil {
+		return nil, err
+	} else if line != header {
+		return nil, errors.Errorf("unsupported inventory '%s'", line)
+	}
+
+	inv := &Inventory{}
+	for _, field := range []struct {
+		prefix string
+		value  *string
+	}{{"# Project: ", &inv.Project}, {"# Version: ", &inv.Version}, {"# ", nil}} {
+		line, err := readHeader()
+		if err != nil {
+			return nil, err
+		} else if !strings.HasPrefix(line, strings.TrimSpace(field.prefix)) {
+			return nil, errors.Errorf("invalid inventory header '%s'", line)
+		}
+		if field.value != nil {
+			*field.value = strings.TrimSpace(strings.TrimPrefix(line, strings.TrimSpace(field.prefix)))
+		}
+	}
+
+	zr, err := zlib.NewReader(br)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not decompress inventory")
+	}
+	defer func() { _ = zr.Close() }()
+
+	scanner := bufio.NewScanner(io.LimitReader(zr, maxSize))
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if line == "" {
+			continue
+		}
+
+		m := linePattern.FindStringSubmatch(line)
+		if m == nil {
+			// Sphinx skips the lines it cannot parse as well
+			continue
+		}
+		priority, _ := strconv.Atoi(m[3])
+		inv.Objects = append(inv.Objects, &Object{
+			Name:        m[1],
+			Type:        m[2],
+			Priority:    priority,
+			URI:         m[4],
+			DisplayName: m[5],
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrap(err, "could not read inventory")
+	}
+
+	return inv, nil
+}
+
+// Writes the inventory in the version 2 format read by intersphinx
+func (inv *Inventory) Encode(w io.Writer) error {
+	if _, err := fmt.Fprintf(w, "%s\n# Project: %s\n# Version: %s\n"+
+		"# The remainder of this file is compressed using zlib.\n", header, inv.Project, inv.Version); err != nil {
+		return err
+	}
+
+	zw := zlib.NewWriter(w)
+	bw := bufio.NewWriter(zw)
+	for _, o := range inv.Objects {
+		if _, err := fmt.Fprintf(bw, "%s %s %d %s %s\n", o.Name, o.Type, o.Priority, o.URI, o.DisplayName); err != nil {
+			return err
+		}
+	}
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	return zw.Close()
+}
@@ -0,0 +1,51 @@
+package intersphinx_test
+
+import (
+	"bytes"
+	"compress/zlib"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	. "private-sphinx-docs/services/intersphinx"
+)
+
+func TestParse(t *testing.T) {
+	t.Parallel()
+	assert := require.New(t)
+
+	var content bytes.Buffer
+	content.WriteString("# Sphinx inventory version 2\n# Project: mypkg\n# Version: 1.2\n" +
+		"# The remainder of this file is compressed using zlib.\n")
+	zw := zlib.NewWriter(&content)
+	_, _ = zw.Write([]byte("mypkg.Client py:class 1 api.html#$ -\n" +
+		"mypkg.Client.send py:method 1 api.html#mypkg.Client.send Client.send\n" +
+		"getting started std:label -1 start.html#getting-started Getting Started\n" +
+		"not an object\n"))
+	assert.NoError(zw.Close())
+
+	inv, err := Parse(bytes.NewReader(content.Bytes()))
+	assert.NoError(err)
+	assert.Equal("mypkg", inv.Project)
+	assert.Equal("1.2", inv.Version)
+	assert.Equal([]*Object{
+		{Name: "mypkg.Client", Type: "py:class", Priority: 1, URI: "api.html#$", DisplayName: "-"},
+		{Name: "mypkg.Client.send", Type: "py:method", Priority: 1, URI: "api.html#mypkg.Client.send", DisplayName: "Client.send"},
+		{Name: "getting started", Type: "std:label", Priority: -1, URI: "start.html#getting-started", DisplayName: "Getting Started"},
+	}, inv.Objects)
+	assert.Equal("api.html#mypkg.Client", inv.Objects[0].Location())
+	assert.Equal("start.html#getting-started", inv.Objects[2].Location())
+
+	// encoded inventories can be read again
+	var encoded bytes.Buffer
+	assert.NoError(inv.Encode(&encoded))
+	decoded, err := Parse(&encoded)
+	assert.NoError(err)
+	assert.Equal(inv, decoded)
+
+	_, err = Parse(strings.NewReader("# Sphinx inventory version 1\n# Project: mypkg\n"))
+	assert.Error(err)
+	_, err = Parse(strings.NewReader("# Sphinx inventory version 2\n# Project: mypkg\n# Version: 1\n# zlib\nnot compressed"))
+	assert.Error(err)
+}
@@ -0,0 +1,112 @@
+package search
+
+import (
+	"io"
+	"io/ioutil"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Sphinx sets the version in _static/documentation_options.js
+var versionPattern = regexp.MustCompile(`VERSION:\s*['"]([^'"]*)['"]`)
+
+// Elements of a Sphinx page that do not belong to its content
+var skippedClasses = []string{"headerlink", "sphinxsidebar", "related", "footer", "rst-versions"}
+
+// Extracts the title and the text of a HTML page. Only the main content of Sphinx pages is
+// kept, navigation, scripts and styles are left out.
+func ParseHTML(r io.Reader) (title, content string, err error) {
+	doc, err := html.Parse(r)
+	if err != nil {
+		return "", "", errors.Wrap(err, "could not parse page")
+	}
+
+	root := find(doc, func(n *html.Node) bool { return attr(n, "role") == "main" })
+	if root == nil {
+		if root = find(doc, func(n *html.Node) bool { return n.DataAtom == atom.Body }); root == nil {
+			root = doc
+		}
+	}
+
+	if h1 := find(root, func(n *html.Node) bool { return n.DataAtom == atom.H1 }); h1 != nil {
+		title = text(h1)
+	} else if t := find(doc, func(n *html.Node) bool { return n.DataAtom == atom.Title }); t != nil {
+		title = text(t)
+	}
+	return title, text(root), nil
+}
+
+// Reads the version from the content of Sphinx' documentation_options.js. Returns an empty
+// string if it is not set
+func DetectVersion(r io.Reader) string {
+	content, err := ioutil.ReadAll(io.LimitReader(r, 1<<20))
+	if err != nil {
+		return ""
+	}
+	if m := versionPattern.FindSubmatch(content); m != nil {
+		return strings.TrimSpace(string(m[1]))
+	}
+	return ""
+}
+
+// Finds the first node (depth first) matching the predicate
+func find(n *html.Node, match func(*html.Node) bool) *html.Node {
+	if n.Type == html.ElementNode && match(n) {
+		return n
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if found := find(c, match); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+// Collects the text under the node with the whitespace collapsed
+func text(n *html.Node) string {
+	var b strings.Builder
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		switch {
+		case n.Type == html.TextNode:
+			b.WriteString(n.Data)
+			b.WriteByte(' ')
+			return
+		case n.Type == html.ElementNode && skipped(n):
+			return
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(n)
+	return strings.Join(strings.Fields(b.String()), " ")
+}
+
+func skipped(n *html.Node) bool {
+	switch n.DataAtom {
+	case atom.Script, atom.Style, atom.Noscript, atom.Head, atom.Nav:
+		return true
+	}
+	for _, class := range strings.Fields(attr(n, "class")) {
+		for _, s := range skippedClasses {
+			if class == s {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func attr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
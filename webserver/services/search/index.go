@@ -0,0 +1,275 @@
+package search
+
+import (
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/bleve/analysis/analyzer/keyword"
+	"github.com/blevesearch/bleve/analysis/lang/en"
+	"github.com/blevesearch/bleve/mapping"
+	blevesearch "github.com/blevesearch/bleve/search"
+	"github.com/blevesearch/bleve/search/query"
+	"github.com/pkg/errors"
+
+	"private-sphinx-docs/libs"
+)
+
+const (
+	defaultLimit = 20
+	maxLimit     = 100
+	// number of documents fetched at once when listing the pages of a project
+	pageSize = 1000
+)
+
+// A HTML page of the live documentation of a project
+type Page struct {
+	Project string `json:"project"`
+	Version string `json:"version"`
+	Path    string `json:"path"`
+	Title   string `json:"title"`
+	Content string `json:"content"`
+	// sha256 checksum of the file, used to skip unchanged pages
+	Checksum string `json:"checksum"`
+}
+
+// Full text index of the HTML pages of every project, stored on the local disk
+type Index struct {
+	index bleve.Index
+	// serializes the updates of a project
+	locks sync.Map
+}
+
+type Query struct {
+	Text string
+	// Only search the pages of the project
+	Project string
+	// Only search the pages with the version
+	Version string
+	// Matches the text as a whole instead of any of its words
+	Phrase bool
+	Limit  int
+	Offset int
+}
+
+type Results struct {
+	Total uint64 `json:"total"`
+	Hits  []*Hit `json:"hits"`
+}
+
+type Hit struct {
+	Project string  `json:"project"`
+	Version string  `json:"version"`
+	Path    string  `json:"path"`
+	Title   string  `json:"title"`
+	Score   float64 `json:"score"`
+	// Excerpts of the page with the matches wrapped in <mark> tags. The rest of the text is
+	// HTML escaped
+	Snippets []string `json:"snippets"`
+}
+
+// Opens the index in folder, creating it if it does not exist
+func New(folder string) (*Index, error) {
+	if libs.PathExists(folder) {
+		index, err := bleve.Open(folder)
+		if err != nil {
+			return nil, errors.Wrapf(err, "could not open search index at '%s'", folder)
+		}
+		return &Index{index: index}, nil
+	}
+
+	if err := os.MkdirAll(folder, 0744); err != nil {
+		return nil, errors.Wrapf(err, "could not create search index folder at '%s'", folder)
+	}
+	// bleve refuses to create an index in an existing folder
+	if err := os.Remove(folder); err != nil {
+		return nil, err
+	}
+
+	index, err := bleve.New(folder, newMapping())
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not create search index at '%s'", folder)
+	}
+	return &Index{index: index}, nil
+}
+
+func newMapping() mapping.IndexMapping {
+	exact := bleve.NewTextFieldMapping()
+	exact.Analyzer = keyword.Name
+
+	text := bleve.NewTextFieldMapping()
+	text.Analyzer = en.AnalyzerName
+	text.IncludeTermVectors = true
+
+	stored := bleve.NewTextFieldMapping()
+	stored.Index = false
+	stored.IncludeInAll = false
+
+	page := bleve.NewDocumentMapping()
+	page.AddFieldMappingsAt("project", exact)
+	page.AddFieldMappingsAt("version", exact)
+	page.AddFieldMappingsAt("path", exact)
+	page.AddFieldMappingsAt("title", text)
+	page.AddFieldMappingsAt("content", text)
+	page.AddFieldMappingsAt("checksum", stored)
+
+	m := bleve.NewIndexMapping()
+	m.DefaultMapping = page
+	m.DefaultAnalyzer = en.AnalyzerName
+	return m
+}
+
+func (i *Index) Close() error {
+	return i.index.Close()
+}
+
+// Brings the pages of the project up to date. files are the checksums of the HTML pages of
+// the live documentation keyed by their path. Only the pages that are new, changed or have a
+// different version are loaded and indexed, pages that no longer exist are removed.
+func (i *Index) Update(project, version string, files map[string]string, load func(path string) (*Page, error)) (indexed, removed int, err error) {
+	unlock := i.lock(project)
+	defer unlock()
+
+	existing, err := i.pages(project)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	batch := i.index.NewBatch()
+	for path, checksum := range files {
+		if page, ok := existing[path]; ok && page.Checksum == checksum && page.Version == version {
+			continue
+		}
+
+		page, err := load(path)
+		if err != nil {
+			return 0, 0, errors.Wrapf(err, "could not read '%s'", path)
+		}
+		page.Project, page.Version, page.Path, page.Checksum = project, version, path, checksum
+		if err := batch.Index(pageId(project, path), page); err != nil {
+			return 0, 0, err
+		}
+		indexed++
+	}
+	for path := range existing {
+		if _, ok := files[path]; !ok {
+			batch.Delete(pageId(project, path))
+			removed++
+		}
+	}
+
+	if err := i.index.Batch(batch); err != nil {
+		return 0, 0, errors.Wrap(err, "could not update search index")
+	}
+	return indexed, removed, nil
+}
+
+// Removes every page of the project
+func (i *Index) Remove(project string) error {
+	_, _, err := i.Update(project, "", nil, nil)
+	return err
+}
+
+func (i *Index) Search(q Query) (*Results, error) {
+	if strings.TrimSpace(q.Text) == "" {
+		return nil, errors.New("search text must be specified")
+	}
+
+	var match []query.Query
+	for _, field := range []string{"title", "content"} {
+		var m query.Query
+		if q.Phrase {
+			phrase := bleve.NewMatchPhraseQuery(q.Text)
+			phrase.SetField(field)
+			m = phrase
+		} else {
+			words := bleve.NewMatchQuery(q.Text)
+			words.SetField(field)
+			m = words
+		}
+		if field == "title" {
+			m.(query.BoostableQuery).SetBoost(2)
+		}
+		match = append(match, m)
+	}
+
+	conjuncts := []query.Query{bleve.NewDisjunctionQuery(match...)}
+	for field, value := range map[string]string{"project": q.Project, "version": q.Version} {
+		if value != "" {
+			term := bleve.NewTermQuery(value)
+			term.SetField(field)
+			conjuncts = append(conjuncts, term)
+		}
+	}
+
+	limit := q.Limit
+	if limit <= 0 {
+		limit = defaultLimit
+	} else if limit > maxLimit {
+		limit = maxLimit
+	}
+
+	req := bleve.NewSearchRequestOptions(bleve.NewConjunctionQuery(conjuncts...), limit, q.Offset, false)
+	req.Fields = []string{"project", "version", "path", "title"}
+	req.Highlight = bleve.NewHighlightWithStyle("html")
+	req.Highlight.AddField("content")
+
+	res, err := i.index.Search(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not search index")
+	}
+
+	results := &Results{Total: res.Total, Hits: make([]*Hit, 0, len(res.Hits))}
+	for _, match := range res.Hits {
+		results.Hits = append(results.Hits, &Hit{
+			Project:  field(match, "project"),
+			Version:  field(match, "version"),
+			Path:     field(match, "path"),
+			Title:    field(match, "title"),
+			Score:    match.Score,
+			Snippets: match.Fragments["content"],
+		})
+	}
+	return results, nil
+}
+
+// Lists the indexed pages of the project keyed by path. Only the version and checksum are
+// loaded
+func (i *Index) pages(project string) (map[string]*Page, error) {
+	term := bleve.NewTermQuery(project)
+	term.SetField("project")
+
+	pages := make(map[string]*Page)
+	for offset := 0; ; offset += pageSize {
+		req := bleve.NewSearchRequestOptions(term, pageSize, offset, false)
+		req.Fields = []string{"path", "version", "checksum"}
+		res, err := i.index.Search(req)
+		if err != nil {
+			return nil, errors.Wrapf(err, "could not list indexed pages of '%s'", project)
+		}
+
+		for _, match := range res.Hits {
+			path := field(match, "path")
+			pages[path] = &Page{Path: path, Version: field(match, "version"), Checksum: field(match, "checksum")}
+		}
+		if len(res.Hits) < pageSize {
+			return pages, nil
+		}
+	}
+}
+
+func (i *Index) lock(project string) func() {
+	mu, _ := i.locks.LoadOrStore(project, &sync.Mutex{})
+	mu.(*sync.Mutex).Lock()
+	return mu.(*sync.Mutex).Unlock
+}
+
+func pageId(project, path string) string {
+	return project + "/" + path
+}
+
+func field(match *blevesearch.DocumentMatch, name string) string {
+	value, _ := match.Fields[name].(string)
+	return value
+}
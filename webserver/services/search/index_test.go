@@ -0,0 +1,127 @@
+package search_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	. "private-sphinx-docs/services/search"
+)
+
+const page = `<html>
+<head><title>Configuration — Project 1.0 documentation</title><script>var ignored = 1;</script></head>
+<body>
+<div class="sphinxsidebar">Navigation sidebar</div>
+<div role="main">
+  <h1>Configuration<a class="headerlink" href="#configuration">¶</a></h1>
+  <p>The <code>max_body_size</code> key limits the size of an upload.</p>
+</div>
+</body>
+</html>`
+
+func TestParseHTML(t *testing.T) {
+	t.Parallel()
+	assert := require.New(t)
+
+	title, content, err := ParseHTML(strings.NewReader(page))
+	assert.NoError(err)
+	assert.Equal("Configuration", title)
+	assert.Equal("Configuration The max_body_size key limits the size of an upload.", content)
+
+	assert.Equal("1.2.3", DetectVersion(strings.NewReader(`var DOCUMENTATION_OPTIONS = {
+    URL_ROOT: document.getElementById("documentation_options").getAttribute('data-url_root'),
+    VERSION: '1.2.3',
+    LANGUAGE: 'None',
+};`)))
+	assert.Equal("", DetectVersion(strings.NewReader("var x = 1;")))
+}
+
+func TestIndex(t *testing.T) {
+	t.Parallel()
+	assert := require.New(t)
+
+	folder, err := ioutil.TempDir("", "psd-search-")
+	assert.NoError(err)
+	defer func() { _ = os.RemoveAll(folder) }()
+
+	index, err := New(filepath.Join(folder, "index"))
+	assert.NoError(err)
+
+	pages := map[string]map[string]string{
+		"project": {
+			"index.html":  "Welcome to the project",
+			"config.html": "The max_body_size key limits the size of an upload",
+		},
+		"other": {
+			"index.html": "The upload size of other projects is not limited",
+		},
+	}
+	loaded := 0
+	update := func(project, version string, checksums map[string]string) (int, int) {
+		indexed, removed, err := index.Update(project, version, checksums, func(path string) (*Page, error) {
+			loaded++
+			return &Page{Title: strings.TrimSuffix(path, ".html"), Content: pages[project][path]}, nil
+		})
+		assert.NoError(err)
+		return indexed, removed
+	}
+
+	indexed, removed := update("project", "1.0", map[string]string{"index.html": "a", "config.html": "b"})
+	assert.Equal([]int{2, 0}, []int{indexed, removed})
+	indexed, _ = update("other", "2.0", map[string]string{"index.html": "c"})
+	assert.Equal(1, indexed)
+
+	search := func(q Query) *Results {
+		results, err := index.Search(q)
+		assert.NoError(err)
+		return results
+	}
+
+	results := search(Query{Text: "max_body_size"})
+	assert.EqualValues(1, results.Total)
+	assert.Equal("project", results.Hits[0].Project)
+	assert.Equal("1.0", results.Hits[0].Version)
+	assert.Equal("config.html", results.Hits[0].Path)
+	assert.Equal("config", results.Hits[0].Title)
+	assert.Len(results.Hits[0].Snippets, 1)
+	assert.Contains(results.Hits[0].Snippets[0], "<mark>max_body_size</mark>")
+
+	// words match in any order, phrases only as a whole
+	assert.EqualValues(2, search(Query{Text: "size upload"}).Total)
+	assert.EqualValues(1, search(Query{Text: "upload size", Phrase: true}).Total)
+	assert.EqualValues(1, search(Query{Text: "upload", Project: "project"}).Total)
+	assert.EqualValues(1, search(Query{Text: "upload", Version: "2.0"}).Total)
+	assert.EqualValues(0, search(Query{Text: "upload", Project: "project", Version: "2.0"}).Total)
+
+	_, err = index.Search(Query{Text: " "})
+	assert.Error(err)
+
+	// only changed pages are loaded again
+	loaded = 0
+	indexed, removed = update("project", "1.0", map[string]string{"index.html": "a", "config.html": "changed"})
+	assert.Equal([]int{1, 0}, []int{indexed, removed})
+	assert.Equal(1, loaded)
+
+	indexed, removed = update("project", "1.0", map[string]string{"index.html": "a"})
+	assert.Equal([]int{0, 1}, []int{indexed, removed})
+	assert.EqualValues(0, search(Query{Text: "max_body_size"}).Total)
+
+	// a new version updates every page
+	indexed, _ = update("project", "1.1", map[string]string{"index.html": "a"})
+	assert.Equal(1, indexed)
+
+	assert.NoError(index.Remove("project"))
+	assert.EqualValues(0, search(Query{Text: "welcome"}).Total)
+	assert.EqualValues(1, search(Query{Text: "upload"}).Total)
+
+	// the index is kept on disk
+	assert.NoError(index.Close())
+	index, err = New(filepath.Join(folder, "index"))
+	assert.NoError(err)
+	defer func() { _ = index.Close() }()
+	assert.EqualValues(1, search(Query{Text: "upload"}).Total)
+}
@@ -0,0 +1,419 @@
+package search
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+
+	porterstemmer "github.com/blevesearch/go-porterstemmer"
+	"github.com/pkg/errors"
+)
+
+// Scores of the matches, the same as Sphinx' searchtools.js
+const (
+	scoreTitle        = 15
+	scorePartialTitle = 7
+	scoreTerm         = 5
+	scorePartialTerm  = 2
+	// words shorter than this are only matched exactly
+	minPartialLength = 3
+)
+
+// Words Sphinx leaves out of the English index
+var stopwords = map[string]bool{}
+
+func init() {
+	for _, w := range strings.Fields(`a and are as at be but by for if in into is it near no not of on or
+		such that the their then there these they this to was will with`) {
+		stopwords[w] = true
+	}
+}
+
+// Searches the searchindex.js files Sphinx builds with the documentation of every project.
+// The indices are kept in memory
+type SphinxIndex struct {
+	mu       sync.RWMutex
+	projects map[string]*sphinxProject
+}
+
+type sphinxProject struct {
+	version    string
+	docs       []sphinxDoc
+	terms      map[string][]int
+	titleTerms map[string][]int
+	sections   []sphinxSection
+}
+
+type sphinxDoc struct {
+	path  string
+	title string
+}
+
+type sphinxSection struct {
+	doc    int
+	anchor string
+	title  string
+}
+
+// The parts of searchindex.js that are searched
+type sphinxData struct {
+	DocNames   []string                   `json:"docnames"`
+	Titles     []string                   `json:"titles"`
+	Terms      map[string]docRefs         `json:"terms"`
+	TitleTerms map[string]docRefs         `json:"titleterms"`
+	AllTitles  map[string][]sectionAnchor `json:"alltitles"`
+}
+
+// Sphinx stores a single document as a number and several as a list
+type docRefs []int
+
+func (d *docRefs) UnmarshalJSON(b []byte) error {
+	var doc int
+	if err := json.Unmarshal(b, &doc); err == nil {
+		*d = docRefs{doc}
+		return nil
+	}
+	return json.Unmarshal(b, (*[]int)(d))
+}
+
+// [document, anchor] pair of alltitles, the anchor is null for the title of the page
+type sectionAnchor struct {
+	doc    int
+	anchor string
+}
+
+func (s *sectionAnchor) UnmarshalJSON(b []byte) error {
+	var pair []interface{}
+	if err := json.Unmarshal(b, &pair); err != nil || len(pair) != 2 {
+		return errors.Errorf("invalid title reference %s", b)
+	}
+	doc, ok := pair[0].(float64)
+	if !ok {
+		return errors.Errorf("invalid title reference %s", b)
+	}
+	s.doc = int(doc)
+	s.anchor, _ = pair[1].(string)
+	return nil
+}
+
+type SphinxResults struct {
+	Total int          `json:"total"`
+	Hits  []*SphinxHit `json:"hits"`
+}
+
+// A page, or a section of a page, matching the search
+type SphinxHit struct {
+	Project string `json:"project"`
+	Version string `json:"version"`
+	Path    string `json:"path"`
+	Anchor  string `json:"anchor,omitempty"`
+	Title   string `json:"title"`
+	// Title of the matching section, empty if the page matches
+	Section string `json:"section,omitempty"`
+	Score   int    `json:"score"`
+	// Link to the page on the subdomain of the project, set by the server
+	URL string `json:"url,omitempty"`
+}
+
+func NewSphinxIndex() *SphinxIndex {
+	return &SphinxIndex{projects: make(map[string]*sphinxProject)}
+}
+
+// Replaces the index of the project with the searchindex.js read from r. files are the files of
+// the live documentation, used to find the path of each page
+func (s *SphinxIndex) Load(project, version string, r io.Reader, files map[string]string) error {
+	content, err := ioutil.ReadAll(r)
+	if err != nil {
+		return errors.Wrap(err, "could not read searchindex.js")
+	}
+	data, err := parseSearchIndex(content)
+	if err != nil {
+		return err
+	}
+
+	p := &sphinxProject{
+		version:    version,
+		docs:       make([]sphinxDoc, len(data.DocNames)),
+		terms:      make(map[string][]int, len(data.Terms)),
+		titleTerms: make(map[string][]int, len(data.TitleTerms)),
+	}
+	for i, name := range data.DocNames {
+		p.docs[i].path = docPath(name, files)
+		if i < len(data.Titles) {
+			p.docs[i].title = data.Titles[i]
+		}
+	}
+
+	valid := func(refs docRefs) []int {
+		docs := make([]int, 0, len(refs))
+		for _, doc := range refs {
+			if doc >= 0 && doc < len(p.docs) {
+				docs = append(docs, doc)
+			}
+		}
+		return docs
+	}
+	for term, refs := range data.Terms {
+		p.terms[term] = valid(refs)
+	}
+	for term, refs := range data.TitleTerms {
+		p.titleTerms[term] = valid(refs)
+	}
+	for title, anchors := range data.AllTitles {
+		for _, a := range anchors {
+			if a.doc >= 0 && a.doc < len(p.docs) {
+				p.sections = append(p.sections, sphinxSection{doc: a.doc, anchor: a.anchor, title: title})
+			}
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.projects[project] = p
+	return nil
+}
+
+// Removes the index of the project
+func (s *SphinxIndex) Remove(project string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.projects, project)
+	return nil
+}
+
+// Ranks the pages and sections of every project the way Sphinx' own search does: pages must
+// contain every word of the text, sections must have a title containing the text.
+func (s *SphinxIndex) Search(q Query) (*SphinxResults, error) {
+	text := strings.ToLower(strings.TrimSpace(q.Text))
+	if text == "" {
+		return nil, errors.New("search text must be specified")
+	}
+
+	var words []string
+	for _, w := range strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_'
+	}) {
+		if !stopwords[w] {
+			words = append(words, w)
+		}
+	}
+
+	s.mu.RLock()
+	hits := make(map[hitKey]*SphinxHit)
+	for name, p := range s.projects {
+		if (q.Project != "" && q.Project != name) || (q.Version != "" && q.Version != p.version) {
+			continue
+		}
+		p.searchTerms(name, words, hits)
+		p.searchSections(name, text, hits)
+	}
+	s.mu.RUnlock()
+
+	results := make([]*SphinxHit, 0, len(hits))
+	for _, hit := range hits {
+		results = append(results, hit)
+	}
+	sort.Slice(results, func(i, j int) bool {
+		a, b := results[i], results[j]
+		if a.Score != b.Score {
+			return a.Score > b.Score
+		}
+		if a.Project != b.Project {
+			return a.Project < b.Project
+		}
+		if a.Path != b.Path {
+			return a.Path < b.Path
+		}
+		return a.Anchor < b.Anchor
+	})
+
+	limit := q.Limit
+	if limit <= 0 {
+		limit = defaultLimit
+	} else if limit > maxLimit {
+		limit = maxLimit
+	}
+	total := len(results)
+	if q.Offset >= total {
+		results = results[:0]
+	} else {
+		results = results[q.Offset:]
+	}
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	return &SphinxResults{Total: total, Hits: results}, nil
+}
+
+type hitKey struct {
+	project string
+	doc     int
+	anchor  string
+}
+
+// Adds the pages containing every word. A page scores as its best matching word
+func (p *sphinxProject) searchTerms(project string, words []string, hits map[hitKey]*SphinxHit) {
+	if len(words) == 0 {
+		return
+	}
+
+	var matched map[int]int
+	for _, word := range words {
+		scores := make(map[int]int)
+		match := func(docs []int, score int) {
+			for _, doc := range docs {
+				if score > scores[doc] {
+					scores[doc] = score
+				}
+			}
+		}
+
+		stem := porterstemmer.StemString(word)
+		for _, key := range []string{stem, word} {
+			match(p.terms[key], scoreTerm)
+			match(p.titleTerms[key], scoreTitle)
+		}
+		if len(word) >= minPartialLength {
+			for term, docs := range p.terms {
+				if term != stem && term != word && strings.Contains(term, word) {
+					match(docs, scorePartialTerm)
+				}
+			}
+			for term, docs := range p.titleTerms {
+				if term != stem && term != word && strings.Contains(term, word) {
+					match(docs, scorePartialTitle)
+				}
+			}
+		}
+
+		if matched == nil {
+			matched = scores
+			continue
+		}
+		for doc, score := range matched {
+			if s, ok := scores[doc]; !ok {
+				delete(matched, doc)
+			} else if s > score {
+				matched[doc] = s
+			}
+		}
+	}
+
+	for doc, score := range matched {
+		p.addHit(project, hitKey{project, doc, ""}, "", score, hits)
+	}
+}
+
+// Adds the sections with a title containing the text. Shorter titles score higher, page
+// titles are preferred over section titles
+func (p *sphinxProject) searchSections(project, text string, hits map[hitKey]*SphinxHit) {
+	for _, section := range p.sections {
+		title := strings.ToLower(strings.TrimSpace(section.title))
+		if !strings.Contains(title, text) || len(text) < len(title)/2 {
+			continue
+		}
+
+		score := int(math.Round(scoreTitle * float64(len(text)) / float64(len(title))))
+		name := section.title
+		if section.anchor == "" || p.docs[section.doc].title == section.title {
+			score++
+			name = ""
+		}
+		p.addHit(project, hitKey{project, section.doc, section.anchor}, name, score, hits)
+	}
+}
+
+func (p *sphinxProject) addHit(project string, key hitKey, section string, score int, hits map[hitKey]*SphinxHit) {
+	if hit, ok := hits[key]; ok {
+		if score > hit.Score {
+			hit.Score = score
+		}
+		return
+	}
+
+	doc := p.docs[key.doc]
+	hits[key] = &SphinxHit{
+		Project: project,
+		Version: p.version,
+		Path:    doc.path,
+		Anchor:  key.anchor,
+		Title:   doc.title,
+		Section: section,
+		Score:   score,
+	}
+}
+
+// Finds the page of a document, which depends on the Sphinx builder (html or dirhtml)
+func docPath(name string, files map[string]string) string {
+	if _, ok := files[name+".html"]; ok {
+		return name + ".html"
+	}
+	if _, ok := files[name+"/index.html"]; ok {
+		return name + "/"
+	}
+	return name + ".html"
+}
+
+// searchindex.js calls Search.setIndex with the index. Recent Sphinx versions write it as
+// JSON, older ones as a JavaScript object literal with unquoted keys
+func parseSearchIndex(content []byte) (*sphinxData, error) {
+	content = bytes.TrimSpace(content)
+	start, end := bytes.IndexByte(content, '{'), bytes.LastIndexByte(content, '}')
+	if !bytes.HasPrefix(content, []byte("Search.setIndex(")) || start < 0 || end < start {
+		return nil, errors.New("searchindex.js does not call Search.setIndex")
+	}
+
+	var data sphinxData
+	if err := json.Unmarshal(quoteKeys(content[start:end+1]), &data); err != nil {
+		return nil, errors.Wrap(err, "could not parse searchindex.js")
+	}
+	return &data, nil
+}
+
+// Converts a JavaScript object literal to JSON by quoting its unquoted keys
+func quoteKeys(js []byte) []byte {
+	out := make([]byte, 0, len(js)+len(js)/8)
+	for i := 0; i < len(js); i++ {
+		c := js[i]
+		switch {
+		case c == '"':
+			j := i + 1
+			for ; j < len(js) && js[j] != '"'; j++ {
+				if js[j] == '\\' {
+					j++
+				}
+			}
+			if j >= len(js) {
+				j = len(js) - 1
+			}
+			out = append(out, js[i:j+1]...)
+			i = j
+		case c == '_' || c == '$' || unicode.IsLetter(rune(c)):
+			j := i
+			for j < len(js) && (js[j] == '_' || js[j] == '$' || unicode.IsLetter(rune(js[j])) || unicode.IsDigit(rune(js[j]))) {
+				j++
+			}
+			k := j
+			for k < len(js) && unicode.IsSpace(rune(js[k])) {
+				k++
+			}
+			if k < len(js) && js[k] == ':' {
+				out = append(out, '"')
+				out = append(out, js[i:j]...)
+				out = append(out, '"')
+			} else {
+				out = append(out, js[i:j]...)
+			}
+			i = j - 1
+		default:
+			out = append(out, c)
+		}
+	}
+	return out
+}
@@ -0,0 +1,81 @@
+package search_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	. "private-sphinx-docs/services/search"
+)
+
+// searchindex.js of Sphinx 4, written as an object literal
+const legacyIndex = `Search.setIndex({docnames:["config","index","install"],envversion:{sphinx:56},` +
+	`filenames:["config.rst","index.rst","install.rst"],objects:{},objnames:{},objtypes:{},` +
+	`terms:{configur:[0,1],server:0,upload:[0,2],instal:2,"default":0,pip:2},` +
+	`titles:["Configuration","Welcome","Installation"],titleterms:{configur:0,welcom:1,instal:2}})`
+
+// searchindex.js of Sphinx 7, written as JSON with the titles of every section
+const jsonIndex = `Search.setIndex({"alltitles": {"Server configuration": [[0, "server-configuration"]], ` +
+	`"Configuration": [[0, null]]}, "docnames": ["config"], "filenames": ["config.rst"], ` +
+	`"terms": {"configur": 0, "port": 0}, "titles": ["Configuration"], "titleterms": {"configur": 0}})`
+
+func TestSphinxIndex(t *testing.T) {
+	t.Parallel()
+	assert := require.New(t)
+
+	index := NewSphinxIndex()
+	assert.NoError(index.Load("legacy", "1.0", strings.NewReader(legacyIndex), map[string]string{
+		"config.html": "a", "index.html": "b", "install/index.html": "c",
+	}))
+	assert.NoError(index.Load("current", "2.0", strings.NewReader(jsonIndex), nil))
+	assert.Error(index.Load("invalid", "", strings.NewReader("var x = 1;"), nil))
+
+	search := func(q Query) *SphinxResults {
+		results, err := index.Search(q)
+		assert.NoError(err)
+		return results
+	}
+	type hit struct {
+		project, path, anchor, section string
+		score                          int
+	}
+	hits := func(results *SphinxResults) []hit {
+		var hits []hit
+		for _, h := range results.Hits {
+			hits = append(hits, hit{h.Project, h.Path, h.Anchor, h.Section, h.Score})
+		}
+		return hits
+	}
+
+	// stemmed words are matched, titles score higher than the text
+	assert.Equal([]hit{
+		{"current", "config.html", "", "", 15},
+		{"legacy", "config.html", "", "", 15},
+		{"legacy", "index.html", "", "", 5},
+	}, hits(search(Query{Text: "Configuring"})))
+
+	// pages must contain every word, stop words are ignored
+	assert.Equal([]hit{{"legacy", "config.html", "", "", 5}}, hits(search(Query{Text: "the server upload"})))
+	assert.Empty(search(Query{Text: "server pip"}).Hits)
+
+	// dirhtml pages link to their folder, words match part of a term
+	assert.Equal([]hit{{"legacy", "install/", "", "", 15}}, hits(search(Query{Text: "install", Version: "1.0"})))
+	assert.Equal([]hit{{"legacy", "install/", "", "", 7}}, hits(search(Query{Text: "nstal", Project: "legacy"})))
+
+	// sections are matched by their title
+	results := search(Query{Text: "server configuration", Project: "current"})
+	assert.Equal([]hit{{"current", "config.html", "server-configuration", "Server configuration", 15}}, hits(results))
+	assert.Equal("Configuration", results.Hits[0].Title)
+	assert.Equal("2.0", results.Hits[0].Version)
+
+	results = search(Query{Text: "configuration", Limit: 1, Offset: 1})
+	assert.Equal(4, results.Total)
+	assert.Equal([]hit{{"legacy", "config.html", "", "", 15}}, hits(results))
+
+	_, err := index.Search(Query{Text: " "})
+	assert.Error(err)
+
+	assert.NoError(index.Remove("legacy"))
+	assert.Equal(2, search(Query{Text: "configuration"}).Total)
+}
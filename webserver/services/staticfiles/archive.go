@@ -0,0 +1,148 @@
+package staticfiles
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pkg/errors"
+)
+
+type archiveFormat string
+
+const (
+	formatZip    archiveFormat = "zip"
+	formatTar    archiveFormat = "tar"
+	formatTarGz  archiveFormat = "tar.gz"
+	formatTarZst archiveFormat = "tar.zst"
+)
+
+var (
+	zipMagic      = []byte("PK\x03\x04")
+	zipEmptyMagic = []byte("PK\x05\x06")
+	gzipMagic     = []byte{0x1f, 0x8b}
+	zstdMagic     = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	tarMagic      = []byte("ustar")
+	// offset of the magic field in a tar header
+	tarMagicOffset = 257
+)
+
+// Detects the archive format from its magic bytes
+func detectFormat(r io.ReaderAt, size int64) (archiveFormat, error) {
+	header := make([]byte, tarMagicOffset+len(tarMagic))
+	n, err := r.ReadAt(header, 0)
+	if err != nil && err != io.EOF {
+		return "", errors.Wrap(err, "could not read archive")
+	}
+	header = header[:n]
+
+	switch {
+	case bytes.HasPrefix(header, zipMagic), bytes.HasPrefix(header, zipEmptyMagic):
+		return formatZip, nil
+	case bytes.HasPrefix(header, gzipMagic):
+		return formatTarGz, nil
+	case bytes.HasPrefix(header, zstdMagic):
+		return formatTarZst, nil
+	case len(header) == tarMagicOffset+len(tarMagic) && bytes.Equal(header[tarMagicOffset:], tarMagic):
+		return formatTar, nil
+	}
+	return "", errors.New("unsupported archive format, upload a zip, tar, tar.gz or tar.zst archive")
+}
+
+// Extracts the archive (of any supported format) with the extractor
+func extractArchive(ex *extractor, r io.ReaderAt, size int64) error {
+	format, err := detectFormat(r, size)
+	if err != nil {
+		return err
+	}
+
+	if format == formatZip {
+		return extractZip(ex, r, size)
+	}
+
+	var content io.Reader = io.NewSectionReader(r, 0, size)
+	switch format {
+	case formatTarGz:
+		gz, err := gzip.NewReader(content)
+		if err != nil {
+			return errors.Wrap(err, "could not read gzip contents")
+		}
+		defer func() { _ = gz.Close() }()
+		content = ex.limitRatio(gz, size)
+
+	case formatTarZst:
+		zr, err := zstd.NewReader(content, zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxMemory(uint64(ex.limits.MaxTotalSize)))
+		if err != nil {
+			return errors.Wrap(err, "could not read zstd contents")
+		}
+		defer zr.Close()
+		content = ex.limitRatio(zr, size)
+	}
+
+	return extractTar(ex, content)
+}
+
+func extractZip(ex *extractor, r io.ReaderAt, size int64) error {
+	contents, err := zip.NewReader(r, size)
+	if err != nil {
+		return errors.Wrap(err, "could not read zip contents")
+	}
+
+	for _, file := range contents.File {
+		if file.FileInfo().IsDir() {
+			err = ex.dir(file.Name, file.Mode())
+		} else {
+			err = extractZipFile(ex, file)
+		}
+		if err != nil {
+			return errors.Wrapf(err, "could not extract '%s'", file.Name)
+		}
+	}
+	return nil
+}
+
+func extractZipFile(ex *extractor, f *zip.File) error {
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = rc.Close() }()
+
+	return ex.file(f.Name, f.Mode(), rc, int64(f.UncompressedSize64), int64(f.CompressedSize64))
+}
+
+func extractTar(ex *extractor, r io.Reader) error {
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return errors.Wrap(err, "could not read tar contents")
+		}
+
+		switch header.Typeflag {
+		case tar.TypeXGlobalHeader:
+			// pax metadata (i.e. from git archive), not a file
+			continue
+		case tar.TypeDir:
+			err = ex.dir(header.Name, header.FileInfo().Mode())
+		case tar.TypeReg, tar.TypeRegA:
+			err = ex.file(header.Name, header.FileInfo().Mode(), tr, header.Size, 0)
+		case tar.TypeLink:
+			err = errors.Errorf("archive entry '%s' is a hard link, links are not allowed", header.Name)
+		default:
+			// symlinks and special files are rejected by the extractor
+			err = ex.check(header.Name, header.FileInfo().Mode())
+			if err == nil {
+				err = errors.Errorf("archive entry '%s' has an unsupported type", header.Name)
+			}
+		}
+		if err != nil {
+			return errors.Wrapf(err, "could not extract '%s'", header.Name)
+		}
+	}
+}
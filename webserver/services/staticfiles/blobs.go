@@ -0,0 +1,381 @@
+package staticfiles
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"private-sphinx-docs/libs"
+)
+
+const (
+	// Folder (relative to the root) holding the content of every file once, named by its
+	// sha256 checksum
+	blobFolder = ".blobs"
+	// Folder (relative to the root) holding the manifest of every revision when deduplication
+	// is enabled. Manifests replace the uploaded artifacts
+	manifestFolder = ".manifests"
+	// Unreferenced blobs younger than this are not collected since they could belong to a
+	// revision being saved
+	blobGracePeriod = time.Hour
+)
+
+// Maps the path of every file of a revision to its blob
+type revisionManifest struct {
+	Files map[string]*manifestFile `json:"files"`
+}
+
+type manifestFile struct {
+	Checksum string `json:"sha256"`
+	Size     int64  `json:"size"`
+}
+
+// Content addressable store of files. Every distinct file is stored once and shared between
+// releases and revisions with hard links.
+type blobStore struct {
+	root string
+	// held for reading while blobs are being referenced, for writing while collecting
+	mu sync.RWMutex
+}
+
+func (b *blobStore) path(checksum string) string {
+	return filepath.Join(b.root, blobFolder, checksum[:2], checksum)
+}
+
+// Stores every file of the tree and replaces the file by a hard link to its blob. Files that
+// cannot be linked (i.e. on another device) are copied into the store.
+func (b *blobStore) ingest(tree string) (*revisionManifest, error) {
+	manifest := &revisionManifest{Files: make(map[string]*manifestFile)}
+	err := filepath.Walk(tree, func(fp string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+
+		rel, err := filepath.Rel(tree, fp)
+		if err != nil {
+			return err
+		}
+
+		checksum, err := fileChecksum(fp)
+		if err != nil {
+			return err
+		}
+
+		blob := b.path(checksum)
+		if stored, err := os.Stat(blob); err == nil {
+			// share the stored copy, keeping the file if it cannot be linked. Renaming a link
+			// over the same file is a no-op, files linked from an earlier revision are skipped
+			tmp := fp + ".blob"
+			if !os.SameFile(stored, info) && os.Link(blob, tmp) == nil {
+				if err := os.Rename(tmp, fp); err != nil {
+					_ = os.Remove(tmp)
+				}
+			}
+		} else if err := b.store(fp, blob); err != nil {
+			return errors.Wrapf(err, "could not store '%s'", rel)
+		}
+
+		manifest.Files[filepath.ToSlash(rel)] = &manifestFile{Checksum: checksum, Size: info.Size()}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+func (b *blobStore) store(fp, blob string) error {
+	if err := os.MkdirAll(filepath.Dir(blob), dirMode); err != nil {
+		return err
+	}
+
+	err := os.Link(fp, blob)
+	if err == nil || os.IsExist(err) {
+		// the same content could have been stored concurrently
+		return nil
+	}
+
+	// copy beside the blob first so that a blob is never partially written
+	tmp := blob + ".tmp"
+	if err := copyFile(fp, tmp); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, blob)
+}
+
+// Recreates the files of the manifest in dest with hard links to their blobs
+func (b *blobStore) materialise(manifest *revisionManifest, dest string) error {
+	// manifests are written by the store but are checked like archive entries anyway
+	ex := newExtractor(dest, Limits{})
+	for path, file := range manifest.Files {
+		fp, err := ex.path(path)
+		if err != nil {
+			return err
+		}
+
+		blob := b.path(file.Checksum)
+		if !libs.PathExists(blob) {
+			return errors.Errorf("content of '%s' is missing from the blob store", path)
+		}
+
+		if err := os.MkdirAll(filepath.Dir(fp), dirMode); err != nil {
+			return err
+		}
+		if err := os.Link(blob, fp); err != nil {
+			if err := copyFile(blob, fp); err != nil {
+				return errors.Wrapf(err, "could not restore '%s'", path)
+			}
+		}
+	}
+	return nil
+}
+
+// Removes the blobs that are not referenced by any manifest. Returns the number of blobs
+// removed and the bytes freed
+func (b *blobStore) collect() (removed int, freed int64, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	referenced := make(map[string]bool)
+	err = filepath.Walk(filepath.Join(b.root, manifestFolder), func(fp string, info os.FileInfo, err error) error {
+		if os.IsNotExist(err) {
+			return nil
+		} else if err != nil || info.IsDir() {
+			return err
+		}
+
+		manifest, err := readManifest(fp)
+		if err != nil {
+			return err
+		}
+		for _, file := range manifest.Files {
+			referenced[file.Checksum] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, 0, errors.Wrap(err, "could not read manifests")
+	}
+
+	err = filepath.Walk(filepath.Join(b.root, blobFolder), func(fp string, info os.FileInfo, err error) error {
+		if os.IsNotExist(err) {
+			return nil
+		} else if err != nil || info.IsDir() {
+			return err
+		}
+
+		if referenced[info.Name()] || time.Since(info.ModTime()) < blobGracePeriod {
+			return nil
+		}
+		if err := os.Remove(fp); err != nil {
+			return err
+		}
+		removed++
+		freed += info.Size()
+		return nil
+	})
+	if err != nil {
+		return removed, freed, errors.Wrap(err, "could not remove blobs")
+	}
+	return removed, freed, nil
+}
+
+// Stores the files of the tree as blobs and saves the manifest of the revision. The artifact
+// key is derived from checksum, the checksum of what was uploaded, or from the checksum of
+// the manifest itself if it is empty. Returns the artifact key, its checksum and the total
+// size of the files.
+func (f *FileSys) saveManifest(name, tree, checksum string) (artifact, sum string, n int64, err error) {
+	f.blobs.mu.RLock()
+	defer f.blobs.mu.RUnlock()
+
+	manifest, err := f.blobs.ingest(tree)
+	if err != nil {
+		return "", "", 0, err
+	}
+
+	content, err := json.Marshal(manifest)
+	if err != nil {
+		return "", "", 0, errors.Wrap(err, "could not save manifest")
+	}
+	if checksum == "" {
+		hash := sha256.Sum256(content)
+		checksum = hex.EncodeToString(hash[:])
+	}
+	for _, file := range manifest.Files {
+		n += file.Size
+	}
+
+	folder := filepath.Join(f.root, manifestFolder, projectName(name))
+	if err := os.MkdirAll(folder, 0744); err != nil {
+		return "", "", 0, errors.Wrapf(err, "could not create manifest folder at '%s'", folder)
+	}
+
+	artifact = artifactName(checksum)
+	tmp := f.manifestPath(name, artifact) + ".tmp"
+	if err := ioutil.WriteFile(tmp, content, 0644); err != nil {
+		return "", "", 0, errors.Wrap(err, "could not save manifest")
+	}
+	if err := os.Rename(tmp, f.manifestPath(name, artifact)); err != nil {
+		_ = os.Remove(tmp)
+		return "", "", 0, errors.Wrap(err, "could not save manifest")
+	}
+	return artifact, checksum, n, nil
+}
+
+// Extracts the uploaded archive and saves its files as a new revision
+func (f *FileSys) saveBlobs(r io.ReaderAt, name string, size int64) (artifact, checksum string, err error) {
+	checksum, err = contentChecksum(r, size)
+	if err != nil {
+		return "", "", err
+	}
+
+	tree, err := stageArchive(f.root, name, f.limits, r, size)
+	if err != nil {
+		return "", "", err
+	}
+	defer func() { _ = os.RemoveAll(tree) }()
+
+	artifact, _, _, err = f.saveManifest(name, tree, checksum)
+	if err != nil {
+		return "", "", err
+	}
+	return artifact, checksum, nil
+}
+
+// Publishes the upload from the stored files if its revision was saved already (see
+// SaveArtifact), otherwise extracts it
+func (f *FileSys) uploadFromBlobs(r io.ReaderAt, name string, size int64) (*Stats, error) {
+	checksum, err := contentChecksum(r, size)
+	if err != nil {
+		return nil, err
+	}
+
+	if artifact, ok := f.findManifest(name, checksum); ok {
+		tree, err := f.stageManifest(name, artifact)
+		if err == nil {
+			defer func() { _ = os.RemoveAll(tree) }()
+			return f.publish(name, tree, "")
+		}
+		log.Warnf("could not restore '%s' from the stored files, extracting the upload: %v", artifact, err)
+	}
+
+	tree, err := stageArchive(f.root, name, f.limits, r, size)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = os.RemoveAll(tree) }()
+
+	return f.publish(name, tree, "")
+}
+
+// Recreates the tree of the revision in a new staging directory. The caller is responsible
+// for removing the directory
+func (f *FileSys) stageManifest(name, artifact string) (string, error) {
+	manifest, err := readManifest(f.manifestPath(name, artifact))
+	if err != nil {
+		return "", errors.Wrapf(err, "could not read manifest of '%s'", artifact)
+	}
+
+	dest, err := newStagingDir(f.root, name)
+	if err != nil {
+		return "", err
+	}
+	if err := f.blobs.materialise(manifest, dest); err != nil {
+		_ = os.RemoveAll(dest)
+		return "", err
+	}
+	return dest, nil
+}
+
+// Finds the latest revision of the project saved from an upload with the checksum
+func (f *FileSys) findManifest(name, checksum string) (string, bool) {
+	files, err := ioutil.ReadDir(filepath.Join(f.root, manifestFolder, projectName(name)))
+	if err != nil {
+		return "", false
+	}
+
+	var matches []string
+	for _, file := range files {
+		if artifact := strings.TrimSuffix(file.Name(), ".json"); strings.HasSuffix(artifact, "-"+checksum[:12]) && artifact != file.Name() {
+			matches = append(matches, artifact)
+		}
+	}
+	if len(matches) == 0 {
+		return "", false
+	}
+
+	sort.Strings(matches)
+	return matches[len(matches)-1], true
+}
+
+func (f *FileSys) manifestPath(name, artifact string) string {
+	return filepath.Join(f.root, manifestFolder, projectName(name), filepath.Base(artifact)+".json")
+}
+
+func (f *FileSys) hasManifest(name, artifact string) bool {
+	return libs.PathExists(f.manifestPath(name, artifact))
+}
+
+// Removes the blobs that are no longer referenced by any revision. Returns the number of
+// blobs removed and the bytes freed
+func (f *FileSys) CollectBlobs() (int, int64, error) {
+	return f.blobs.collect()
+}
+
+// Periodically removes unreferenced blobs until the returned function is called
+func (f *FileSys) StartBlobCollector(interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if n, freed, err := f.CollectBlobs(); err != nil {
+					log.Errorf("could not collect unreferenced blobs: %v", err)
+				} else if n > 0 {
+					log.Infof("removed %d unreferenced blobs, freed %d bytes", n, freed)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() { close(done) }
+}
+
+func readManifest(fp string) (*revisionManifest, error) {
+	content, err := ioutil.ReadFile(fp)
+	if err != nil {
+		return nil, err
+	}
+
+	manifest := &revisionManifest{}
+	if err := json.Unmarshal(content, manifest); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// Checksum of the content of the reader
+func contentChecksum(r io.ReaderAt, size int64) (string, error) {
+	hash := sha256.New()
+	if _, err := io.Copy(hash, io.NewSectionReader(r, 0, size)); err != nil {
+		return "", errors.Wrap(err, "could not read artifact")
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
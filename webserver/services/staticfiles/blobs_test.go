@@ -0,0 +1,98 @@
+package staticfiles_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	. "private-sphinx-docs/services/staticfiles"
+)
+
+func TestFileSys_Deduplicate(t *testing.T) {
+	t.Parallel()
+	assert := require.New(t)
+
+	root, err := ioutil.TempDir("", "psd-")
+	assert.NoError(err)
+	defer func() { _ = os.RemoveAll(root) }()
+
+	fs, err := NewFileSys(&FileSysOption{Root: root, Deduplicate: true})
+	assert.NoError(err)
+
+	upload := func(name string, files map[string]string) string {
+		content := createZip(t, files)
+		artifact, _, err := fs.SaveArtifact(bytes.NewReader(content), name, int64(len(content)))
+		assert.NoError(err)
+		_, err = fs.Upload(bytes.NewReader(content), name, int64(len(content)))
+		assert.NoError(err)
+		return artifact
+	}
+	stat := func(name, path string) os.FileInfo {
+		info, err := os.Stat(filepath.Join(fs.Destination(name), filepath.FromSlash(path)))
+		assert.NoError(err)
+		return info
+	}
+
+	v1 := upload("project", map[string]string{"index.html": "v1", "_static/app.js": "js"})
+	first := stat("project", "_static/app.js")
+	upload("project", map[string]string{"index.html": "v2", "_static/app.js": "js"})
+	upload("other", map[string]string{"index.html": "other", "app.js": "js"})
+
+	// identical files are stored once across revisions and projects
+	assert.True(os.SameFile(first, stat("project", "_static/app.js")))
+	assert.True(os.SameFile(first, stat("other", "app.js")))
+	assertArchiveFile(t, fs, "index.html", "v2")
+
+	// only manifests are saved for the revisions
+	_, err = os.Stat(filepath.Join(root, ".revisions"))
+	assert.True(os.IsNotExist(err))
+
+	// revisions are restored from the stored files
+	assert.NoError(fs.Restore("project", v1))
+	assertArchiveFile(t, fs, "index.html", "v1")
+
+	// incremental uploads are saved as manifests too
+	changes := createZip(t, map[string]string{"new.html": "new"})
+	synced, _, n, _, err := fs.Sync("project", bytes.NewReader(changes), int64(len(changes)), []string{"index.html"})
+	assert.NoError(err)
+	assert.EqualValues(len("js")+len("new"), n)
+	assert.NoError(fs.Restore("project", v1))
+	assert.NoError(fs.Restore("project", synced))
+	manifest, err := fs.Manifest("project")
+	assert.NoError(err)
+	assert.Equal(map[string]string{"_static/app.js": checksum("js"), "new.html": checksum("new")}, manifest)
+
+	// blobs are kept while referenced or recently stored
+	age(t, filepath.Join(root, ".blobs"))
+	removed, _, err := fs.CollectBlobs()
+	assert.NoError(err)
+	assert.Zero(removed)
+
+	assert.NoError(fs.Remove("other"))
+	removed, freed, err := fs.CollectBlobs()
+	assert.NoError(err)
+	assert.Equal(1, removed)
+	assert.EqualValues(len("other"), freed)
+
+	assert.NoError(fs.Remove("project"))
+	removed, _, err = fs.CollectBlobs()
+	assert.NoError(err)
+	assert.Equal(4, removed)
+}
+
+// Moves the modification time of every file under root back by a day
+func age(t *testing.T, root string) {
+	past := time.Now().Add(-24 * time.Hour)
+	err := filepath.Walk(root, func(fp string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		return os.Chtimes(fp, past, past)
+	})
+	require.NoError(t, err)
+}
@@ -0,0 +1,191 @@
+package staticfiles
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// Folder (relative to the root) holding the checksums of the files of every release
+const checksumFolder = ".checksums"
+
+// Keeps the checksums of the files of the served releases keyed by the release path. Published
+// releases never change, their checksums are computed once at publish time (see saveChecksums)
+// and dropped together with the release
+type checksumCache struct {
+	mu       sync.RWMutex
+	releases map[string]map[string]string
+	// releases being loaded, concurrent readers wait for the first load
+	pending map[string]*pendingChecksums
+}
+
+type pendingChecksums struct {
+	done      chan struct{}
+	checksums map[string]string
+	err       error
+	// set if the release was evicted while it was loaded, the result is not cached
+	evicted bool
+}
+
+func (c *checksumCache) get(release string) (map[string]string, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	checksums, ok := c.releases[release]
+	return checksums, ok
+}
+
+// Gets the checksums of the release, calling load if they are not cached. Concurrent calls for
+// the same release share a single load
+func (c *checksumCache) load(release string, load func() (map[string]string, error)) (map[string]string, error) {
+	if checksums, ok := c.get(release); ok {
+		return checksums, nil
+	}
+
+	c.mu.Lock()
+	if checksums, ok := c.releases[release]; ok {
+		c.mu.Unlock()
+		return checksums, nil
+	}
+	if p, ok := c.pending[release]; ok {
+		c.mu.Unlock()
+		<-p.done
+		return p.checksums, p.err
+	}
+	p := &pendingChecksums{done: make(chan struct{})}
+	if c.pending == nil {
+		c.pending = make(map[string]*pendingChecksums)
+	}
+	c.pending[release] = p
+	c.mu.Unlock()
+
+	p.checksums, p.err = load()
+
+	c.mu.Lock()
+	delete(c.pending, release)
+	if p.err == nil && !p.evicted {
+		if c.releases == nil {
+			c.releases = make(map[string]map[string]string)
+		}
+		c.releases[release] = p.checksums
+	}
+	c.mu.Unlock()
+	close(p.done)
+
+	return p.checksums, p.err
+}
+
+// Drops the checksums of the release fp (or every release under the folder fp)
+func (c *checksumCache) evict(fp string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	matches := func(key string) bool {
+		return key == fp || strings.HasPrefix(key, fp+string(filepath.Separator))
+	}
+	for key := range c.releases {
+		if matches(key) {
+			delete(c.releases, key)
+		}
+	}
+	for key, p := range c.pending {
+		if matches(key) {
+			p.evicted = true
+		}
+	}
+}
+
+// Gets the sha256 checksum of a file of the live documentation of the project, computed when
+// it was published
+func (f *FileSys) Checksum(name, path string) (string, error) {
+	if checkName(name) != nil {
+		return "", os.ErrNotExist
+	}
+
+	release, err := filepath.EvalSymlinks(f.Destination(name))
+	if err != nil {
+		return "", err
+	}
+
+	checksums, err := f.releaseChecksums(release)
+	if err != nil {
+		return "", err
+	}
+	if checksum, ok := checksums[cleanPath(path)]; ok {
+		return checksum, nil
+	}
+
+	// not a file of the release, tell directories apart from missing files
+	file, err := f.openRelease(release, path)
+	if err != nil {
+		return "", err
+	}
+	_ = file.Close()
+	return "", errors.Errorf("'%s' is a directory", path)
+}
+
+// Gets the checksums of every file of the release keyed by its path. Releases published
+// before the checksums were saved are hashed on first use, once for all concurrent readers
+func (f *FileSys) releaseChecksums(release string) (map[string]string, error) {
+	return f.checksums.load(release, func() (map[string]string, error) {
+		var checksums map[string]string
+		content, err := ioutil.ReadFile(f.checksumsPath(release))
+		if err == nil {
+			err = json.Unmarshal(content, &checksums)
+		}
+		if err == nil {
+			return checksums, nil
+		}
+
+		if checksums, err = releaseManifest(release); err != nil {
+			return nil, err
+		}
+		if f.isRelease(release) {
+			f.saveChecksums(checksums, release)
+		}
+		return checksums, nil
+	})
+}
+
+// Computes the checksums of the staged tree (or archive) at fp that is published as release
+// and saves them beside the release. Checksum hashes the release on first use if it fails
+func (f *FileSys) hashRelease(fp, release string) {
+	checksums, err := releaseManifest(fp)
+	if err != nil {
+		log.Errorf("could not compute the checksums of release '%s': %v", release, err)
+		return
+	}
+	f.saveChecksums(checksums, release)
+}
+
+func (f *FileSys) saveChecksums(checksums map[string]string, release string) {
+	fp := f.checksumsPath(release)
+	content, err := json.Marshal(checksums)
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(fp), dirMode)
+	}
+	if err == nil {
+		err = ioutil.WriteFile(fp, content, fileMode)
+	}
+	if err != nil {
+		log.Errorf("could not save the checksums of release '%s': %v", release, err)
+		_ = os.Remove(fp)
+	}
+}
+
+// File holding the checksums of the release
+func (f *FileSys) checksumsPath(release string) string {
+	return filepath.Join(f.root, checksumFolder, filepath.Base(filepath.Dir(release)), filepath.Base(release)+".json")
+}
+
+// Checks that fp is in the release folder. Projects published before releases existed are
+// plain directories at their destination
+func (f *FileSys) isRelease(fp string) bool {
+	return filepath.Dir(filepath.Dir(fp)) == filepath.Join(f.root, releaseFolder)
+}
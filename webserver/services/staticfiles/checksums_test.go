@@ -0,0 +1,112 @@
+package staticfiles_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	. "private-sphinx-docs/services/staticfiles"
+)
+
+func TestFileSys_Checksum(t *testing.T) {
+	t.Parallel()
+
+	for _, serveArchives := range []bool{false, true} {
+		assert := require.New(t)
+
+		root, err := ioutil.TempDir("", "psd-")
+		assert.NoError(err)
+		defer func() { _ = os.RemoveAll(root) }()
+
+		fs, err := NewFileSys(&FileSysOption{Root: root, ServeArchives: serveArchives})
+		assert.NoError(err)
+
+		upload := func(files map[string]string) {
+			content := createZip(t, files)
+			_, err := fs.Upload(bytes.NewReader(content), "project", int64(len(content)))
+			assert.NoError(err)
+		}
+
+		upload(map[string]string{"index.html": "v1", "_static/app.js": "js"})
+		for _, path := range []string{"index.html", "/index.html", "index.html"} {
+			actual, err := fs.Checksum("project", path)
+			assert.NoError(err)
+			assert.Equal(checksum("v1"), actual)
+		}
+
+		// checksums follow the live release
+		upload(map[string]string{"index.html": "v2", "_static/app.js": "js"})
+		actual, err := fs.Checksum("project", "index.html")
+		assert.NoError(err)
+		assert.Equal(checksum("v2"), actual)
+
+		// computed at publish time and saved with the live release only
+		saved, err := ioutil.ReadDir(filepath.Join(root, ".checksums", "project"))
+		assert.NoError(err)
+		assert.Len(saved, 1)
+		manifest, err := fs.Manifest("project")
+		assert.NoError(err)
+		assert.Equal(map[string]string{"index.html": checksum("v2"), "_static/app.js": checksum("js")}, manifest)
+
+		// releases without saved checksums are hashed on first use
+		upload(map[string]string{"index.html": "v3"})
+		assert.NoError(os.RemoveAll(filepath.Join(root, ".checksums")))
+		actual, err = fs.Checksum("project", "index.html")
+		assert.NoError(err)
+		assert.Equal(checksum("v3"), actual)
+
+		_, err = fs.Checksum("project", "_static")
+		assert.Error(err)
+		_, err = fs.Checksum("project", "missing.html")
+		assert.True(os.IsNotExist(err))
+		_, err = fs.Checksum("missing", "index.html")
+		assert.True(os.IsNotExist(err))
+	}
+}
+
+func TestFileSys_LegacyChecksum(t *testing.T) {
+	t.Parallel()
+	assert := require.New(t)
+
+	root, err := ioutil.TempDir("", "psd-")
+	assert.NoError(err)
+	defer func() { _ = os.RemoveAll(root) }()
+
+	// project published before releases existed
+	legacy := filepath.Join(root, "legacy")
+	assert.NoError(os.MkdirAll(legacy, 0755))
+	assert.NoError(ioutil.WriteFile(filepath.Join(legacy, "index.html"), []byte("old"), 0644))
+
+	fs, err := NewFileSys(&FileSysOption{Root: root})
+	assert.NoError(err)
+
+	// concurrent readers share the checksums hashed on first use
+	var wg sync.WaitGroup
+	results := make([]string, 8)
+	errs := make([]error, len(results))
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], errs[i] = fs.Checksum("legacy", "index.html")
+		}(i)
+	}
+	wg.Wait()
+	for i := range results {
+		assert.NoError(errs[i])
+		assert.Equal(checksum("old"), results[i])
+	}
+
+	// the upload moves the directory into the release folder
+	content := createZip(t, map[string]string{"index.html": "new"})
+	_, err = fs.Upload(bytes.NewReader(content), "legacy", int64(len(content)))
+	assert.NoError(err)
+	actual, err := fs.Checksum("legacy", "index.html")
+	assert.NoError(err)
+	assert.Equal(checksum("new"), actual)
+}
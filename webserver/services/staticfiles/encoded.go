@@ -0,0 +1,212 @@
+package staticfiles
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// Folder (relative to the root) holding the precompressed files of every release
+	encodedFolder = ".encoded"
+	// Suffix of the release holding the precompressed files in the object store
+	encodedSuffix = ".encoded"
+	// Files smaller than this are not worth compressing
+	minEncodeSize = 1 << 10
+	// Brotli levels above this are too slow to compress large files (i.e. searchindex.js)
+	// at publish time
+	brotliLevel = 9
+)
+
+// Precompressed variants generated at publish time, keyed by the content encoding
+var encodings = map[string]struct {
+	ext       string
+	newWriter func(w io.Writer) io.WriteCloser
+}{
+	"br": {".br", func(w io.Writer) io.WriteCloser {
+		return brotli.NewWriterLevel(w, brotliLevel)
+	}},
+	"gzip": {".gz", func(w io.Writer) io.WriteCloser {
+		zw, _ := gzip.NewWriterLevel(w, gzip.BestCompression)
+		return zw
+	}},
+}
+
+// Extensions of the text files that are precompressed. Other files (i.e. images and fonts)
+// are compressed already
+var compressible = map[string]bool{
+	".html": true,
+	".htm":  true,
+	".css":  true,
+	".js":   true,
+	".json": true,
+	".map":  true,
+	".svg":  true,
+	".txt":  true,
+	".xml":  true,
+}
+
+func isCompressible(p string, size int64) bool {
+	return size >= minEncodeSize && compressible[strings.ToLower(path.Ext(p))]
+}
+
+// Calls fn with every file of the release at fp, which is either a directory or a zip archive
+func walkRelease(fp string, fn func(p string, size int64, open func() (io.ReadCloser, error)) error) error {
+	if isFile(fp) {
+		archive, err := openZipArchive(fp)
+		if err != nil {
+			return err
+		}
+		defer func() { _ = archive.file.Close() }()
+
+		for p, entry := range archive.files {
+			if err := fn(p, int64(entry.UncompressedSize64), entry.Open); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	return filepath.Walk(fp, func(file string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(fp, file)
+		if err != nil {
+			return err
+		}
+		return fn(filepath.ToSlash(rel), info.Size(), func() (io.ReadCloser, error) {
+			return os.Open(file)
+		})
+	})
+}
+
+// Writes the compressed content of r to dest. Returns false (and writes nothing) if the
+// compressed content is not smaller than size
+func encodeFile(r io.Reader, dest, encoding string, size int64) (bool, error) {
+	if err := os.MkdirAll(filepath.Dir(dest), dirMode); err != nil {
+		return false, err
+	}
+
+	file, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, fileMode)
+	if err != nil {
+		return false, err
+	}
+	defer func() { _ = file.Close() }()
+
+	w := encodings[encoding].newWriter(file)
+	if _, err := io.Copy(w, r); err != nil {
+		_ = os.Remove(dest)
+		return false, err
+	}
+	if err := w.Close(); err != nil {
+		_ = os.Remove(dest)
+		return false, err
+	}
+
+	info, err := file.Stat()
+	if err != nil || info.Size() >= size {
+		_ = os.Remove(dest)
+		return false, err
+	}
+	return true, file.Close()
+}
+
+// Writes the precompressed variants of the compressible files of the release at fp into dest
+func precompress(fp, dest string) error {
+	return walkRelease(fp, func(p string, size int64, open func() (io.ReadCloser, error)) error {
+		if !isCompressible(p, size) {
+			return nil
+		}
+
+		for encoding, e := range encodings {
+			rc, err := open()
+			if err != nil {
+				return err
+			}
+			_, err = encodeFile(rc, filepath.Join(dest, filepath.FromSlash(p)+e.ext), encoding, size)
+			_ = rc.Close()
+			if err != nil {
+				return errors.Wrapf(err, "could not compress '%s'", p)
+			}
+		}
+		return nil
+	})
+}
+
+// Precompresses the staged tree (or archive) at fp that is published as release. The release
+// is still served (compressed on the fly) if it fails
+func (f *FileSys) precompress(fp, release string) {
+	if err := precompress(fp, f.encodedPath(release)); err != nil {
+		log.Errorf("could not precompress release '%s': %v", release, err)
+		_ = os.RemoveAll(f.encodedPath(release))
+	}
+}
+
+// Folder of the precompressed files of the release
+func (f *FileSys) encodedPath(release string) string {
+	return filepath.Join(f.root, encodedFolder, filepath.Base(filepath.Dir(release)), filepath.Base(release))
+}
+
+// Opens the variant of a file of the live documentation compressed with the content encoding
+// (gzip or br). Fails with os.ErrNotExist if there is no such variant
+func (f *FileSys) OpenEncoded(name, p, encoding string) (http.File, error) {
+	e, ok := encodings[encoding]
+	if !ok || checkName(name) != nil {
+		return nil, os.ErrNotExist
+	}
+
+	release, err := filepath.EvalSymlinks(f.Destination(name))
+	if err != nil {
+		return nil, err
+	}
+	// projects published before releases existed have no variants
+	if !f.isRelease(release) {
+		return nil, os.ErrNotExist
+	}
+
+	file, err := http.Dir(f.encodedPath(release)).Open(cleanPath(p) + e.ext)
+	if err != nil {
+		return nil, err
+	}
+	if info, err := file.Stat(); err != nil || info.IsDir() {
+		_ = file.Close()
+		return nil, os.ErrNotExist
+	}
+	return file, nil
+}
+
+// Compresses the file at fp into a temporary file for every encoding. Returns the paths of
+// the compressed files keyed by encoding, the caller is responsible for removing them
+func encodeTemp(root, fp string, size int64) (map[string]string, error) {
+	files := make(map[string]string)
+	for encoding := range encodings {
+		tmp, err := createTemp(root)
+		if err != nil {
+			return files, err
+		}
+		_ = tmp.Close()
+
+		src, err := os.Open(fp)
+		if err != nil {
+			_ = os.Remove(tmp.Name())
+			return files, err
+		}
+		ok, err := encodeFile(src, tmp.Name(), encoding, size)
+		_ = src.Close()
+		if err != nil {
+			return files, err
+		} else if ok {
+			files[encoding] = tmp.Name()
+		}
+	}
+	return files, nil
+}
@@ -0,0 +1,115 @@
+package staticfiles_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/stretchr/testify/require"
+
+	. "private-sphinx-docs/services/staticfiles"
+)
+
+func TestFileSys_OpenEncoded(t *testing.T) {
+	t.Parallel()
+
+	page := strings.Repeat("<p>Sphinx</p>", 1000)
+	for _, serveArchives := range []bool{false, true} {
+		assert := require.New(t)
+
+		root, err := ioutil.TempDir("", "psd-")
+		assert.NoError(err)
+		defer func() { _ = os.RemoveAll(root) }()
+
+		fs, err := NewFileSys(&FileSysOption{Root: root, ServeArchives: serveArchives})
+		assert.NoError(err)
+
+		content := createZip(t, map[string]string{
+			"index.html":       page,
+			"small.html":       "<p>small</p>",
+			"_static/logo.png": page,
+		})
+		_, err = fs.Upload(bytes.NewReader(content), "project", int64(len(content)))
+		assert.NoError(err)
+
+		file, err := fs.OpenEncoded("project", "/index.html", "gzip")
+		assert.NoError(err)
+		zr, err := gzip.NewReader(file)
+		assert.NoError(err)
+		actual, err := ioutil.ReadAll(zr)
+		assert.NoError(err)
+		assert.Equal(page, string(actual))
+		assert.NoError(file.Close())
+
+		file, err = fs.OpenEncoded("project", "index.html", "br")
+		assert.NoError(err)
+		actual, err = ioutil.ReadAll(brotli.NewReader(file))
+		assert.NoError(err)
+		assert.Equal(page, string(actual))
+		assert.NoError(file.Close())
+
+		// small files and files in compressed formats are not precompressed
+		for _, path := range []string{"small.html", "_static/logo.png", "missing.html", "_static"} {
+			_, err = fs.OpenEncoded("project", path, "gzip")
+			assert.True(os.IsNotExist(err), path)
+		}
+		_, err = fs.OpenEncoded("project", "index.html", "deflate")
+		assert.True(os.IsNotExist(err))
+
+		// the variants are replaced with the release
+		content = createZip(t, map[string]string{"index.html": "<p>small</p>"})
+		_, err = fs.Upload(bytes.NewReader(content), "project", int64(len(content)))
+		assert.NoError(err)
+		_, err = fs.OpenEncoded("project", "index.html", "gzip")
+		assert.True(os.IsNotExist(err))
+
+		releases, err := ioutil.ReadDir(filepath.Join(root, ".releases", "project"))
+		assert.NoError(err)
+		assert.Len(releases, 1)
+	}
+}
+
+func TestFileSys_ConcurrentPrecompress(t *testing.T) {
+	t.Parallel()
+	assert := require.New(t)
+
+	root, err := ioutil.TempDir("", "psd-")
+	assert.NoError(err)
+	defer func() { _ = os.RemoveAll(root) }()
+
+	fs, err := NewFileSys(&FileSysOption{Root: root})
+	assert.NoError(err)
+
+	// uploads are compressed side by side, only the variants of the live release are kept
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			content := createZip(t, map[string]string{"index.html": strings.Repeat(fmt.Sprintf("<p>%d</p>", i), 1000)})
+			_, err := fs.Upload(bytes.NewReader(content), "project", int64(len(content)))
+			assert.NoError(err)
+		}(i)
+	}
+	wg.Wait()
+
+	live, err := ioutil.ReadFile(filepath.Join(fs.Destination("project"), "index.html"))
+	assert.NoError(err)
+	file, err := fs.OpenEncoded("project", "index.html", "br")
+	assert.NoError(err)
+	actual, err := ioutil.ReadAll(brotli.NewReader(file))
+	assert.NoError(err)
+	assert.Equal(string(live), string(actual))
+	assert.NoError(file.Close())
+
+	encoded, err := ioutil.ReadDir(filepath.Join(root, ".encoded", "project"))
+	assert.NoError(err)
+	assert.Len(encoded, 1)
+}
@@ -0,0 +1,209 @@
+package staticfiles
+
+import (
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultMaxTotalSize        = 2 << 30
+	defaultMaxEntries          = 100000
+	defaultMaxFileSize         = 512 << 20
+	defaultMaxCompressionRatio = 200
+
+	// compression ratio is only checked for entries larger than this as small files
+	// (i.e. blank pages) legitimately compress extremely well
+	minRatioCheckSize = 1 << 20
+
+	fileMode = 0644
+	dirMode  = 0755
+)
+
+// Limits applied when extracting uploaded archives. Zero values are replaced by the defaults
+type Limits struct {
+	// Maximum total uncompressed size of all entries in bytes
+	MaxTotalSize int64
+	// Maximum number of entries (files and directories) in the archive
+	MaxEntries int
+	// Maximum uncompressed size of a single file in bytes
+	MaxFileSize int64
+	// Maximum ratio between the uncompressed and compressed size of an entry
+	MaxCompressionRatio int64
+}
+
+func (l Limits) withDefaults() Limits {
+	if l.MaxTotalSize <= 0 {
+		l.MaxTotalSize = defaultMaxTotalSize
+	}
+	if l.MaxEntries <= 0 {
+		l.MaxEntries = defaultMaxEntries
+	}
+	if l.MaxFileSize <= 0 {
+		l.MaxFileSize = defaultMaxFileSize
+	}
+	if l.MaxCompressionRatio <= 0 {
+		l.MaxCompressionRatio = defaultMaxCompressionRatio
+	}
+	return l
+}
+
+// Writes archive entries into dest while enforcing the limits. Entries are only ever written
+// within dest, with fixed permissions. Links and special files are rejected.
+type extractor struct {
+	dest    string
+	limits  Limits
+	entries int
+	total   int64
+}
+
+func newExtractor(dest string, limits Limits) *extractor {
+	return &extractor{dest: dest, limits: limits.withDefaults()}
+}
+
+// Resolves the path of the entry within dest
+func (e *extractor) path(name string) (string, error) {
+	clean := strings.Replace(name, `\`, "/", -1)
+	if path.IsAbs(clean) || filepath.IsAbs(name) || filepath.VolumeName(name) != "" {
+		return "", errors.Errorf("archive entry '%s' has an absolute path", name)
+	}
+
+	clean = path.Clean(clean)
+	if clean == ".." || strings.HasPrefix(clean, "../") {
+		return "", errors.Errorf("archive entry '%s' points outside of the documentation folder", name)
+	}
+
+	fp := filepath.Join(e.dest, filepath.FromSlash(clean))
+	if rel, err := filepath.Rel(e.dest, fp); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", errors.Errorf("archive entry '%s' points outside of the documentation folder", name)
+	}
+	return fp, nil
+}
+
+// Checks the entry count and the type of the entry. Only regular files and directories
+// are allowed
+func (e *extractor) check(name string, mode os.FileMode) error {
+	e.entries++
+	if e.entries > e.limits.MaxEntries {
+		return errors.Errorf("archive has more than %d entries", e.limits.MaxEntries)
+	}
+
+	switch {
+	case mode&os.ModeSymlink != 0:
+		return errors.Errorf("archive entry '%s' is a symlink, links are not allowed", name)
+	case !mode.IsDir() && !mode.IsRegular():
+		return errors.Errorf("archive entry '%s' is not a regular file or directory", name)
+	}
+	return nil
+}
+
+func (e *extractor) dir(name string, mode os.FileMode) error {
+	if err := e.check(name, mode); err != nil {
+		return err
+	}
+
+	fp, err := e.path(name)
+	if err != nil {
+		return err
+	}
+	return os.MkdirAll(fp, dirMode)
+}
+
+// Writes a file entry. size and compressed are the sizes declared by the archive. Since
+// these can be forged, the limits are enforced again on the bytes actually written.
+// compressed should be 0 if it is unknown.
+func (e *extractor) file(name string, mode os.FileMode, r io.Reader, size, compressed int64) error {
+	if err := e.check(name, mode); err != nil {
+		return err
+	}
+
+	fp, err := e.path(name)
+	if err != nil {
+		return err
+	}
+
+	if err := e.declare(name, size, compressed); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(fp), dirMode); err != nil {
+		return err
+	}
+	// the file could be a hard link into a published tree (see Sync), replace it instead of
+	// writing through the link
+	if err := os.Remove(fp); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	file, err := os.OpenFile(fp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, fileMode)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = file.Close() }()
+
+	// read at most 1 byte over the limits to detect entries lying about their size
+	limit := e.limits.MaxFileSize
+	if remaining := e.limits.MaxTotalSize - e.total; remaining < limit {
+		limit = remaining
+	}
+	n, err := io.Copy(file, io.LimitReader(r, limit+1))
+	if err != nil {
+		return err
+	}
+	e.total += n
+
+	if n > limit {
+		if n > e.limits.MaxFileSize {
+			return errors.Errorf("archive entry '%s' exceeds the maximum file size of %d bytes", name, e.limits.MaxFileSize)
+		}
+		return errors.Errorf("archive content exceeds the maximum uncompressed size of %d bytes", e.limits.MaxTotalSize)
+	}
+	if compressed > 0 && n > minRatioCheckSize && n/compressed > e.limits.MaxCompressionRatio {
+		return errors.Errorf("archive entry '%s' has a compression ratio of %d, the maximum allowed is %d", name, n/compressed, e.limits.MaxCompressionRatio)
+	}
+
+	return file.Close()
+}
+
+// Checks the sizes declared by the archive for the entry against the limits
+func (e *extractor) declare(name string, size, compressed int64) error {
+	if size > e.limits.MaxFileSize {
+		return errors.Errorf("archive entry '%s' exceeds the maximum file size of %d bytes", name, e.limits.MaxFileSize)
+	}
+	if e.total+size > e.limits.MaxTotalSize {
+		return errors.Errorf("archive content exceeds the maximum uncompressed size of %d bytes", e.limits.MaxTotalSize)
+	}
+	if compressed > 0 && size > minRatioCheckSize && size/compressed > e.limits.MaxCompressionRatio {
+		return errors.Errorf("archive entry '%s' has a compression ratio of %d, the maximum allowed is %d", name, size/compressed, e.limits.MaxCompressionRatio)
+	}
+	return nil
+}
+
+// Fails reading from the decompressed stream r once more than the maximum compression ratio
+// times the compressed size has been read
+func (e *extractor) limitRatio(r io.Reader, compressed int64) io.Reader {
+	max := compressed * e.limits.MaxCompressionRatio
+	if max < minRatioCheckSize {
+		max = minRatioCheckSize
+	}
+	return &ratioReader{r: r, max: max, ratio: e.limits.MaxCompressionRatio}
+}
+
+type ratioReader struct {
+	r     io.Reader
+	read  int64
+	max   int64
+	ratio int64
+}
+
+func (r *ratioReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.read += int64(n)
+	if r.read > r.max {
+		return n, errors.Errorf("archive exceeds the maximum compression ratio of %d", r.ratio)
+	}
+	return n, err
+}
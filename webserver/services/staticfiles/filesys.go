@@ -1,12 +1,16 @@
 package staticfiles
 
 import (
-	"archive/zip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
 	"io"
 	"io/ioutil"
+	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/otiai10/copy"
 	"github.com/pkg/errors"
@@ -14,11 +18,36 @@ import (
 	"private-sphinx-docs/libs"
 )
 
+// Folder (relative to the root) where the uploaded artifacts of every revision are kept.
+// Folders starting with a "." can never be reached through a subdomain
+const revisionFolder = ".revisions"
+
+type FileSysOption struct {
+	// Folder where the documentation is stored
+	Root string
+	// Limits applied when extracting uploaded archives
+	Limits Limits
+	// Keeps uploaded zip archives as they are and serves the files straight from them
+	// instead of extracting the archives. Other formats are repacked into zip archives
+	ServeArchives bool
+	// Stores every distinct file of the revisions once and saves a manifest of the files
+	// instead of the uploaded artifact. Releases share the stored files with hard links
+	Deduplicate bool
+}
+
 type FileSys struct {
-	root string
+	root          string
+	limits        Limits
+	locks         projectLocks
+	serveArchives bool
+	archives      archiveCache
+	checksums     checksumCache
+	deduplicate   bool
+	blobs         *blobStore
 }
 
-func NewFileSys(root string) (*FileSys, error) {
+func NewFileSys(option *FileSysOption) (*FileSys, error) {
+	root := option.Root
 	if !filepath.IsAbs(root) {
 		_root, err := filepath.Abs(root)
 		if err != nil {
@@ -34,77 +63,239 @@ func NewFileSys(root string) (*FileSys, error) {
 		}
 	}
 
-	return &FileSys{root}, nil
+	// anything left in these folders is from uploads that were interrupted
+	for _, folder := range []string{stagingFolder, tempFolder} {
+		if err := os.RemoveAll(filepath.Join(root, folder)); err != nil {
+			return nil, errors.Wrapf(err, "could not clear %s folder", strings.TrimPrefix(folder, "."))
+		}
+	}
+
+	return &FileSys{
+		root:          root,
+		limits:        option.Limits.withDefaults(),
+		serveArchives: option.ServeArchives,
+		deduplicate:   option.Deduplicate,
+		blobs:         &blobStore{root: root},
+	}, nil
 }
 
-func (f *FileSys) Upload(r io.ReaderAt, name string, size int64) error {
-	contents, err := zip.NewReader(r, size)
+// Extracts the uploaded archive (zip, tar, tar.gz or tar.zst) into a staging directory and
+// publishes it once the extraction succeeded. Readers keep seeing the previous files until
+// the new files are swapped in. Returns the statistics of the published files.
+func (f *FileSys) Upload(r io.ReaderAt, name string, size int64) (*Stats, error) {
+	if err := checkName(name); err != nil {
+		return nil, err
+	}
+	if f.serveArchives {
+		return f.uploadArchive(r, name, size)
+	}
+	if f.deduplicate {
+		return f.uploadFromBlobs(r, name, size)
+	}
+
+	dest, err := stageArchive(f.root, name, f.limits, r, size)
 	if err != nil {
-		return errors.Wrap(err, "could not read zip contents")
+		return nil, err
 	}
-	dest := f.Destination(name)
-	err = os.RemoveAll(dest)
+	// no-op once the staging directory has been published
+	defer func() { _ = os.RemoveAll(dest) }()
+
+	return f.publish(name, dest, "")
+}
+
+// Creates a temporary file under the root folder to buffer uploads. The caller is responsible
+// for closing and removing the file
+func (f *FileSys) CreateTemp() (*os.File, error) {
+	return createTemp(f.root)
+}
+
+func (f *FileSys) Destination(name string) string {
+	return filepath.Join(f.root, projectName(name))
+}
+
+// Saves a copy of the uploaded artifact so that the project can be restored to it later.
+// Returns the key of the stored artifact and its sha256 checksum
+func (f *FileSys) SaveArtifact(r io.ReaderAt, name string, size int64) (artifact, checksum string, err error) {
+	if err := checkName(name); err != nil {
+		return "", "", err
+	}
+	if f.deduplicate {
+		return f.saveBlobs(r, name, size)
+	}
+
+	folder := filepath.Join(f.root, revisionFolder, projectName(name))
+	if err := os.MkdirAll(folder, 0744); err != nil {
+		return "", "", errors.Wrapf(err, "could not create revision folder at '%s'", folder)
+	}
+
+	tmp, err := ioutil.TempFile(folder, ".upload-*")
 	if err != nil {
-		return errors.Wrap(err, "could not remove old directory")
+		return "", "", errors.Wrap(err, "could not create artifact file")
+	}
+	defer func() {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+	}()
+
+	hash := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(tmp, hash), io.NewSectionReader(r, 0, size)); err != nil {
+		return "", "", errors.Wrap(err, "could not write artifact")
+	}
+	if err := tmp.Close(); err != nil {
+		return "", "", errors.Wrap(err, "could not write artifact")
 	}
 
-	extractAndWriteFile := func(f *zip.File) error {
-		rc, err := f.Open()
+	checksum = hex.EncodeToString(hash.Sum(nil))
+	artifact = artifactName(checksum)
+	if err := os.Rename(tmp.Name(), filepath.Join(folder, artifact)); err != nil {
+		return "", "", errors.Wrap(err, "could not save artifact")
+	}
+
+	return artifact, checksum, nil
+}
+
+// Replaces the project files with the contents of a previously saved artifact
+func (f *FileSys) Restore(name, artifact string) error {
+	if err := checkName(name); err != nil {
+		return err
+	}
+	if f.hasManifest(name, artifact) {
+		tree, err := f.stageManifest(name, artifact)
 		if err != nil {
 			return err
 		}
-		defer func() {
-			if err := rc.Close(); err != nil {
-				panic(err)
-			}
-		}()
-
-		path := filepath.Join(dest, f.Name)
-
-		if f.FileInfo().IsDir() {
-			_ = os.MkdirAll(path, f.Mode())
-		} else {
-			_ = os.MkdirAll(filepath.Dir(path), f.Mode())
-			f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, f.Mode())
-			if err != nil {
-				return err
-			}
-			defer func() {
-				if err := f.Close(); err != nil {
-					panic(err)
-				}
-			}()
-
-			_, err = io.Copy(f, rc)
-			if err != nil {
-				return err
-			}
-		}
-		return nil
+		defer func() { _ = os.RemoveAll(tree) }()
+
+		_, err = f.publishTree(name, tree, "")
+		return err
+	}
+
+	file, err := os.Open(f.artifactPath(name, artifact))
+	if err != nil {
+		return errors.Wrapf(err, "could not open artifact '%s'", artifact)
 	}
+	defer func() { _ = file.Close() }()
 
-	for _, file := range contents.File {
-		if err := extractAndWriteFile(file); err != nil {
+	info, err := file.Stat()
+	if err != nil {
+		return errors.Wrapf(err, "could not read artifact '%s'", artifact)
+	}
+
+	// zip artifacts are served as they are, restoring them only swaps the link
+	if format, err := detectFormat(file, info.Size()); f.serveArchives && err == nil && format == formatZip {
+		if err := validateZip(file, info.Size(), f.limits); err != nil {
 			return err
 		}
+		_, err := f.publishArchive(name, file.Name(), "")
+		return err
 	}
 
-	return formatContentDirectory(dest)
-}
-
-func (f *FileSys) Destination(name string) string {
-	name = strings.TrimSuffix(filepath.Base(name), ".zip")
-	return filepath.Join(f.root, name)
+	_, err = f.Upload(file, name, info.Size())
+	return err
 }
 
+// Remove the project files, its releases and all its saved artifacts
 func (f *FileSys) Remove(name string) error {
-	return os.RemoveAll(f.Destination(name))
+	if err := checkName(name); err != nil {
+		return err
+	}
+
+	unlock := f.locks.lock(projectName(name))
+	defer unlock()
+	defer f.archives.evict(filepath.Join(f.root, releaseFolder, projectName(name)))
+	defer f.checksums.evict(filepath.Join(f.root, releaseFolder, projectName(name)))
+	defer f.checksums.evict(f.Destination(name))
+
+	if err := os.RemoveAll(f.Destination(name)); err != nil {
+		return errors.Wrap(err, "could not remove project files")
+	}
+	for _, folder := range []string{releaseFolder, encodedFolder, checksumFolder, revisionFolder, manifestFolder} {
+		if err := os.RemoveAll(filepath.Join(f.root, folder, projectName(name))); err != nil {
+			return errors.Wrapf(err, "could not remove project %s", strings.TrimPrefix(folder, "."))
+		}
+	}
+	return nil
 }
 
 func (f *FileSys) Source() string {
 	return f.root
 }
 
+// Opens a file of the live documentation of the project. path is slash separated and
+// relative to the project root
+func (f *FileSys) Open(name, path string) (http.File, error) {
+	if checkName(name) != nil {
+		return nil, os.ErrNotExist
+	}
+
+	dest := f.Destination(name)
+	if isFile(dest) {
+		release, err := filepath.EvalSymlinks(dest)
+		if err != nil {
+			return nil, err
+		}
+		return f.openRelease(release, path)
+	}
+	return http.Dir(dest).Open(path)
+}
+
+// Opens a file of the release at fp, which is either a directory or a zip archive
+func (f *FileSys) openRelease(fp, path string) (http.File, error) {
+	if isFile(fp) {
+		return f.archives.open(fp, path)
+	}
+	return http.Dir(fp).Open(path)
+}
+
+func (f *FileSys) Stat(name, path string) (os.FileInfo, error) {
+	file, err := f.Open(name, path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = file.Close() }()
+
+	return file.Stat()
+}
+
+// Lists the directory of the live documentation of the project sorted by name
+func (f *FileSys) List(name, path string) ([]os.FileInfo, error) {
+	file, err := f.Open(name, path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = file.Close() }()
+
+	infos, err := file.Readdir(-1)
+	if err != nil {
+		return nil, err
+	}
+	sortInfos(infos)
+	return infos, nil
+}
+
+func (f *FileSys) artifactPath(name, artifact string) string {
+	return filepath.Join(f.root, revisionFolder, projectName(name), filepath.Base(artifact))
+}
+
+// Names the artifact after the upload time so that artifacts sort chronologically
+func artifactName(checksum string) string {
+	return fmt.Sprintf("%d-%s", time.Now().UnixNano(), checksum[:12])
+}
+
+// Gets the folder name of the project
+func projectName(name string) string {
+	return strings.TrimSuffix(filepath.Base(name), ".zip")
+}
+
+// Checks that the project folder is a direct child of the root folder and does not collide
+// with the internal (hidden) folders
+func checkName(name string) error {
+	if n := projectName(name); n == "." || n == ".." || n == string(filepath.Separator) || strings.HasPrefix(n, ".") {
+		return errors.Errorf("invalid project name '%s'", name)
+	}
+	return nil
+}
+
 // If the destination folder only contains 1 folder, moves the entire folder up 1
 // level till we reach the first level with more than 1 item.
 func formatContentDirectory(src string) error {
@@ -115,7 +306,7 @@ func formatContentDirectory(src string) error {
 			return err
 		}
 
-		if len(f) != 1 {
+		if len(f) != 1 || !f[0].IsDir() {
 			break
 		}
 
@@ -0,0 +1,296 @@
+package staticfiles_test
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/require"
+
+	"private-sphinx-docs/libs"
+	. "private-sphinx-docs/services/staticfiles"
+)
+
+func TestFileSys_Upload(t *testing.T) {
+	t.Parallel()
+	assert := require.New(t)
+
+	root, err := ioutil.TempDir("", "psd-")
+	assert.NoError(err)
+	defer func() { _ = os.RemoveAll(root) }()
+
+	// project published before releases existed
+	legacy := filepath.Join(root, "legacy")
+	assert.NoError(os.MkdirAll(legacy, 0755))
+	assert.NoError(ioutil.WriteFile(filepath.Join(legacy, "old.html"), []byte("old"), 0644))
+
+	fs, err := NewFileSys(&FileSysOption{Root: root})
+	assert.NoError(err)
+
+	for _, s := range []struct {
+		Name     string
+		Files    map[string]string
+		Expected map[string]string
+		HasError bool
+	}{
+		{"project", map[string]string{"html/index.html": "v1", "html/_static/app.js": "js"}, map[string]string{"index.html": "v1", "_static/app.js": "js"}, false},
+		{"project", map[string]string{"index.html": "v2"}, map[string]string{"index.html": "v2"}, false},
+		{"project", map[string]string{}, map[string]string{"index.html": "v2"}, true},
+		{"legacy", map[string]string{"index.html": "new"}, map[string]string{"index.html": "new"}, false},
+	} {
+		content := createZip(t, s.Files)
+		stats, err := fs.Upload(bytes.NewReader(content), s.Name, int64(len(content)))
+		if s.HasError {
+			assert.Error(err)
+		} else {
+			assert.NoError(err)
+			assert.Equal(len(s.Expected), stats.Files)
+		}
+
+		dest := fs.Destination(s.Name)
+		info, err := os.Lstat(dest)
+		assert.NoError(err)
+		assert.True(info.Mode()&os.ModeSymlink != 0, "destination should be a symlink to the release")
+
+		for path, expected := range s.Expected {
+			actual, err := ioutil.ReadFile(filepath.Join(dest, path))
+			assert.NoError(err)
+			assert.Equal(expected, string(actual))
+		}
+	}
+
+	for _, name := range []string{"", ".", "..", ".releases"} {
+		content := createZip(t, map[string]string{"index.html": "v1"})
+		_, err := fs.Upload(bytes.NewReader(content), name, int64(len(content)))
+		assert.Error(err)
+	}
+
+	// only the live release is kept around
+	releases, err := ioutil.ReadDir(filepath.Join(root, ".releases", "project"))
+	assert.NoError(err)
+	assert.Len(releases, 1)
+
+	assert.NoError(fs.Remove("project"))
+	_, err = os.Lstat(fs.Destination("project"))
+	assert.True(os.IsNotExist(err))
+}
+
+func TestFileSys_Open(t *testing.T) {
+	t.Parallel()
+	assert := require.New(t)
+
+	root, err := ioutil.TempDir("", "psd-")
+	assert.NoError(err)
+	defer func() { _ = os.RemoveAll(root) }()
+
+	fs, err := NewFileSys(&FileSysOption{Root: root})
+	assert.NoError(err)
+
+	content := createZip(t, map[string]string{"index.html": "v1", "_static/app.js": "js"})
+	_, err = fs.Upload(bytes.NewReader(content), "project", int64(len(content)))
+	assert.NoError(err)
+
+	file, err := fs.Open("project", "/index.html")
+	assert.NoError(err)
+	actual, err := ioutil.ReadAll(file)
+	assert.NoError(err)
+	assert.Equal("v1", string(actual))
+	assert.NoError(file.Close())
+
+	info, err := fs.Stat("project", "_static")
+	assert.NoError(err)
+	assert.True(info.IsDir())
+
+	infos, err := fs.List("project", "/")
+	assert.NoError(err)
+	assert.Len(infos, 2)
+	assert.Equal("_static", infos[0].Name())
+	assert.Equal("index.html", infos[1].Name())
+
+	for _, s := range []struct {
+		Name string
+		Path string
+	}{
+		{"project", "missing.html"},
+		{"project", "../../etc/passwd"},
+		{".releases", "project"},
+		{"missing", "index.html"},
+	} {
+		_, err = fs.Open(s.Name, s.Path)
+		assert.True(os.IsNotExist(err), "%s/%s should not exist", s.Name, s.Path)
+	}
+}
+
+func TestFileSys_UploadRejectsUnsafeArchives(t *testing.T) {
+	t.Parallel()
+	assert := require.New(t)
+
+	root, err := ioutil.TempDir("", "psd-")
+	assert.NoError(err)
+	defer func() { _ = os.RemoveAll(root) }()
+
+	fs, err := NewFileSys(&FileSysOption{
+		Root: root,
+		Limits: Limits{
+			MaxTotalSize:        4 << 20,
+			MaxEntries:          3,
+			MaxFileSize:         3 << 20,
+			MaxCompressionRatio: 10,
+		},
+	})
+	assert.NoError(err)
+
+	symlink := &zip.FileHeader{Name: "link.html"}
+	symlink.SetMode(os.ModeSymlink | 0777)
+
+	for _, s := range []struct {
+		Entries []zipEntry
+		Message string
+	}{
+		{[]zipEntry{{Header: &zip.FileHeader{Name: "../evil.html"}}}, "outside of the documentation folder"},
+		{[]zipEntry{{Header: &zip.FileHeader{Name: "html/../../evil.html"}}}, "outside of the documentation folder"},
+		{[]zipEntry{{Header: &zip.FileHeader{Name: "/etc/evil.html"}}}, "absolute path"},
+		{[]zipEntry{{Header: symlink, Content: "/etc/passwd"}}, "symlink"},
+		{[]zipEntry{{Name: "1.html"}, {Name: "2.html"}, {Name: "3.html"}, {Name: "4.html"}}, "more than 3 entries"},
+		{[]zipEntry{{Name: "big.html", Content: randomString(3<<20 + 1)}}, "maximum file size"},
+		{[]zipEntry{{Name: "1.html", Content: randomString(3 << 20)}, {Name: "2.html", Content: randomString(3 << 20)}}, "maximum uncompressed size"},
+		{[]zipEntry{{Name: "zeros.html", Content: string(make([]byte, 2<<20)), Deflate: true}}, "compression ratio"},
+	} {
+		content := createZipWithEntries(t, s.Entries)
+		_, err := fs.Upload(bytes.NewReader(content), "project", int64(len(content)))
+		assert.Error(err)
+		assert.Contains(err.Error(), s.Message)
+	}
+
+	assert.False(libs.PathExists(filepath.Join(filepath.Dir(root), "evil.html")))
+	_, err = os.Lstat(fs.Destination("project"))
+	assert.True(os.IsNotExist(err), "nothing should have been published")
+}
+
+func TestFileSys_UploadTarArchives(t *testing.T) {
+	t.Parallel()
+	assert := require.New(t)
+
+	root, err := ioutil.TempDir("", "psd-")
+	assert.NoError(err)
+	defer func() { _ = os.RemoveAll(root) }()
+
+	fs, err := NewFileSys(&FileSysOption{Root: root})
+	assert.NoError(err)
+
+	files := map[string]string{"_build/html/index.html": "index", "_build/html/_static/app.css": "css"}
+	for _, s := range []struct {
+		Compression string
+		Headers     []*tar.Header
+		HasError    bool
+	}{
+		{"", nil, false},
+		{"gzip", nil, false},
+		{"zstd", nil, false},
+		{"gzip", []*tar.Header{{Name: "_build/html/link.html", Typeflag: tar.TypeSymlink, Linkname: "/etc/passwd"}}, true},
+		{"gzip", []*tar.Header{{Name: "_build/html/link.html", Typeflag: tar.TypeLink, Linkname: "/etc/passwd"}}, true},
+		{"gzip", []*tar.Header{{Name: "../evil.html", Typeflag: tar.TypeReg}}, true},
+		{"bzip", nil, true},
+	} {
+		content := createTar(t, s.Compression, files, s.Headers)
+		name := "tar" + s.Compression
+		_, err := fs.Upload(bytes.NewReader(content), name, int64(len(content)))
+		if s.HasError {
+			assert.Error(err)
+			continue
+		}
+		assert.NoError(err)
+
+		// flattened the same way as zip archives
+		actual, err := ioutil.ReadFile(filepath.Join(fs.Destination(name), "_static", "app.css"))
+		assert.NoError(err)
+		assert.Equal("css", string(actual))
+	}
+}
+
+func createTar(t *testing.T, compression string, files map[string]string, headers []*tar.Header) []byte {
+	buf := &bytes.Buffer{}
+	var w io.WriteCloser
+	switch compression {
+	case "gzip":
+		w = gzip.NewWriter(buf)
+	case "zstd":
+		zw, err := zstd.NewWriter(buf)
+		require.NoError(t, err)
+		w = zw
+	case "bzip":
+		// not supported
+		_, _ = buf.WriteString("BZh91AY&SY")
+		return buf.Bytes()
+	default:
+		w = nopCloser{buf}
+	}
+
+	tw := tar.NewWriter(w)
+	for name, content := range files {
+		require.NoError(t, tw.WriteHeader(&tar.Header{Name: name, Mode: 0600, Size: int64(len(content)), Typeflag: tar.TypeReg}))
+		_, err := tw.Write([]byte(content))
+		require.NoError(t, err)
+	}
+	for _, h := range headers {
+		require.NoError(t, tw.WriteHeader(h))
+	}
+	require.NoError(t, tw.Close())
+	require.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error { return nil }
+
+type zipEntry struct {
+	Header  *zip.FileHeader
+	Name    string
+	Content string
+	Deflate bool
+}
+
+func createZip(t *testing.T, files map[string]string) []byte {
+	var entries []zipEntry
+	for name, content := range files {
+		entries = append(entries, zipEntry{Name: name, Content: content})
+	}
+	return createZipWithEntries(t, entries)
+}
+
+func createZipWithEntries(t *testing.T, entries []zipEntry) []byte {
+	buf := &bytes.Buffer{}
+	w := zip.NewWriter(buf)
+	for _, e := range entries {
+		header := e.Header
+		if header == nil {
+			header = &zip.FileHeader{Name: e.Name}
+		}
+		if e.Deflate {
+			header.Method = zip.Deflate
+		}
+		f, err := w.CreateHeader(header)
+		require.NoError(t, err)
+		_, err = f.Write([]byte(e.Content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+func randomString(n int) string {
+	content := make([]byte, n)
+	_, _ = rand.Read(content)
+	return string(content)
+}
@@ -0,0 +1,108 @@
+package staticfiles
+
+import (
+	"io"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/minio/minio-go/v6"
+	"github.com/pkg/errors"
+)
+
+// A file of a release in the object store. The content is fetched with (range) requests as
+// it is read
+type objectFile struct {
+	*minio.Object
+	info os.FileInfo
+}
+
+func (f *objectFile) Readdir(int) ([]os.FileInfo, error) {
+	return nil, errors.New("not a directory")
+}
+
+func (f *objectFile) Stat() (os.FileInfo, error) {
+	return f.info, nil
+}
+
+// A directory of a release in the object store. Directories are not stored, they are derived
+// from the paths of the files
+type objectDir struct {
+	info    os.FileInfo
+	entries []os.FileInfo
+	offset  int
+}
+
+func (d *objectDir) Read([]byte) (int, error) {
+	return 0, errors.New("is a directory")
+}
+
+func (d *objectDir) Seek(int64, int) (int64, error) {
+	return 0, errors.New("is a directory")
+}
+
+func (d *objectDir) Close() error {
+	return nil
+}
+
+// Reads the directory entries like os.File.Readdir
+func (d *objectDir) Readdir(count int) ([]os.FileInfo, error) {
+	remaining := d.entries[d.offset:]
+	if count <= 0 {
+		d.offset = len(d.entries)
+		return remaining, nil
+	}
+
+	if len(remaining) == 0 {
+		return nil, io.EOF
+	}
+	if count > len(remaining) {
+		count = len(remaining)
+	}
+	d.offset += count
+	return remaining[:count], nil
+}
+
+func (d *objectDir) Stat() (os.FileInfo, error) {
+	return d.info, nil
+}
+
+type objectInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	dir     bool
+}
+
+func (i *objectInfo) Name() string {
+	return i.name
+}
+
+func (i *objectInfo) Size() int64 {
+	return i.size
+}
+
+func (i *objectInfo) Mode() os.FileMode {
+	if i.dir {
+		return os.ModeDir | dirMode
+	}
+	return fileMode
+}
+
+func (i *objectInfo) ModTime() time.Time {
+	return i.modTime
+}
+
+func (i *objectInfo) IsDir() bool {
+	return i.dir
+}
+
+func (i *objectInfo) Sys() interface{} {
+	return nil
+}
+
+func sortInfos(infos []os.FileInfo) {
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Name() < infos[j].Name()
+	})
+}
@@ -0,0 +1,680 @@
+package staticfiles
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/minio/minio-go/v6"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"private-sphinx-docs/libs"
+)
+
+// Time for which the live release of a project is cached. Other replicas see a new release
+// at most this long after it is published
+const pointerTTL = 5 * time.Second
+
+type ObjectStoreOption struct {
+	// Host (and port) of the S3 compatible service
+	Endpoint  string
+	AccessKey string
+	SecretKey string
+	Region    string
+	UseSSL    bool
+	Bucket    string
+	// Prefix of every key written by the store, allows sharing the bucket
+	Prefix string
+	// Local folder where uploads are buffered and extracted before they are stored
+	WorkDir string
+	// Limits applied when extracting uploaded archives
+	Limits Limits
+}
+
+// Stores the documentation in an S3 compatible object store so that multiple replicas can
+// serve the same projects. Objects are laid out as
+//
+//	releases/{project}/{release}/{path}  files of every release
+//	live/{project}.json                  the live release of the project and its files
+//	revisions/{project}/{artifact}       uploaded artifacts
+//
+// Releases are written in full before the live object is swapped over to them, so readers
+// either see the old or the new release.
+type ObjectStore struct {
+	client  *minio.Client
+	bucket  string
+	prefix  string
+	workDir string
+	limits  Limits
+	locks   projectLocks
+	// cached releasePointer of each project
+	pointers sync.Map
+	// releases being uploaded by this replica keyed by project and release, see publish
+	pending sync.Map
+}
+
+// The live release of a project
+type releasePointer struct {
+	Release   string                  `json:"release"`
+	Published time.Time               `json:"published"`
+	Files     map[string]*releaseFile `json:"files"`
+	fetched   time.Time
+	// sorted entries of every directory, see index
+	dirs map[string][]os.FileInfo
+}
+
+type releaseFile struct {
+	Checksum string    `json:"sha256"`
+	Size     int64     `json:"size"`
+	ModTime  time.Time `json:"modTime"`
+	// size of the precompressed variants keyed by content encoding
+	Encoded map[string]int64 `json:"encoded,omitempty"`
+}
+
+func NewObjectStore(option *ObjectStoreOption) (*ObjectStore, error) {
+	if strings.TrimSpace(option.Endpoint) == "" || strings.TrimSpace(option.Bucket) == "" {
+		return nil, errors.New("object store endpoint and bucket must be specified")
+	}
+
+	client, err := minio.NewWithRegion(option.Endpoint, option.AccessKey, option.SecretKey, option.UseSSL, option.Region)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not create object store client")
+	}
+
+	exists, err := client.BucketExists(option.Bucket)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not check bucket '%s'", option.Bucket)
+	} else if !exists {
+		if err := client.MakeBucket(option.Bucket, option.Region); err != nil {
+			return nil, errors.Wrapf(err, "could not create bucket '%s'", option.Bucket)
+		}
+	}
+
+	workDir, err := filepath.Abs(option.WorkDir)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not convert work folder to absolute path")
+	}
+	if !libs.PathExists(workDir) {
+		if err := os.MkdirAll(workDir, 0744); err != nil {
+			return nil, errors.Wrapf(err, "could not create work folder at '%s'", workDir)
+		}
+	}
+
+	// anything left in these folders is from uploads that were interrupted
+	for _, folder := range []string{stagingFolder, tempFolder} {
+		if err := os.RemoveAll(filepath.Join(workDir, folder)); err != nil {
+			return nil, errors.Wrapf(err, "could not clear %s folder", strings.TrimPrefix(folder, "."))
+		}
+	}
+
+	return &ObjectStore{
+		client:  client,
+		bucket:  option.Bucket,
+		prefix:  strings.Trim(option.Prefix, "/"),
+		workDir: workDir,
+		limits:  option.Limits.withDefaults(),
+	}, nil
+}
+
+// Extracts the uploaded archive (zip, tar, tar.gz or tar.zst) locally and stores the files
+// as a new release of the project. Returns the statistics of the published files
+func (o *ObjectStore) Upload(r io.ReaderAt, name string, size int64) (*Stats, error) {
+	if err := checkName(name); err != nil {
+		return nil, err
+	}
+
+	dest, err := stageArchive(o.workDir, name, o.limits, r, size)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = os.RemoveAll(dest) }()
+
+	return o.publish(name, dest, "")
+}
+
+// Creates a temporary file under the work folder to buffer uploads. The caller is responsible
+// for closing and removing the file
+func (o *ObjectStore) CreateTemp() (*os.File, error) {
+	return createTemp(o.workDir)
+}
+
+func (o *ObjectStore) Destination(name string) string {
+	return fmt.Sprintf("s3://%s/%s", o.bucket, o.key("releases", projectName(name)))
+}
+
+// Stores a copy of the uploaded artifact so that the project can be restored to it later.
+// Returns the key of the stored artifact and its sha256 checksum
+func (o *ObjectStore) SaveArtifact(r io.ReaderAt, name string, size int64) (artifact, checksum string, err error) {
+	if err := checkName(name); err != nil {
+		return "", "", err
+	}
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, io.NewSectionReader(r, 0, size)); err != nil {
+		return "", "", errors.Wrap(err, "could not read artifact")
+	}
+
+	checksum = hex.EncodeToString(hash.Sum(nil))
+	artifact = artifactName(checksum)
+	_, err = o.client.PutObject(o.bucket, o.artifactKey(name, artifact), io.NewSectionReader(r, 0, size), size, minio.PutObjectOptions{
+		ContentType: "application/octet-stream",
+	})
+	if err != nil {
+		return "", "", errors.Wrap(err, "could not save artifact")
+	}
+
+	return artifact, checksum, nil
+}
+
+// Replaces the project files with the contents of a previously saved artifact
+func (o *ObjectStore) Restore(name, artifact string) error {
+	if err := checkName(name); err != nil {
+		return err
+	}
+
+	file, err := o.CreateTemp()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = file.Close()
+		_ = os.Remove(file.Name())
+	}()
+
+	object, err := o.client.GetObject(o.bucket, o.artifactKey(name, artifact), minio.GetObjectOptions{})
+	if err != nil {
+		return errors.Wrapf(err, "could not open artifact '%s'", artifact)
+	}
+	defer func() { _ = object.Close() }()
+
+	size, err := io.Copy(file, object)
+	if err != nil {
+		return errors.Wrapf(err, "could not read artifact '%s'", artifact)
+	}
+
+	_, err = o.Upload(file, name, size)
+	return err
+}
+
+// Computes the sha256 checksum of every live file of the project keyed by its path
+func (o *ObjectStore) Manifest(name string) (map[string]string, error) {
+	pointer, err := o.pointer(name, false)
+	if err != nil {
+		return nil, err
+	}
+
+	manifest := make(map[string]string, len(pointer.Files))
+	for path, file := range pointer.Files {
+		manifest[path] = file.Checksum
+	}
+	return manifest, nil
+}
+
+// Assembles a new release from the live release of the project by removing the deleted paths
+// and adding the files in the (optional) archive r. See FileSys.Sync
+func (o *ObjectStore) Sync(name string, r io.ReaderAt, size int64, deletions []string) (artifact, checksum string, n int64, stats *Stats, err error) {
+	pointer, err := o.pointer(name, false)
+	if err != nil {
+		return "", "", 0, nil, err
+	}
+
+	dest, err := newStagingDir(o.workDir, name)
+	if err != nil {
+		return "", "", 0, nil, err
+	}
+	defer func() { _ = os.RemoveAll(dest) }()
+
+	for path := range pointer.Files {
+		err := o.client.FGetObject(o.bucket, o.releaseKey(name, pointer.Release, path), filepath.Join(dest, filepath.FromSlash(path)), minio.GetObjectOptions{})
+		if err != nil {
+			// the release may have been removed by a concurrent publish while it was read
+			if current, perr := o.pointer(name, false); perr == nil && current.Release != pointer.Release {
+				return "", "", 0, nil, ErrConflict
+			}
+			return "", "", 0, nil, errors.Wrapf(err, "could not download '%s'", path)
+		}
+	}
+
+	if err := applyChanges(dest, o.limits, r, size, deletions); err != nil {
+		return "", "", 0, nil, err
+	}
+
+	file, err := o.CreateTemp()
+	if err != nil {
+		return "", "", 0, nil, err
+	}
+	defer func() {
+		_ = file.Close()
+		_ = os.Remove(file.Name())
+	}()
+
+	checksum, n, err = writeArtifact(file, dest)
+	if err != nil {
+		return "", "", 0, nil, err
+	}
+
+	artifact = artifactName(checksum)
+	if _, err := o.client.PutObject(o.bucket, o.artifactKey(name, artifact), io.NewSectionReader(file, 0, n), n, minio.PutObjectOptions{
+		ContentType: "application/zip",
+	}); err != nil {
+		return "", "", 0, nil, errors.Wrap(err, "could not save artifact")
+	}
+
+	stats, err = o.publish(name, dest, pointer.Release)
+	if err != nil {
+		return "", "", 0, nil, err
+	}
+	return artifact, checksum, n, stats, nil
+}
+
+// Removes the project files, its releases and all its saved artifacts
+func (o *ObjectStore) Remove(name string) error {
+	if err := checkName(name); err != nil {
+		return err
+	}
+
+	name = projectName(name)
+	unlock := o.locks.lock(name)
+	defer unlock()
+	defer o.pointers.Delete(name)
+
+	if err := o.client.RemoveObject(o.bucket, o.pointerKey(name)); err != nil {
+		return errors.Wrap(err, "could not remove project files")
+	}
+	for _, folder := range []string{"releases", "revisions"} {
+		if err := o.removePrefix(o.key(folder, name) + "/"); err != nil {
+			return errors.Wrapf(err, "could not remove project %s", folder)
+		}
+	}
+	return nil
+}
+
+func (o *ObjectStore) Source() string {
+	return o.workDir
+}
+
+// Opens a file of the live documentation of the project. Files are only downloaded once
+// they are read
+func (o *ObjectStore) Open(name, path string) (http.File, error) {
+	info, pointer, err := o.stat(name, path)
+	if err != nil {
+		return nil, err
+	}
+
+	if info.IsDir() {
+		return &objectDir{info: info, entries: pointer.list(cleanPath(path))}, nil
+	}
+
+	object, err := o.client.GetObject(o.bucket, o.releaseKey(name, pointer.Release, cleanPath(path)), minio.GetObjectOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return &objectFile{Object: object, info: info}, nil
+}
+
+// Opens the variant of a file of the live documentation compressed with the content encoding
+// (gzip or br). Fails with os.ErrNotExist if there is no such variant
+func (o *ObjectStore) OpenEncoded(name, path, encoding string) (http.File, error) {
+	if _, ok := encodings[encoding]; !ok {
+		return nil, os.ErrNotExist
+	}
+
+	info, pointer, err := o.stat(name, path)
+	if err != nil {
+		return nil, err
+	}
+	file, ok := pointer.Files[cleanPath(path)]
+	if !ok || file.Encoded[encoding] == 0 {
+		return nil, os.ErrNotExist
+	}
+
+	object, err := o.client.GetObject(o.bucket, o.encodedKey(name, pointer.Release, cleanPath(path), encoding), minio.GetObjectOptions{})
+	if err != nil {
+		return nil, err
+	}
+	encoded := &objectInfo{name: info.Name() + encodings[encoding].ext, size: file.Encoded[encoding], modTime: info.ModTime()}
+	return &objectFile{Object: object, info: encoded}, nil
+}
+
+// Gets the sha256 checksum of a file of the live documentation, recorded when it was published
+func (o *ObjectStore) Checksum(name, path string) (string, error) {
+	info, pointer, err := o.stat(name, path)
+	if err != nil {
+		return "", err
+	} else if info.IsDir() {
+		return "", errors.Errorf("'%s' is a directory", path)
+	}
+	return pointer.Files[cleanPath(path)].Checksum, nil
+}
+
+func (o *ObjectStore) Stat(name, path string) (os.FileInfo, error) {
+	info, _, err := o.stat(name, path)
+	return info, err
+}
+
+// Lists the directory of the live documentation of the project sorted by name
+func (o *ObjectStore) List(name, path string) ([]os.FileInfo, error) {
+	info, pointer, err := o.stat(name, path)
+	if err != nil {
+		return nil, err
+	} else if !info.IsDir() {
+		return nil, errors.Errorf("'%s' is not a directory", path)
+	}
+	return pointer.list(cleanPath(path)), nil
+}
+
+func (o *ObjectStore) stat(name, path string) (os.FileInfo, *releasePointer, error) {
+	if checkName(name) != nil {
+		return nil, nil, os.ErrNotExist
+	}
+
+	pointer, err := o.pointer(name, true)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	info := pointer.stat(cleanPath(path))
+	if info == nil {
+		return nil, nil, os.ErrNotExist
+	}
+	return info, pointer, nil
+}
+
+// Uploads the staged tree as a new release and swaps the live release of the project over to
+// it. Releases other than the new and the previous one are removed afterwards. The previous
+// release is kept since other replicas could still be serving it from their cache. base is
+// the live release the tree was assembled from (see Sync), the tree is only published if it
+// is still live. Only publishes of this replica are serialized, see FileSys.publish. The
+// files are uploaded (and compressed) before the project is locked. Returns the statistics
+// of the published files
+func (o *ObjectStore) publish(name, tree, base string) (*Stats, error) {
+	name = projectName(name)
+	pointer, err := o.putRelease(name, tree)
+	if err != nil {
+		return nil, err
+	}
+
+	unlock := o.locks.lock(name)
+	defer unlock()
+
+	previous, err := o.pointer(name, false)
+	if base != "" && (err != nil || previous.Release != base) {
+		o.removeRelease(name, pointer.Release)
+		return nil, ErrConflict
+	}
+
+	keep := []string{pointer.Release}
+	if previous != nil {
+		keep = append(keep, previous.Release)
+	}
+
+	content, err := json.Marshal(pointer)
+	if err != nil {
+		o.removeRelease(name, pointer.Release)
+		return nil, errors.Wrap(err, "could not save release")
+	}
+	if _, err := o.client.PutObject(o.bucket, o.pointerKey(name), bytes.NewReader(content), int64(len(content)), minio.PutObjectOptions{
+		ContentType: "application/json",
+	}); err != nil {
+		o.removeRelease(name, pointer.Release)
+		return nil, errors.Wrap(err, "could not swap release")
+	}
+
+	pointer.index()
+	pointer.fetched = time.Now()
+	o.pointers.Store(name, pointer)
+
+	o.removeReleases(name, keep)
+	return pointer.stats(), nil
+}
+
+// Uploads the files of the staged tree (and their precompressed variants) as a new release.
+// The release is not live yet, it is kept from removeReleases until it is published
+func (o *ObjectStore) putRelease(name, tree string) (*releasePointer, error) {
+	pointer := &releasePointer{
+		Release:   fmt.Sprintf("%d", time.Now().UnixNano()),
+		Published: time.Now(),
+		Files:     make(map[string]*releaseFile),
+	}
+	pending := path.Join(name, pointer.Release)
+	o.pending.Store(pending, true)
+	defer o.pending.Delete(pending)
+
+	err := filepath.Walk(tree, func(fp string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+
+		rel, err := filepath.Rel(tree, fp)
+		if err != nil {
+			return err
+		}
+		path := filepath.ToSlash(rel)
+
+		checksum, err := fileChecksum(fp)
+		if err != nil {
+			return err
+		}
+		if _, err := o.client.FPutObject(o.bucket, o.releaseKey(name, pointer.Release, path), fp, minio.PutObjectOptions{}); err != nil {
+			return errors.Wrapf(err, "could not store '%s'", path)
+		}
+
+		file := &releaseFile{Checksum: checksum, Size: info.Size(), ModTime: info.ModTime()}
+		if isCompressible(path, info.Size()) {
+			if file.Encoded, err = o.putEncoded(name, pointer.Release, path, fp, info.Size()); err != nil {
+				return errors.Wrapf(err, "could not store compressed '%s'", path)
+			}
+		}
+		pointer.Files[path] = file
+		return nil
+	})
+	if err != nil {
+		o.removeRelease(name, pointer.Release)
+		return nil, err
+	}
+	return pointer, nil
+}
+
+// Gets the live release of the project. A cached release is used if it is recent enough
+func (o *ObjectStore) pointer(name string, cached bool) (*releasePointer, error) {
+	if err := checkName(name); err != nil {
+		return nil, err
+	}
+
+	name = projectName(name)
+	if cached {
+		if p, ok := o.pointers.Load(name); ok && time.Since(p.(*releasePointer).fetched) < pointerTTL {
+			return p.(*releasePointer), nil
+		}
+	}
+
+	object, err := o.client.GetObject(o.bucket, o.pointerKey(name), minio.GetObjectOptions{})
+	if err != nil {
+		return nil, errors.Wrap(err, "could not fetch live release")
+	}
+	defer func() { _ = object.Close() }()
+
+	pointer := &releasePointer{}
+	if err := json.NewDecoder(object).Decode(pointer); err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			o.pointers.Delete(name)
+			return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+		}
+		return nil, errors.Wrap(err, "could not read live release")
+	}
+
+	pointer.index()
+	pointer.fetched = time.Now()
+	o.pointers.Store(name, pointer)
+	return pointer, nil
+}
+
+// Removes every release of the project except the ones given and the ones still being uploaded
+func (o *ObjectStore) removeReleases(name string, keep []string) {
+	done := make(chan struct{})
+	defer close(done)
+
+	prefix := o.key("releases", name) + "/"
+	for object := range o.client.ListObjectsV2(o.bucket, prefix, false, done) {
+		if object.Err != nil {
+			log.Errorf("could not list releases of '%s': %v", name, object.Err)
+			return
+		}
+
+		release := strings.Trim(strings.TrimPrefix(object.Key, prefix), "/")
+		id := strings.TrimSuffix(release, encodedSuffix)
+		if _, uploading := o.pending.Load(path.Join(name, id)); !uploading && !contains(keep, id) {
+			if err := o.removePrefix(prefix + release + "/"); err != nil {
+				log.Errorf("could not remove old release '%s': %v", object.Key, err)
+			}
+		}
+	}
+}
+
+// Removes the files of a release that could not be published
+func (o *ObjectStore) removeRelease(name, release string) {
+	_ = o.removePrefix(o.key("releases", name, release) + "/")
+	_ = o.removePrefix(o.key("releases", name, release+encodedSuffix) + "/")
+}
+
+// Uploads the precompressed variants of the file at fp. Returns their size keyed by encoding
+func (o *ObjectStore) putEncoded(name, release, file, fp string, size int64) (map[string]int64, error) {
+	files, err := encodeTemp(o.workDir, fp, size)
+	defer func() {
+		for _, tmp := range files {
+			_ = os.Remove(tmp)
+		}
+	}()
+	if err != nil {
+		return nil, err
+	}
+
+	sizes := make(map[string]int64, len(files))
+	for encoding, tmp := range files {
+		n, err := o.client.FPutObject(o.bucket, o.encodedKey(name, release, file, encoding), tmp, minio.PutObjectOptions{})
+		if err != nil {
+			return nil, err
+		}
+		sizes[encoding] = n
+	}
+	return sizes, nil
+}
+
+// Removes every object whose key starts with prefix
+func (o *ObjectStore) removePrefix(prefix string) error {
+	done := make(chan struct{})
+	defer close(done)
+
+	keys := make(chan string)
+	var listErr error
+	go func() {
+		defer close(keys)
+		for object := range o.client.ListObjectsV2(o.bucket, prefix, true, done) {
+			if object.Err != nil {
+				listErr = object.Err
+				return
+			}
+			keys <- object.Key
+		}
+	}()
+
+	// drain the errors so that the removal (and listing) runs to completion
+	var removeErr error
+	for err := range o.client.RemoveObjects(o.bucket, keys) {
+		if removeErr == nil {
+			removeErr = errors.Wrapf(err.Err, "could not remove '%s'", err.ObjectName)
+		}
+	}
+	if removeErr != nil {
+		return removeErr
+	}
+	return listErr
+}
+
+func (o *ObjectStore) key(parts ...string) string {
+	return path.Join(append([]string{o.prefix}, parts...)...)
+}
+
+func (o *ObjectStore) pointerKey(name string) string {
+	return o.key("live", projectName(name)+".json")
+}
+
+func (o *ObjectStore) releaseKey(name, release, file string) string {
+	return o.key("releases", projectName(name), release, file)
+}
+
+func (o *ObjectStore) encodedKey(name, release, file, encoding string) string {
+	return o.key("releases", projectName(name), release+encodedSuffix, file+encodings[encoding].ext)
+}
+
+func (o *ObjectStore) artifactKey(name, artifact string) string {
+	return o.key("revisions", projectName(name), path.Base(artifact))
+}
+
+// Indexes the directories of the release once so that they are not derived from every file
+// path on each lookup
+func (p *releasePointer) index() {
+	files := make(map[string]os.FileInfo, len(p.Files))
+	for name, f := range p.Files {
+		files[name] = &objectInfo{name: path.Base(name), size: f.Size, modTime: f.ModTime}
+	}
+	p.dirs = indexDirs(files, p.dirInfo)
+}
+
+func (p *releasePointer) dirInfo(dir string) os.FileInfo {
+	name := path.Base(dir)
+	if dir == "" {
+		name = "/"
+	}
+	return &objectInfo{name: name, modTime: p.Published, dir: true}
+}
+
+// Describes the file or directory at path. Returns nil if it does not exist
+func (p *releasePointer) stat(file string) os.FileInfo {
+	if f, ok := p.Files[file]; ok {
+		return &objectInfo{name: path.Base(file), size: f.Size, modTime: f.ModTime}
+	}
+	if _, ok := p.dirs[file]; ok {
+		return p.dirInfo(file)
+	}
+	return nil
+}
+
+// Lists the entries of the directory sorted by name
+func (p *releasePointer) list(dir string) []os.FileInfo {
+	return p.dirs[dir]
+}
+
+// Counts the files of the release and their size
+func (p *releasePointer) stats() *Stats {
+	stats := &Stats{}
+	for file, f := range p.Files {
+		stats.add(file, f.Size)
+	}
+	return stats
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Converts the (url) path into the key of the file relative to the release
+func cleanPath(file string) string {
+	return strings.TrimPrefix(path.Clean("/"+file), "/")
+}
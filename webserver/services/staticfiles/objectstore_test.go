@@ -0,0 +1,161 @@
+package staticfiles_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/dhui/dktest"
+	"github.com/minio/minio-go/v6"
+	"github.com/stretchr/testify/require"
+
+	. "private-sphinx-docs/services/staticfiles"
+)
+
+var (
+	minioAccessKey    = "minio"
+	minioSecretKey    = "minio-secret"
+	minioImageName    = "minio/minio:RELEASE.2020-10-18T21-54-12Z"
+	minioImageOptions = dktest.Options{
+		ReadyFunc:    minioReady,
+		PortRequired: true,
+		ReadyTimeout: 5 * time.Minute,
+		Cmd:          []string{"server", "/data"},
+		Env: map[string]string{
+			"MINIO_ACCESS_KEY": minioAccessKey,
+			"MINIO_SECRET_KEY": minioSecretKey,
+		},
+	}
+)
+
+func minioEndpoint(c dktest.ContainerInfo) (string, error) {
+	ip, port, err := c.FirstPort()
+	if err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(ip, port), nil
+}
+
+func minioReady(_ context.Context, c dktest.ContainerInfo) bool {
+	endpoint, err := minioEndpoint(c)
+	if err != nil {
+		return false
+	}
+
+	client, err := minio.New(endpoint, minioAccessKey, minioSecretKey, false)
+	if err != nil {
+		return false
+	}
+	_, err = client.ListBuckets()
+	return err == nil
+}
+
+func newTestObjectStore(t *testing.T, c dktest.ContainerInfo) (*ObjectStore, func()) {
+	assert := require.New(t)
+
+	endpoint, err := minioEndpoint(c)
+	assert.NoError(err)
+
+	workDir, err := ioutil.TempDir("", "psd-")
+	assert.NoError(err)
+
+	store, err := NewObjectStore(&ObjectStoreOption{
+		Endpoint:  endpoint,
+		AccessKey: minioAccessKey,
+		SecretKey: minioSecretKey,
+		Bucket:    "docs",
+		Prefix:    "psd",
+		WorkDir:   workDir,
+	})
+	assert.NoError(err)
+
+	return store, func() { _ = os.RemoveAll(workDir) }
+}
+
+func TestObjectStore(t *testing.T) {
+	t.Parallel()
+
+	dktest.Run(t, minioImageName, minioImageOptions, func(t *testing.T, c dktest.ContainerInfo) {
+		assert := require.New(t)
+		store, cleanup := newTestObjectStore(t, c)
+		defer cleanup()
+
+		_, err := store.Open("project", "index.html")
+		assert.True(os.IsNotExist(err))
+
+		content := createZip(t, map[string]string{
+			"html/index.html":     "v1",
+			"html/_static/app.js": "js",
+		})
+		_, err = store.Upload(bytes.NewReader(content), "project", int64(len(content)))
+		assert.NoError(err)
+		artifact, _, err := store.SaveArtifact(bytes.NewReader(content), "project", int64(len(content)))
+		assert.NoError(err)
+
+		file, err := store.Open("project", "/index.html")
+		assert.NoError(err)
+		actual, err := ioutil.ReadAll(file)
+		assert.NoError(err)
+		assert.Equal("v1", string(actual))
+		assert.NoError(file.Close())
+
+		info, err := store.Stat("project", "_static")
+		assert.NoError(err)
+		assert.True(info.IsDir())
+
+		infos, err := store.List("project", "/")
+		assert.NoError(err)
+		assert.Len(infos, 2)
+		assert.Equal("_static", infos[0].Name())
+		assert.Equal("index.html", infos[1].Name())
+		assert.Equal(int64(2), infos[1].Size())
+		infos, err = store.List("project", "_static")
+		assert.NoError(err)
+		assert.Len(infos, 1)
+		assert.Equal("app.js", infos[0].Name())
+		_, err = store.Stat("project", "_stat")
+		assert.True(os.IsNotExist(err))
+
+		_, err = store.Open("project", "missing.html")
+		assert.True(os.IsNotExist(err))
+		_, err = store.Open(".releases", "index.html")
+		assert.True(os.IsNotExist(err))
+
+		// incremental upload on top of the live release
+		changes := createZip(t, map[string]string{"index.html": "v2"})
+		_, _, _, stats, err := store.Sync("project", bytes.NewReader(changes), int64(len(changes)), []string{"_static/app.js"})
+		assert.NoError(err)
+		assert.Equal(&Stats{Files: 1, Size: 2, HasIndex: true}, stats)
+
+		manifest, err := store.Manifest("project")
+		assert.NoError(err)
+		assert.Equal(map[string]string{"index.html": checksum("v2")}, manifest)
+
+		walked := make(map[string]int64)
+		walk := func(p string, size int64, _ time.Time, _ io.Reader) error {
+			walked[p] = size
+			return nil
+		}
+		assert.NoError(store.Walk("project", "", walk))
+		assert.Equal(map[string]int64{"index.html": 2}, walked)
+		walked = make(map[string]int64)
+		assert.NoError(store.Walk("project", artifact, walk))
+		assert.Equal(map[string]int64{"index.html": 2, "_static/app.js": 2}, walked)
+
+		// restore the first upload
+		assert.NoError(store.Restore("project", artifact))
+		manifest, err = store.Manifest("project")
+		assert.NoError(err)
+		assert.Len(manifest, 2)
+		assert.Equal(checksum("v1"), manifest["index.html"])
+
+		assert.NoError(store.Remove("project"))
+		_, err = store.Stat("project", "index.html")
+		assert.True(os.IsNotExist(err))
+	})
+}
@@ -0,0 +1,298 @@
+package staticfiles
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"private-sphinx-docs/libs"
+)
+
+const (
+	// Folder (relative to the root) holding the extracted trees of every project. The project
+	// destination is a symlink pointing to one of these trees
+	releaseFolder = ".releases"
+	// Folder (relative to the root) where uploads are extracted before being published
+	stagingFolder = ".staging"
+	// Folder (relative to the root) where uploaded artifacts are buffered
+	tempFolder = ".tmp"
+)
+
+// Serializes the publishing of the same project
+type projectLocks struct {
+	locks sync.Map
+}
+
+func (p *projectLocks) lock(name string) func() {
+	mu, _ := p.locks.LoadOrStore(name, &sync.Mutex{})
+	mu.(*sync.Mutex).Lock()
+	return mu.(*sync.Mutex).Unlock
+}
+
+// Creates an empty staging directory for the project under the root folder. The caller is
+// responsible for removing it if it is not published
+func newStagingDir(root, name string) (string, error) {
+	folder := filepath.Join(root, stagingFolder)
+	if err := os.MkdirAll(folder, 0744); err != nil {
+		return "", errors.Wrapf(err, "could not create staging folder at '%s'", folder)
+	}
+
+	dir, err := ioutil.TempDir(folder, projectName(name)+"-")
+	if err != nil {
+		return "", errors.Wrap(err, "could not create staging directory")
+	}
+	return dir, nil
+}
+
+// Extracts the archive into a new staging directory and checks that it can be published.
+// The caller is responsible for removing the directory
+func stageArchive(root, name string, limits Limits, r io.ReaderAt, size int64) (string, error) {
+	dest, err := newStagingDir(root, name)
+	if err != nil {
+		return "", err
+	}
+
+	if err := stage(dest, limits, r, size); err != nil {
+		_ = os.RemoveAll(dest)
+		return "", err
+	}
+	return dest, nil
+}
+
+func stage(dest string, limits Limits, r io.ReaderAt, size int64) error {
+	if err := extractArchive(newExtractor(dest, limits), r, size); err != nil {
+		return err
+	}
+	if err := formatContentDirectory(dest); err != nil {
+		return err
+	}
+	return validateStagingDir(dest)
+}
+
+// Creates a temporary file under the root folder to buffer uploads. The caller is responsible
+// for closing and removing the file
+func createTemp(root string) (*os.File, error) {
+	folder := filepath.Join(root, tempFolder)
+	if err := os.MkdirAll(folder, 0744); err != nil {
+		return nil, errors.Wrapf(err, "could not create temp folder at '%s'", folder)
+	}
+
+	file, err := ioutil.TempFile(folder, "upload-")
+	if err != nil {
+		return nil, errors.Wrap(err, "could not create temp file")
+	}
+	return file, nil
+}
+
+// Checks that the staged tree can be published
+func validateStagingDir(dir string) error {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return errors.Wrap(err, "could not read staged files")
+	} else if len(files) == 0 {
+		return errors.New("uploaded archive does not contain any files")
+	}
+
+	if !libs.PathExists(filepath.Join(dir, "index.html")) {
+		log.Warnf("published tree at '%s' does not contain an index.html", dir)
+	}
+	return nil
+}
+
+// Moves the staged tree into the release folder and atomically swaps the project destination
+// over to it. Older releases of the project are removed afterwards. base is the live release
+// the tree was assembled from (see Sync), the tree is only published if it is still live.
+// Uploads replace the live files regardless and pass an empty base. Returns the statistics of
+// the published files.
+func (f *FileSys) publish(name, staging, base string) (*Stats, error) {
+	name = projectName(name)
+	folder := filepath.Join(f.root, releaseFolder, name)
+	release := filepath.Join(folder, fmt.Sprintf("%d", time.Now().UnixNano()))
+
+	stats, err := releaseStats(staging)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not count staged files")
+	}
+
+	// compressing and hashing take a while for large trees, other publishes of the project
+	// go ahead in the meantime
+	f.precompress(staging, release)
+	f.hashRelease(staging, release)
+
+	unlock := f.locks.lock(name)
+	defer unlock()
+
+	if err := f.checkLive(name, base); err != nil {
+		f.removeDerived(release)
+		return nil, err
+	}
+
+	if err := os.MkdirAll(folder, 0744); err != nil {
+		f.removeDerived(release)
+		return nil, errors.Wrapf(err, "could not create release folder at '%s'", folder)
+	}
+	if err := os.Rename(staging, release); err != nil {
+		f.removeDerived(release)
+		return nil, errors.Wrap(err, "could not move staged files into release folder")
+	}
+
+	if err := f.activate(name, release); err != nil {
+		_ = os.RemoveAll(release)
+		f.removeDerived(release)
+		return nil, err
+	}
+
+	f.removeReleases(name, release)
+	return stats, nil
+}
+
+// Publishes the zip archive at fp as it is. The archive is hard linked (or copied) into the
+// release folder so that the original can be removed independently
+func (f *FileSys) publishArchive(name, fp, base string) (*Stats, error) {
+	dir, err := newStagingDir(f.root, name)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	staged := filepath.Join(dir, "archive.zip")
+	if err := os.Link(fp, staged); err != nil {
+		if err := copyFile(fp, staged); err != nil {
+			return nil, errors.Wrap(err, "could not stage archive")
+		}
+	}
+	return f.publish(name, staged, base)
+}
+
+// Validates the uploaded archive and publishes it without extracting it. Archives in other
+// formats than zip are extracted and repacked
+func (f *FileSys) uploadArchive(r io.ReaderAt, name string, size int64) (*Stats, error) {
+	format, err := detectFormat(r, size)
+	if err != nil {
+		return nil, err
+	}
+
+	if format != formatZip {
+		tree, err := stageArchive(f.root, name, f.limits, r, size)
+		if err != nil {
+			return nil, err
+		}
+		defer func() { _ = os.RemoveAll(tree) }()
+
+		return f.publishTree(name, tree, "")
+	}
+
+	if err := validateZip(r, size, f.limits); err != nil {
+		return nil, err
+	}
+
+	file, err := createTemp(f.root)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = file.Close()
+		_ = os.Remove(file.Name())
+	}()
+
+	if _, err := io.Copy(file, io.NewSectionReader(r, 0, size)); err != nil {
+		return nil, errors.Wrap(err, "could not save archive")
+	}
+	if err := file.Close(); err != nil {
+		return nil, errors.Wrap(err, "could not save archive")
+	}
+	return f.publishArchive(name, file.Name(), "")
+}
+
+// Publishes the staged tree, packed into a zip archive if archives are served
+func (f *FileSys) publishTree(name, tree, base string) (*Stats, error) {
+	if !f.serveArchives {
+		return f.publish(name, tree, base)
+	}
+
+	file, err := createTemp(f.root)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = file.Close()
+		_ = os.Remove(file.Name())
+	}()
+
+	if err := writeZip(file, tree); err != nil {
+		return nil, errors.Wrap(err, "could not pack archive")
+	}
+	if err := file.Close(); err != nil {
+		return nil, errors.Wrap(err, "could not pack archive")
+	}
+	return f.publishArchive(name, file.Name(), base)
+}
+
+// Points the project destination to the release. The symlink is created beside the
+// destination and renamed over it so that readers either see the old or the new tree.
+func (f *FileSys) activate(name, release string) error {
+	dest := f.Destination(name)
+
+	target, err := filepath.Rel(f.root, release)
+	if err != nil {
+		return errors.Wrap(err, "could not resolve release path")
+	}
+
+	link := filepath.Join(f.root, fmt.Sprintf(".%s-%d.link", name, time.Now().UnixNano()))
+	if err := os.Symlink(target, link); err != nil {
+		return errors.Wrap(err, "could not create release link")
+	}
+
+	// destinations published before releases existed are plain directories which cannot
+	// be replaced by a rename. Move them into the release folder so they are cleaned up
+	if info, err := os.Lstat(dest); err == nil && info.Mode()&os.ModeSymlink == 0 {
+		legacy := filepath.Join(filepath.Dir(release), fmt.Sprintf("legacy-%d", time.Now().UnixNano()))
+		if err := os.Rename(dest, legacy); err != nil {
+			_ = os.Remove(link)
+			return errors.Wrap(err, "could not move old directory")
+		}
+		// the checksums of the directory were cached under the destination path
+		f.checksums.evict(dest)
+	}
+
+	if err := os.Rename(link, dest); err != nil {
+		_ = os.Remove(link)
+		return errors.Wrap(err, "could not swap release")
+	}
+	return nil
+}
+
+// Removes every release of the project (and its precompressed files and checksums) except
+// the one given
+func (f *FileSys) removeReleases(name, keep string) {
+	folder := filepath.Join(f.root, releaseFolder, projectName(name))
+	files, err := ioutil.ReadDir(folder)
+	if err != nil {
+		log.Errorf("could not list releases of '%s': %v", name, err)
+		return
+	}
+
+	for _, file := range files {
+		if path := filepath.Join(folder, file.Name()); path != keep {
+			f.archives.evict(path)
+			f.checksums.evict(path)
+			if err := os.RemoveAll(path); err != nil {
+				log.Errorf("could not remove old release '%s': %v", path, err)
+			}
+			f.removeDerived(path)
+		}
+	}
+}
+
+// Removes the files derived from the release when it was published
+func (f *FileSys) removeDerived(release string) {
+	_ = os.RemoveAll(f.encodedPath(release))
+	_ = os.Remove(f.checksumsPath(release))
+}
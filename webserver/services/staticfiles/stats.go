@@ -0,0 +1,35 @@
+package staticfiles
+
+import (
+	"io"
+)
+
+// Statistics of a published release of a project
+type Stats struct {
+	// Number of files
+	Files int
+	// Uncompressed size of every file in bytes
+	Size int64
+	// True if the documentation has an index.html at its root
+	HasIndex bool
+}
+
+func (s *Stats) add(p string, size int64) {
+	s.Files++
+	s.Size += size
+	s.HasIndex = s.HasIndex || p == "index.html"
+}
+
+// Counts the files of the release at fp, which is either a directory or a zip archive, and
+// their size
+func releaseStats(fp string) (*Stats, error) {
+	stats := &Stats{}
+	err := walkRelease(fp, func(p string, size int64, _ func() (io.ReadCloser, error)) error {
+		stats.add(p, size)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
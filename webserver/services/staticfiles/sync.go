@@ -0,0 +1,360 @@
+package staticfiles
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+// Returned by Sync when the live documentation was replaced while the new tree was assembled.
+// The changes were not published, the client has to compare its files again
+var ErrConflict = errors.New("the live documentation was changed by another upload")
+
+// Gets the sha256 checksum of every file in the live tree of the project. Paths are relative
+// to the project root and slash separated
+func (f *FileSys) Manifest(name string) (map[string]string, error) {
+	live, err := f.livePath(name)
+	if err != nil {
+		return nil, err
+	}
+
+	checksums, err := f.releaseChecksums(live)
+	if err != nil {
+		return nil, err
+	}
+	// the checksums are shared with every reader of the release
+	manifest := make(map[string]string, len(checksums))
+	for p, checksum := range checksums {
+		manifest[p] = checksum
+	}
+	return manifest, nil
+}
+
+// Computes the sha256 checksum of every file of the release at fp, which is either a
+// directory or a zip archive
+func releaseManifest(fp string) (map[string]string, error) {
+	if isFile(fp) {
+		return zipManifest(fp)
+	}
+	return treeManifest(fp)
+}
+
+// Assembles a new tree from the live tree of the project by removing the deleted paths and
+// adding the files in the (optional) archive r. Paths in the archive are relative to the
+// project root. The new tree is saved as an artifact and published. Returns the artifact key,
+// its checksum and size, and the statistics of the published files.
+func (f *FileSys) Sync(name string, r io.ReaderAt, size int64, deletions []string) (artifact, checksum string, n int64, stats *Stats, err error) {
+	if err := checkName(name); err != nil {
+		return "", "", 0, nil, err
+	}
+
+	live, err := f.livePath(name)
+	if err != nil {
+		return "", "", 0, nil, err
+	}
+
+	dest, err := newStagingDir(f.root, name)
+	if err != nil {
+		return "", "", 0, nil, err
+	}
+	// no-op once the staging directory has been published
+	defer func() { _ = os.RemoveAll(dest) }()
+
+	if isFile(live) {
+		err = extractLiveArchive(live, dest, f.limits)
+	} else {
+		err = linkTree(live, dest)
+	}
+	if err != nil {
+		// the release may have been removed by a concurrent publish while it was read
+		if conflict := f.checkLive(name, live); conflict != nil {
+			return "", "", 0, nil, conflict
+		}
+		return "", "", 0, nil, errors.Wrap(err, "could not copy current files")
+	}
+
+	if err := applyChanges(dest, f.limits, r, size, deletions); err != nil {
+		return "", "", 0, nil, err
+	}
+
+	if f.deduplicate {
+		artifact, checksum, n, err = f.saveManifest(name, dest, "")
+	} else {
+		artifact, checksum, n, err = f.saveTree(name, dest)
+	}
+	if err != nil {
+		return "", "", 0, nil, err
+	}
+
+	if f.serveArchives && !f.deduplicate {
+		stats, err = f.publishArchive(name, f.artifactPath(name, artifact), live)
+	} else {
+		stats, err = f.publishTree(name, dest, live)
+	}
+	if err != nil {
+		return "", "", 0, nil, err
+	}
+	return artifact, checksum, n, stats, nil
+}
+
+// Resolves the folder of the live tree of the project
+func (f *FileSys) livePath(name string) (string, error) {
+	if err := checkName(name); err != nil {
+		return "", err
+	}
+
+	live, err := filepath.EvalSymlinks(f.Destination(name))
+	if os.IsNotExist(err) {
+		return "", errors.Errorf("project '%s' has not been published", projectName(name))
+	} else if err != nil {
+		return "", errors.Wrap(err, "could not resolve project files")
+	}
+	return live, nil
+}
+
+// Fails with ErrConflict if base is given and no longer the live release of the project
+func (f *FileSys) checkLive(name, base string) error {
+	if base == "" {
+		return nil
+	}
+	if live, err := f.livePath(name); err != nil || live != base {
+		return ErrConflict
+	}
+	return nil
+}
+
+// Extracts the served archive at fp into dest
+func extractLiveArchive(fp, dest string, limits Limits) error {
+	file, err := os.Open(fp)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = file.Close() }()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	if err := extractArchive(newExtractor(dest, limits), file, info.Size()); err != nil {
+		return err
+	}
+	return formatContentDirectory(dest)
+}
+
+func isFile(fp string) bool {
+	info, err := os.Stat(fp)
+	return err == nil && info.Mode().IsRegular()
+}
+
+// Saves the tree as a zip artifact of the project
+func (f *FileSys) saveTree(name, tree string) (artifact, checksum string, n int64, err error) {
+	folder := filepath.Join(f.root, revisionFolder, projectName(name))
+	if err := os.MkdirAll(folder, 0744); err != nil {
+		return "", "", 0, errors.Wrapf(err, "could not create revision folder at '%s'", folder)
+	}
+
+	tmp, err := ioutil.TempFile(folder, ".upload-*")
+	if err != nil {
+		return "", "", 0, errors.Wrap(err, "could not create artifact file")
+	}
+	defer func() {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+	}()
+
+	checksum, n, err = writeArtifact(tmp, tree)
+	if err != nil {
+		return "", "", 0, err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", "", 0, errors.Wrap(err, "could not write artifact")
+	}
+
+	artifact = artifactName(checksum)
+	if err := os.Rename(tmp.Name(), filepath.Join(folder, artifact)); err != nil {
+		return "", "", 0, errors.Wrap(err, "could not save artifact")
+	}
+
+	return artifact, checksum, n, nil
+}
+
+// Removes the deleted paths from the staged tree at dest and extracts the (optional) archive
+// r over it. Paths are relative to the project root
+func applyChanges(dest string, limits Limits, r io.ReaderAt, size int64, deletions []string) error {
+	ex := newExtractor(dest, limits)
+	for _, path := range deletions {
+		fp, err := ex.path(path)
+		if err != nil {
+			return err
+		} else if fp == dest {
+			return errors.Errorf("cannot delete the project root with '%s'", path)
+		}
+		if err := os.RemoveAll(fp); err != nil {
+			return errors.Wrapf(err, "could not delete '%s'", path)
+		}
+	}
+
+	if r != nil && size > 0 {
+		if err := extractArchive(ex, r, size); err != nil {
+			return err
+		}
+	}
+
+	return validateStagingDir(dest)
+}
+
+// Computes the sha256 checksum of every file in the tree keyed by its slash separated path
+func treeManifest(tree string) (map[string]string, error) {
+	manifest := make(map[string]string)
+	err := filepath.Walk(tree, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+
+		rel, err := filepath.Rel(tree, path)
+		if err != nil {
+			return err
+		}
+
+		checksum, err := fileChecksum(path)
+		if err != nil {
+			return err
+		}
+		manifest[filepath.ToSlash(rel)] = checksum
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "could not compute manifest")
+	}
+
+	return manifest, nil
+}
+
+// Writes the tree as a zip artifact into w. Returns the sha256 checksum and size of the artifact
+func writeArtifact(w io.Writer, tree string) (checksum string, n int64, err error) {
+	hash := sha256.New()
+	counter := &countingWriter{w: io.MultiWriter(w, hash)}
+	if err := writeZip(counter, tree); err != nil {
+		return "", 0, errors.Wrap(err, "could not write artifact")
+	}
+	return hex.EncodeToString(hash.Sum(nil)), counter.n, nil
+}
+
+// Writes every file in the tree into a zip archive. Paths are relative to the tree root
+func writeZip(w io.Writer, tree string) error {
+	zw := zip.NewWriter(w)
+	err := filepath.Walk(tree, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+
+		rel, err := filepath.Rel(tree, path)
+		if err != nil {
+			return err
+		}
+
+		header, err := zip.FileInfoHeader(info)
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(rel)
+		header.Method = zip.Deflate
+
+		entry, err := zw.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer func() { _ = file.Close() }()
+
+		_, err = io.Copy(entry, file)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// Recreates the tree at src in dest with hard links. Files are copied if they cannot be linked.
+// Files in a published tree are never modified in place so sharing them is safe.
+func linkTree(src, dest string) error {
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dest, rel)
+
+		if info.IsDir() {
+			return os.MkdirAll(target, dirMode)
+		}
+		if err := os.Link(path, target); err == nil {
+			return nil
+		}
+		return copyFile(path, target)
+	})
+}
+
+func copyFile(src, dest string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = in.Close() }()
+
+	out, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, fileMode)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = out.Close() }()
+
+	if _, err := io.Copy(out, in); err != nil {
+		return err
+	}
+	return out.Close()
+}
+
+func fileChecksum(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = file.Close() }()
+
+	return readerChecksum(file)
+}
+
+func readerChecksum(r io.Reader) (string, error) {
+	hash := sha256.New()
+	if _, err := io.Copy(hash, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,142 @@
+package staticfiles_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	. "private-sphinx-docs/services/staticfiles"
+)
+
+func TestFileSys_Sync(t *testing.T) {
+	t.Parallel()
+	assert := require.New(t)
+
+	root, err := ioutil.TempDir("", "psd-")
+	assert.NoError(err)
+	defer func() { _ = os.RemoveAll(root) }()
+
+	fs, err := NewFileSys(&FileSysOption{Root: root})
+	assert.NoError(err)
+
+	// nothing to sync against
+	_, err = fs.Manifest("project")
+	assert.Error(err)
+	_, _, _, _, err = fs.Sync("project", nil, 0, []string{"index.html"})
+	assert.Error(err)
+
+	content := createZip(t, map[string]string{
+		"index.html":     "v1",
+		"_static/app.js": "js",
+		"_static/old.js": "old",
+	})
+	_, err = fs.Upload(bytes.NewReader(content), "project", int64(len(content)))
+	assert.NoError(err)
+
+	manifest, err := fs.Manifest("project")
+	assert.NoError(err)
+	assert.Equal(map[string]string{
+		"index.html":     checksum("v1"),
+		"_static/app.js": checksum("js"),
+		"_static/old.js": checksum("old"),
+	}, manifest)
+
+	// keep a link to the live file to check that the published tree is not modified in place
+	live := filepath.Join(root, "live-index.html")
+	assert.NoError(os.Link(filepath.Join(fs.Destination("project"), "index.html"), live))
+
+	changes := createZip(t, map[string]string{
+		"index.html":   "v2",
+		"new/new.html": "new",
+	})
+	artifact, sum, size, stats, err := fs.Sync("project", bytes.NewReader(changes), int64(len(changes)), []string{"_static/old.js"})
+	assert.NoError(err)
+	assert.NotEmpty(artifact)
+	assert.Len(sum, 64)
+	assert.True(size > 0)
+	assert.Equal(&Stats{Files: 3, Size: 7, HasIndex: true}, stats)
+
+	manifest, err = fs.Manifest("project")
+	assert.NoError(err)
+	assert.Equal(map[string]string{
+		"index.html":     checksum("v2"),
+		"_static/app.js": checksum("js"),
+		"new/new.html":   checksum("new"),
+	}, manifest)
+
+	old, err := ioutil.ReadFile(live)
+	assert.NoError(err)
+	assert.Equal("v1", string(old))
+
+	// the artifact holds the whole tree so the revision can be restored later
+	assert.NoError(fs.Restore("project", artifact))
+	manifest, err = fs.Manifest("project")
+	assert.NoError(err)
+	assert.Len(manifest, 3)
+	assert.Equal(checksum("v2"), manifest["index.html"])
+
+	for _, deletions := range [][]string{{"../other"}, {"."}, {"/"}} {
+		_, _, _, _, err = fs.Sync("project", nil, 0, deletions)
+		assert.Error(err)
+	}
+}
+
+func checksum(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestFileSys_ConcurrentSync(t *testing.T) {
+	t.Parallel()
+
+	for _, archives := range []bool{false, true} {
+		assert := require.New(t)
+
+		root, err := ioutil.TempDir("", "psd-")
+		assert.NoError(err)
+		defer func() { _ = os.RemoveAll(root) }()
+
+		fs, err := NewFileSys(&FileSysOption{Root: root, ServeArchives: archives})
+		assert.NoError(err)
+
+		content := createZip(t, map[string]string{"index.html": "index"})
+		_, err = fs.Upload(bytes.NewReader(content), "project", int64(len(content)))
+		assert.NoError(err)
+
+		// every sync either publishes its file on top of the others or is rejected
+		var wg sync.WaitGroup
+		results := make([]error, 8)
+		for i := range results {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				changes := createZip(t, map[string]string{fmt.Sprintf("page%d.html", i): "page"})
+				_, _, _, _, results[i] = fs.Sync("project", bytes.NewReader(changes), int64(len(changes)), nil)
+			}(i)
+		}
+		wg.Wait()
+
+		manifest, err := fs.Manifest("project")
+		assert.NoError(err)
+		published := 0
+		for i, err := range results {
+			_, ok := manifest[fmt.Sprintf("page%d.html", i)]
+			if err != nil {
+				assert.Equal(ErrConflict, err)
+				assert.False(ok)
+			} else {
+				published++
+			}
+		}
+		assert.True(published > 0)
+		assert.Len(manifest, published+1)
+	}
+}
@@ -0,0 +1,327 @@
+package staticfiles
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/minio/minio-go/v6"
+	"github.com/pkg/errors"
+)
+
+// Called with every file of a tree. p is slash separated and relative to the project root,
+// r is only valid during the call
+type WalkFunc func(p string, size int64, modTime time.Time, r io.Reader) error
+
+// Calls fn with every live file of the project or, if an artifact is given, every file of the
+// saved artifact. Nothing is extracted to disk
+func (f *FileSys) Walk(name, artifact string, fn WalkFunc) error {
+	if artifact != "" {
+		if err := checkName(name); err != nil {
+			return err
+		}
+		if f.hasManifest(name, artifact) {
+			return f.walkManifest(name, artifact, fn)
+		}
+		return walkArtifactFile(f.artifactPath(name, artifact), f.limits, fn)
+	}
+
+	live, err := f.livePath(name)
+	if err != nil {
+		return err
+	}
+	if isFile(live) {
+		return walkZipArchive(live, fn)
+	}
+
+	return filepath.Walk(live, func(file string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(live, file)
+		if err != nil {
+			return err
+		}
+		return walkFile(file, func(r io.Reader) error {
+			return fn(filepath.ToSlash(rel), info.Size(), info.ModTime(), r)
+		})
+	})
+}
+
+// Reads the files of the revision from the blob store
+func (f *FileSys) walkManifest(name, artifact string, fn WalkFunc) error {
+	manifest, err := readManifest(f.manifestPath(name, artifact))
+	if err != nil {
+		return errors.Wrapf(err, "could not read manifest of '%s'", artifact)
+	}
+
+	f.blobs.mu.RLock()
+	defer f.blobs.mu.RUnlock()
+
+	paths := make([]string, 0, len(manifest.Files))
+	for p := range manifest.Files {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	for _, p := range paths {
+		file := manifest.Files[p]
+		blob := f.blobs.path(file.Checksum)
+		info, err := os.Stat(blob)
+		if err != nil {
+			return errors.Wrapf(err, "could not read file '%s'", p)
+		}
+		err = walkFile(blob, func(r io.Reader) error {
+			return fn(p, file.Size, info.ModTime(), r)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Calls fn with every live file of the project or, if an artifact is given, every file of the
+// saved artifact. Files are streamed from the bucket
+func (o *ObjectStore) Walk(name, artifact string, fn WalkFunc) error {
+	if err := checkName(name); err != nil {
+		return err
+	}
+
+	if artifact != "" {
+		object, err := o.client.GetObject(o.bucket, o.artifactKey(name, artifact), minio.GetObjectOptions{})
+		if err != nil {
+			return errors.Wrapf(err, "could not open artifact '%s'", artifact)
+		}
+		defer func() { _ = object.Close() }()
+
+		info, err := object.Stat()
+		if err != nil {
+			return errors.Wrapf(err, "could not read artifact '%s'", artifact)
+		}
+		return walkArchive(object, info.Size, o.limits, fn)
+	}
+
+	pointer, err := o.pointer(name, false)
+	if err != nil {
+		return err
+	}
+	paths := make([]string, 0, len(pointer.Files))
+	for p := range pointer.Files {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	for _, p := range paths {
+		file := pointer.Files[p]
+		object, err := o.client.GetObject(o.bucket, o.releaseKey(name, pointer.Release, p), minio.GetObjectOptions{})
+		if err != nil {
+			return errors.Wrapf(err, "could not read file '%s'", p)
+		}
+		err = fn(p, file.Size, file.ModTime, object)
+		_ = object.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func walkFile(fp string, fn func(r io.Reader) error) error {
+	file, err := os.Open(fp)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = file.Close() }()
+	return fn(file)
+}
+
+// Reads the files of a release served as zip archive, see openZipArchive
+func walkZipArchive(fp string, fn WalkFunc) error {
+	archive, err := openZipArchive(fp)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = archive.file.Close() }()
+
+	paths := make([]string, 0, len(archive.files))
+	for p := range archive.files {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	for _, p := range paths {
+		if err := walkZipFile(archive.files[p], p, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func walkArtifactFile(fp string, limits Limits, fn WalkFunc) error {
+	file, err := os.Open(fp)
+	if err != nil {
+		return errors.Wrap(err, "could not open artifact")
+	}
+	defer func() { _ = file.Close() }()
+
+	info, err := file.Stat()
+	if err != nil {
+		return errors.Wrap(err, "could not read artifact")
+	}
+	return walkArchive(file, info.Size(), limits, fn)
+}
+
+// Calls fn with every file of the archive (of any supported format). Like uploads, the single
+// top level folder of the archive (i.e. _build/html) is the project root
+func walkArchive(r io.ReaderAt, size int64, limits Limits, fn WalkFunc) error {
+	format, err := detectFormat(r, size)
+	if err != nil {
+		return err
+	}
+
+	if format == formatZip {
+		contents, err := zip.NewReader(r, size)
+		if err != nil {
+			return errors.Wrap(err, "could not read zip contents")
+		}
+
+		var entries []archiveEntry
+		for _, file := range contents.File {
+			entries = append(entries, archiveEntry{file.Name, file.FileInfo().IsDir()})
+		}
+		prefix := contentPrefix(entries)
+
+		for _, file := range contents.File {
+			p, ok := entryPath(file.Name, prefix)
+			if !ok || !file.Mode().IsRegular() {
+				continue
+			}
+			if err := walkZipFile(file, p, fn); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	// tar archives are read twice since the root folder is only known once every entry is seen
+	var entries []archiveEntry
+	err = walkTar(r, size, format, limits, func(header *tar.Header, _ io.Reader) error {
+		entries = append(entries, archiveEntry{header.Name, header.Typeflag == tar.TypeDir})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	prefix := contentPrefix(entries)
+
+	return walkTar(r, size, format, limits, func(header *tar.Header, content io.Reader) error {
+		p, ok := entryPath(header.Name, prefix)
+		if !ok || (header.Typeflag != tar.TypeReg && header.Typeflag != tar.TypeRegA) {
+			return nil
+		}
+		return fn(p, header.Size, header.ModTime, content)
+	})
+}
+
+func walkZipFile(file *zip.File, p string, fn WalkFunc) error {
+	rc, err := file.Open()
+	if err != nil {
+		return errors.Wrapf(err, "could not read '%s'", file.Name)
+	}
+	defer func() { _ = rc.Close() }()
+	return fn(p, int64(file.UncompressedSize64), file.Modified, rc)
+}
+
+func walkTar(r io.ReaderAt, size int64, format archiveFormat, limits Limits, fn func(header *tar.Header, r io.Reader) error) error {
+	var content io.Reader = io.NewSectionReader(r, 0, size)
+	switch format {
+	case formatTarGz:
+		gz, err := gzip.NewReader(content)
+		if err != nil {
+			return errors.Wrap(err, "could not read gzip contents")
+		}
+		defer func() { _ = gz.Close() }()
+		content = gz
+
+	case formatTarZst:
+		zr, err := zstd.NewReader(content, zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxMemory(uint64(limits.withDefaults().MaxTotalSize)))
+		if err != nil {
+			return errors.Wrap(err, "could not read zstd contents")
+		}
+		defer zr.Close()
+		content = zr
+	}
+
+	tr := tar.NewReader(content)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return errors.Wrap(err, "could not read tar contents")
+		}
+		if err := fn(header, tr); err != nil {
+			return err
+		}
+	}
+}
+
+type archiveEntry struct {
+	name  string
+	isDir bool
+}
+
+// Finds the folder holding the content of the archive by descending into the top level
+// folder while it is the only entry, see formatContentDirectory
+func contentPrefix(entries []archiveEntry) string {
+	prefix := ""
+	for {
+		top, isDir := "", false
+		for _, e := range entries {
+			p, ok := entryPath(e.name, prefix)
+			if !ok || p == "" {
+				continue
+			}
+
+			name := p
+			if i := strings.Index(p, "/"); i >= 0 {
+				name, isDir = p[:i], true
+			} else if e.isDir {
+				isDir = true
+			}
+			if top != "" && top != name {
+				return prefix
+			}
+			top = name
+		}
+		if top == "" || !isDir {
+			return prefix
+		}
+		prefix += top + "/"
+	}
+}
+
+// Path of the archive entry relative to the prefix. Entries outside the prefix or escaping
+// the root are skipped
+func entryPath(name, prefix string) (string, bool) {
+	p := strings.TrimPrefix(path.Clean("/"+filepath.ToSlash(name)), "/")
+	if prefix == "" {
+		return p, true
+	}
+	if p+"/" == prefix {
+		return "", true
+	}
+	if !strings.HasPrefix(p, prefix) {
+		return "", false
+	}
+	return strings.TrimPrefix(p, prefix), true
+}
@@ -0,0 +1,75 @@
+package staticfiles_test
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	. "private-sphinx-docs/services/staticfiles"
+)
+
+func TestFileSys_Walk(t *testing.T) {
+	t.Parallel()
+
+	files := map[string]string{
+		"_build/html/index.html":     "index",
+		"_build/html/_static/app.js": "app",
+		"_build/html/api/api.html":   "api",
+	}
+	expected := map[string]string{"index.html": "index", "_static/app.js": "app", "api/api.html": "api"}
+
+	for _, s := range []struct {
+		Name   string
+		Option FileSysOption
+		Tar    bool
+	}{
+		{"tree", FileSysOption{}, false},
+		{"archives", FileSysOption{ServeArchives: true}, false},
+		{"deduplicate", FileSysOption{Deduplicate: true}, false},
+		{"tar", FileSysOption{}, true},
+	} {
+		s := s
+		t.Run(s.Name, func(t *testing.T) {
+			assert := require.New(t)
+
+			root, err := ioutil.TempDir("", "psd-")
+			assert.NoError(err)
+			defer func() { _ = os.RemoveAll(root) }()
+
+			s.Option.Root = root
+			fs, err := NewFileSys(&s.Option)
+			assert.NoError(err)
+
+			content := createZip(t, files)
+			if s.Tar {
+				content = createTar(t, "gzip", files, nil)
+			}
+			artifact, _, err := fs.SaveArtifact(bytes.NewReader(content), "project", int64(len(content)))
+			assert.NoError(err)
+			_, err = fs.Upload(bytes.NewReader(content), "project", int64(len(content)))
+			assert.NoError(err)
+
+			// the saved artifact and the live documentation have the same files
+			for _, a := range []string{"", artifact} {
+				actual := make(map[string]string)
+				err := fs.Walk("project", a, func(p string, size int64, modTime time.Time, r io.Reader) error {
+					b, err := ioutil.ReadAll(r)
+					assert.NoError(err)
+					assert.EqualValues(len(b), size)
+					actual[p] = string(b)
+					return nil
+				})
+				assert.NoError(err)
+				assert.Equal(expected, actual, a)
+			}
+
+			assert.Error(fs.Walk("missing", "", func(string, int64, time.Time, io.Reader) error { return nil }))
+			assert.Error(fs.Walk("project", "missing", func(string, int64, time.Time, io.Reader) error { return nil }))
+		})
+	}
+}
@@ -0,0 +1,391 @@
+package staticfiles
+
+import (
+	"archive/zip"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// A published zip archive served without being extracted. The entries are indexed when the
+// archive is opened.
+type zipArchive struct {
+	file  *os.File
+	files map[string]*zip.File
+	dirs  map[string][]os.FileInfo
+	// modification time of the archive, used for the directories
+	modTime time.Time
+	// files opened from the archive that are not closed yet, guarded by archiveCache.mu
+	refs    int
+	evicted bool
+}
+
+func openZipArchive(fp string) (*zipArchive, error) {
+	file, err := os.Open(fp)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+
+	reader, err := zip.NewReader(file, info.Size())
+	if err != nil {
+		_ = file.Close()
+		return nil, errors.Wrap(err, "could not read zip contents")
+	}
+
+	archive := &zipArchive{file: file, files: indexZip(reader), modTime: info.ModTime()}
+	archive.dirs = archive.listDirs()
+	return archive, nil
+}
+
+// Opens the file (or directory) at the slash separated path
+func (a *zipArchive) Open(name string) (http.File, error) {
+	p := cleanPath(name)
+	if entry, ok := a.files[p]; ok {
+		return a.openFile(entry, p)
+	}
+	if entries, ok := a.dirs[p]; ok {
+		return &objectDir{info: a.dirInfo(p), entries: entries}, nil
+	}
+	return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+}
+
+func (a *zipArchive) openFile(entry *zip.File, p string) (http.File, error) {
+	info := &objectInfo{name: path.Base(p), size: int64(entry.UncompressedSize64), modTime: entry.Modified}
+
+	// stored entries can be read (and seeked) straight from the archive
+	if entry.Method == zip.Store {
+		offset, err := entry.DataOffset()
+		if err != nil {
+			return nil, err
+		}
+		return &zipFile{ReadSeeker: io.NewSectionReader(a.file, offset, info.size), info: info}, nil
+	}
+	return &zipFile{ReadSeeker: &zipEntryReader{entry: entry, size: info.size}, info: info}, nil
+}
+
+func (a *zipArchive) dirInfo(p string) os.FileInfo {
+	name := path.Base(p)
+	if p == "" {
+		name = "/"
+	}
+	return &objectInfo{name: name, modTime: a.modTime, dir: true}
+}
+
+// Derives the directories (and their sorted entries) from the file paths
+func (a *zipArchive) listDirs() map[string][]os.FileInfo {
+	files := make(map[string]os.FileInfo, len(a.files))
+	for p, entry := range a.files {
+		files[p] = &objectInfo{name: path.Base(p), size: int64(entry.UncompressedSize64), modTime: entry.Modified}
+	}
+	return indexDirs(files, a.dirInfo)
+}
+
+// Derives the directories (and their sorted entries) from the files keyed by their slash
+// separated path. dirInfo describes the directory at a path, the root is ""
+func indexDirs(files map[string]os.FileInfo, dirInfo func(p string) os.FileInfo) map[string][]os.FileInfo {
+	children := map[string]map[string]os.FileInfo{"": {}}
+	for p, info := range files {
+		for {
+			dir := path.Dir(p)
+			if dir == "." {
+				dir = ""
+			}
+
+			if children[dir] == nil {
+				children[dir] = make(map[string]os.FileInfo)
+			}
+			children[dir][info.Name()] = info
+
+			if dir == "" {
+				break
+			}
+			p, info = dir, dirInfo(dir)
+		}
+	}
+
+	dirs := make(map[string][]os.FileInfo, len(children))
+	for dir, entries := range children {
+		infos := make([]os.FileInfo, 0, len(entries))
+		for _, info := range entries {
+			infos = append(infos, info)
+		}
+		sortInfos(infos)
+		dirs[dir] = infos
+	}
+	return dirs
+}
+
+// Maps the clean path of every file to its entry. Like formatContentDirectory, folders that
+// wrap all the content are stripped
+func indexZip(reader *zip.Reader) map[string]*zip.File {
+	files := make(map[string]*zip.File)
+	for _, entry := range reader.File {
+		if entry.FileInfo().IsDir() {
+			continue
+		}
+		files[cleanPath(strings.Replace(entry.Name, `\`, "/", -1))] = entry
+	}
+
+	for {
+		prefix := ""
+		for p := range files {
+			i := strings.Index(p, "/")
+			if i < 0 || (prefix != "" && p[:i+1] != prefix) {
+				return files
+			}
+			prefix = p[:i+1]
+		}
+		if prefix == "" {
+			return files
+		}
+
+		stripped := make(map[string]*zip.File, len(files))
+		for p, entry := range files {
+			stripped[strings.TrimPrefix(p, prefix)] = entry
+		}
+		files = stripped
+	}
+}
+
+// Checks that the zip archive can be served. The same limits as for extraction are applied
+// on the sizes declared by the archive. The zip reader fails if an entry is larger than
+// declared.
+func validateZip(r io.ReaderAt, size int64, limits Limits) error {
+	reader, err := zip.NewReader(r, size)
+	if err != nil {
+		return errors.Wrap(err, "could not read zip contents")
+	}
+
+	// nothing is written, the destination is only used to check the paths
+	ex := newExtractor(string(filepath.Separator)+"archive", limits)
+	files := 0
+	for _, entry := range reader.File {
+		if err := ex.check(entry.Name, entry.Mode()); err != nil {
+			return err
+		}
+		if _, err := ex.path(entry.Name); err != nil {
+			return err
+		}
+		if entry.FileInfo().IsDir() {
+			continue
+		}
+
+		size := int64(entry.UncompressedSize64)
+		if err := ex.declare(entry.Name, size, int64(entry.CompressedSize64)); err != nil {
+			return err
+		}
+		ex.total += size
+		files++
+	}
+
+	if files == 0 {
+		return errors.New("uploaded archive does not contain any files")
+	}
+	return nil
+}
+
+// Computes the sha256 checksum of every file in the archive keyed by its path
+func zipManifest(fp string) (map[string]string, error) {
+	archive, err := openZipArchive(fp)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = archive.file.Close() }()
+
+	manifest := make(map[string]string, len(archive.files))
+	for p, entry := range archive.files {
+		rc, err := entry.Open()
+		if err != nil {
+			return nil, err
+		}
+		checksum, err := readerChecksum(rc)
+		_ = rc.Close()
+		if err != nil {
+			return nil, errors.Wrapf(err, "could not read '%s'", p)
+		}
+		manifest[p] = checksum
+	}
+	return manifest, nil
+}
+
+type zipFile struct {
+	io.ReadSeeker
+	info os.FileInfo
+}
+
+func (f *zipFile) Close() error {
+	if c, ok := f.ReadSeeker.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+func (f *zipFile) Readdir(int) ([]os.FileInfo, error) {
+	return nil, errors.New("not a directory")
+}
+
+func (f *zipFile) Stat() (os.FileInfo, error) {
+	return f.info, nil
+}
+
+// Reads a compressed entry. Compressed data cannot be seeked, the entry is decompressed
+// again from the start when seeking backwards. Seeking to the end (to get the size) is free.
+type zipEntryReader struct {
+	entry *zip.File
+	size  int64
+	rc    io.ReadCloser
+	// position of rc in the entry
+	pos int64
+	// position requested by the caller
+	offset int64
+}
+
+func (z *zipEntryReader) Read(p []byte) (int, error) {
+	if z.offset >= z.size {
+		return 0, io.EOF
+	}
+
+	if z.rc == nil || z.pos > z.offset {
+		if z.rc != nil {
+			_ = z.rc.Close()
+		}
+		rc, err := z.entry.Open()
+		if err != nil {
+			return 0, err
+		}
+		z.rc, z.pos = rc, 0
+	}
+	if z.pos < z.offset {
+		n, err := io.CopyN(ioutil.Discard, z.rc, z.offset-z.pos)
+		z.pos += n
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := z.rc.Read(p)
+	z.pos += int64(n)
+	z.offset = z.pos
+	return n, err
+}
+
+func (z *zipEntryReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += z.offset
+	case io.SeekEnd:
+		offset += z.size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+
+	z.offset = offset
+	return offset, nil
+}
+
+func (z *zipEntryReader) Close() error {
+	if z.rc != nil {
+		return z.rc.Close()
+	}
+	return nil
+}
+
+// Keeps the index of every served archive in memory. Archives are keyed by their release
+// path, which never changes once published
+type archiveCache struct {
+	mu       sync.Mutex
+	archives map[string]*zipArchive
+}
+
+// Opens the file (or directory) at path of the archive at fp. The archive stays open until
+// the file is closed, even if it is evicted in the meantime
+func (c *archiveCache) open(fp, path string) (http.File, error) {
+	archive, err := c.acquire(fp)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := archive.Open(path)
+	if err != nil {
+		c.release(archive)
+		return nil, err
+	}
+	return &archiveFile{File: file, release: func() { c.release(archive) }}, nil
+}
+
+func (c *archiveCache) acquire(fp string) (*zipArchive, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	archive, ok := c.archives[fp]
+	if !ok {
+		var err error
+		if archive, err = openZipArchive(fp); err != nil {
+			return nil, err
+		}
+		if c.archives == nil {
+			c.archives = make(map[string]*zipArchive)
+		}
+		c.archives[fp] = archive
+	}
+	archive.refs++
+	return archive, nil
+}
+
+func (c *archiveCache) release(archive *zipArchive) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	archive.refs--
+	if archive.evicted && archive.refs == 0 {
+		_ = archive.file.Close()
+	}
+}
+
+// Drops the archive at fp (or any archive under the folder fp). Archives are closed once
+// the last file read from them is closed
+func (c *archiveCache) evict(fp string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key, archive := range c.archives {
+		if key == fp || strings.HasPrefix(key, fp+string(filepath.Separator)) {
+			delete(c.archives, key)
+			archive.evicted = true
+			if archive.refs == 0 {
+				_ = archive.file.Close()
+			}
+		}
+	}
+}
+
+// A file of a cached archive, releases the archive when it is closed
+type archiveFile struct {
+	http.File
+	once    sync.Once
+	release func()
+}
+
+func (f *archiveFile) Close() error {
+	err := f.File.Close()
+	f.once.Do(f.release)
+	return err
+}
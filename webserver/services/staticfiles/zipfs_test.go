@@ -0,0 +1,180 @@
+package staticfiles_test
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	. "private-sphinx-docs/services/staticfiles"
+)
+
+func TestFileSys_ServeArchives(t *testing.T) {
+	t.Parallel()
+	assert := require.New(t)
+
+	root, err := ioutil.TempDir("", "psd-")
+	assert.NoError(err)
+	defer func() { _ = os.RemoveAll(root) }()
+
+	fs, err := NewFileSys(&FileSysOption{Root: root, ServeArchives: true})
+	assert.NoError(err)
+
+	large := strings.Repeat("0123456789", 1000)
+	content := createZipWithEntries(t, []zipEntry{
+		{Name: "html/index.html", Content: "v1"},
+		{Name: "html/_static/app.js", Content: large, Deflate: true},
+	})
+	_, err = fs.Upload(bytes.NewReader(content), "project", int64(len(content)))
+	assert.NoError(err)
+	artifact, _, err := fs.SaveArtifact(bytes.NewReader(content), "project", int64(len(content)))
+	assert.NoError(err)
+
+	// the archive is published as it is
+	info, err := os.Stat(fs.Destination("project"))
+	assert.NoError(err)
+	assert.True(info.Mode().IsRegular())
+
+	infos, err := fs.List("project", "/")
+	assert.NoError(err)
+	assert.Len(infos, 2)
+	assert.Equal("_static", infos[0].Name())
+	assert.True(infos[0].IsDir())
+	assert.Equal("index.html", infos[1].Name())
+
+	server := httptest.NewServer(http.FileServer(projectFS{fs, "project"}))
+	defer server.Close()
+
+	for _, s := range []struct {
+		Path       string
+		Range      string
+		StatusCode int
+		Body       string
+	}{
+		{"/", "", http.StatusOK, "v1"},
+		{"/index.html", "", http.StatusMovedPermanently, ""},
+		{"/_static/app.js", "", http.StatusOK, large},
+		{"/_static/app.js", "bytes=9995-", http.StatusPartialContent, large[9995:]},
+		{"/_static/app.js", "bytes=10-19", http.StatusPartialContent, large[10:20]},
+		{"/missing.html", "", http.StatusNotFound, ""},
+	} {
+		req, err := http.NewRequest("GET", server.URL+s.Path, nil)
+		assert.NoError(err)
+		if s.Range != "" {
+			req.Header.Set("Range", s.Range)
+		}
+
+		client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		}}
+		resp, err := client.Do(req)
+		assert.NoError(err)
+		body, err := ioutil.ReadAll(resp.Body)
+		assert.NoError(err)
+		assert.NoError(resp.Body.Close())
+
+		assert.Equal(s.StatusCode, resp.StatusCode, s.Path)
+		if s.Body != "" {
+			assert.Equal(s.Body, string(body))
+		}
+	}
+
+	// tar archives are repacked
+	tarball := createTar(t, "gzip", map[string]string{"index.html": "v2"}, nil)
+	_, err = fs.Upload(bytes.NewReader(tarball), "project", int64(len(tarball)))
+	assert.NoError(err)
+	assertArchiveFile(t, fs, "index.html", "v2")
+
+	// incremental uploads work on archives
+	changes := createZip(t, map[string]string{"new.html": "new"})
+	_, _, _, stats, err := fs.Sync("project", bytes.NewReader(changes), int64(len(changes)), nil)
+	assert.NoError(err)
+	assert.Equal(&Stats{Files: 2, Size: 5, HasIndex: true}, stats)
+	manifest, err := fs.Manifest("project")
+	assert.NoError(err)
+	assert.Equal(map[string]string{"index.html": checksum("v2"), "new.html": checksum("new")}, manifest)
+
+	// rolling back only swaps the link to the saved artifact
+	assert.NoError(fs.Restore("project", artifact))
+	assertArchiveFile(t, fs, "index.html", "v1")
+
+	release, err := os.Stat(fs.Destination("project"))
+	assert.NoError(err)
+	saved, err := os.Stat(filepath.Join(root, ".revisions", "project", artifact))
+	assert.NoError(err)
+	assert.True(os.SameFile(release, saved))
+
+	releases, err := ioutil.ReadDir(filepath.Join(root, ".releases", "project"))
+	assert.NoError(err)
+	assert.Len(releases, 1)
+
+	// unsafe archives are rejected without being published
+	unsafe := createZip(t, map[string]string{"../evil.html": "evil"})
+	_, err = fs.Upload(bytes.NewReader(unsafe), "project", int64(len(unsafe)))
+	assert.Error(err)
+	assertArchiveFile(t, fs, "index.html", "v1")
+}
+
+func assertArchiveFile(t *testing.T, fs *FileSys, path, expected string) {
+	file, err := fs.Open("project", path)
+	require.NoError(t, err)
+	defer func() { _ = file.Close() }()
+
+	actual, err := ioutil.ReadAll(io.Reader(file))
+	require.NoError(t, err)
+	require.Equal(t, expected, string(actual))
+}
+
+type projectFS struct {
+	fs   *FileSys
+	name string
+}
+
+func (p projectFS) Open(name string) (http.File, error) {
+	return p.fs.Open(p.name, name)
+}
+
+func TestFileSys_ServeArchivesWhileRepublishing(t *testing.T) {
+	t.Parallel()
+	assert := require.New(t)
+
+	root, err := ioutil.TempDir("", "psd-")
+	assert.NoError(err)
+	defer func() { _ = os.RemoveAll(root) }()
+
+	fs, err := NewFileSys(&FileSysOption{Root: root, ServeArchives: true})
+	assert.NoError(err)
+
+	large := strings.Repeat("0123456789", 1000)
+	for _, deflate := range []bool{false, true} {
+		content := createZipWithEntries(t, []zipEntry{{Name: "large.txt", Content: large, Deflate: deflate}})
+		_, err := fs.Upload(bytes.NewReader(content), "project", int64(len(content)))
+		assert.NoError(err)
+
+		// a download started before the project is published again runs to completion
+		file, err := fs.Open("project", "large.txt")
+		assert.NoError(err)
+		head := make([]byte, 10)
+		_, err = io.ReadFull(file, head)
+		assert.NoError(err)
+
+		next := createZip(t, map[string]string{"index.html": "next"})
+		_, err = fs.Upload(bytes.NewReader(next), "project", int64(len(next)))
+		assert.NoError(err)
+
+		rest, err := ioutil.ReadAll(file)
+		assert.NoError(err)
+		assert.Equal(large, string(head)+string(rest))
+		assert.NoError(file.Close())
+		assert.NoError(file.Close())
+
+		assertArchiveFile(t, fs, "index.html", "next")
+	}
+}
@@ -0,0 +1,272 @@
+package uploads
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"private-sphinx-docs/libs"
+)
+
+var (
+	ErrNotFound       = errors.New("upload does not exist or has expired")
+	ErrOffsetMismatch = errors.New("upload offset does not match the current offset")
+)
+
+// A partial (resumable) upload. The content is assembled chunk by chunk until the offset
+// reaches the length
+type Upload struct {
+	Id        string            `json:"id"`
+	AccountId int               `json:"accountId"`
+	Length    int64             `json:"length"`
+	Offset    int64             `json:"offset"`
+	Metadata  map[string]string `json:"metadata"`
+	Expires   time.Time         `json:"expires"`
+}
+
+func (u *Upload) Complete() bool {
+	return u.Offset == u.Length
+}
+
+// Stores the chunks of resumable uploads in a staging folder. Each upload consists of a data
+// file and an info file. Uploads that have not received any chunk before they expire are
+// garbage collected.
+type Store struct {
+	folder string
+	expiry time.Duration
+	locks  sync.Map
+}
+
+func New(folder string, expiry time.Duration) (*Store, error) {
+	if !libs.PathExists(folder) {
+		if err := os.MkdirAll(folder, 0744); err != nil {
+			return nil, errors.Wrapf(err, "could not create upload folder at '%s'", folder)
+		}
+	}
+	if expiry <= 0 {
+		expiry = 24 * time.Hour
+	}
+
+	return &Store{folder: folder, expiry: expiry}, nil
+}
+
+func (s *Store) Create(accountId int, length int64, metadata map[string]string) (*Upload, error) {
+	if length < 0 {
+		return nil, errors.New("upload length must not be negative")
+	}
+
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, errors.Wrap(err, "could not generate upload id")
+	}
+
+	upload := &Upload{
+		Id:        hex.EncodeToString(id),
+		AccountId: accountId,
+		Length:    length,
+		Metadata:  metadata,
+		Expires:   time.Now().Add(s.expiry),
+	}
+
+	f, err := os.Create(s.dataPath(upload.Id))
+	if err != nil {
+		return nil, errors.Wrap(err, "could not create upload file")
+	}
+	_ = f.Close()
+
+	if err := s.save(upload); err != nil {
+		return nil, err
+	}
+	return upload, nil
+}
+
+func (s *Store) Fetch(id string) (*Upload, error) {
+	if !validId(id) {
+		return nil, ErrNotFound
+	}
+
+	content, err := ioutil.ReadFile(s.infoPath(id))
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, errors.Wrap(err, "could not read upload info")
+	}
+
+	upload := &Upload{}
+	if err := json.Unmarshal(content, upload); err != nil {
+		return nil, errors.Wrap(err, "could not read upload info")
+	}
+	if time.Now().After(upload.Expires) {
+		return nil, ErrNotFound
+	}
+	return upload, nil
+}
+
+// Appends the chunk in r to the upload. offset must be the current offset of the upload.
+// Bytes beyond the upload length are not read. If reading r fails midway, the bytes read
+// so far are kept so that the client can resume from the new offset.
+func (s *Store) Append(id string, offset int64, r io.Reader) (*Upload, error) {
+	unlock := s.lock(id)
+	defer unlock()
+
+	upload, err := s.Fetch(id)
+	if err != nil {
+		return nil, err
+	} else if upload.Offset != offset {
+		return upload, ErrOffsetMismatch
+	}
+
+	f, err := os.OpenFile(s.dataPath(id), os.O_WRONLY|os.O_APPEND, 0644)
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, errors.Wrap(err, "could not open upload file")
+	}
+	defer func() { _ = f.Close() }()
+
+	n, copyErr := io.Copy(f, io.LimitReader(r, upload.Length-upload.Offset))
+	upload.Offset += n
+	upload.Expires = time.Now().Add(s.expiry)
+
+	if err := s.save(upload); err != nil {
+		return nil, err
+	}
+	if copyErr != nil {
+		return upload, errors.Wrap(copyErr, "could not write chunk")
+	}
+	return upload, nil
+}
+
+// Opens the assembled content of the upload
+func (s *Store) Open(id string) (*os.File, error) {
+	if _, err := s.Fetch(id); err != nil {
+		return nil, err
+	}
+	return os.Open(s.dataPath(id))
+}
+
+func (s *Store) Remove(id string) error {
+	if !validId(id) {
+		return ErrNotFound
+	}
+
+	unlock := s.lock(id)
+	defer unlock()
+	defer s.locks.Delete(id)
+
+	for _, path := range []string{s.infoPath(id), s.dataPath(id)} {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return errors.Wrap(err, "could not remove upload")
+		}
+	}
+	return nil
+}
+
+// Removes all expired uploads. Returns the number of uploads removed
+func (s *Store) Collect() (int, error) {
+	files, err := ioutil.ReadDir(s.folder)
+	if err != nil {
+		return 0, errors.Wrap(err, "could not list uploads")
+	}
+
+	n := 0
+	for _, file := range files {
+		ext := filepath.Ext(file.Name())
+		id := strings.TrimSuffix(file.Name(), ext)
+		if !validId(id) {
+			continue
+		}
+
+		switch ext {
+		case ".info":
+			if _, err := s.Fetch(id); err != ErrNotFound {
+				continue
+			}
+		case ".bin":
+			// data files are removed with their info file. Orphans are only removed once they
+			// are old enough not to belong to an upload being created
+			if libs.PathExists(s.infoPath(id)) || time.Since(file.ModTime()) < s.expiry {
+				continue
+			}
+		default:
+			continue
+		}
+
+		if err := s.Remove(id); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// Periodically removes expired uploads until the returned function is called
+func (s *Store) StartCollector(interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if n, err := s.Collect(); err != nil {
+					log.Errorf("could not collect expired uploads: %v", err)
+				} else if n > 0 {
+					log.Infof("removed %d expired uploads", n)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() { close(done) }
+}
+
+func (s *Store) save(upload *Upload) error {
+	content, err := json.Marshal(upload)
+	if err != nil {
+		return errors.Wrap(err, "could not save upload info")
+	}
+
+	// write to a temp file first so the info file is never partially written
+	tmp := s.infoPath(upload.Id) + ".tmp"
+	if err := ioutil.WriteFile(tmp, content, 0644); err != nil {
+		return errors.Wrap(err, "could not save upload info")
+	}
+	return os.Rename(tmp, s.infoPath(upload.Id))
+}
+
+func (s *Store) lock(id string) func() {
+	mu, _ := s.locks.LoadOrStore(id, &sync.Mutex{})
+	mu.(*sync.Mutex).Lock()
+	return mu.(*sync.Mutex).Unlock
+}
+
+func (s *Store) infoPath(id string) string {
+	return filepath.Join(s.folder, id+".info")
+}
+
+func (s *Store) dataPath(id string) string {
+	return filepath.Join(s.folder, id+".bin")
+}
+
+// Ids are generated by the store, anything else could be used to escape the folder
+func validId(id string) bool {
+	if len(id) != 32 {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil
+}
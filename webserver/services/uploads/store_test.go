@@ -0,0 +1,85 @@
+package uploads_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	. "private-sphinx-docs/services/uploads"
+)
+
+func TestStore_Append(t *testing.T) {
+	t.Parallel()
+	assert := require.New(t)
+
+	folder, err := ioutil.TempDir("", "uploads-")
+	assert.NoError(err)
+	defer func() { _ = os.RemoveAll(folder) }()
+
+	store, err := New(folder, time.Hour)
+	assert.NoError(err)
+
+	upload, err := store.Create(1, 6, map[string]string{"title": "project"})
+	assert.NoError(err)
+
+	for _, s := range []struct {
+		Offset   int64
+		Chunk    string
+		Expected int64
+		Err      error
+	}{
+		{0, "abc", 3, nil},
+		{0, "abc", 3, ErrOffsetMismatch},
+		{3, "defghi", 6, nil}, // bytes beyond the length are ignored
+	} {
+		upload, err = store.Append(upload.Id, s.Offset, bytes.NewBufferString(s.Chunk))
+		assert.Equal(s.Err, err)
+		assert.Equal(s.Expected, upload.Offset)
+	}
+	assert.True(upload.Complete())
+
+	f, err := store.Open(upload.Id)
+	assert.NoError(err)
+	content, err := ioutil.ReadAll(f)
+	assert.NoError(err)
+	assert.NoError(f.Close())
+	assert.Equal("abcdef", string(content))
+
+	_, err = store.Fetch("../../etc/passwd")
+	assert.Equal(ErrNotFound, err)
+}
+
+func TestStore_Collect(t *testing.T) {
+	t.Parallel()
+	assert := require.New(t)
+
+	folder, err := ioutil.TempDir("", "uploads-")
+	assert.NoError(err)
+	defer func() { _ = os.RemoveAll(folder) }()
+
+	expired, err := New(folder, time.Nanosecond)
+	assert.NoError(err)
+	_, err = expired.Create(1, 10, nil)
+	assert.NoError(err)
+
+	store, err := New(folder, time.Hour)
+	assert.NoError(err)
+	upload, err := store.Create(1, 10, nil)
+	assert.NoError(err)
+
+	time.Sleep(time.Millisecond)
+	n, err := store.Collect()
+	assert.NoError(err)
+	assert.Equal(1, n)
+
+	_, err = store.Fetch(upload.Id)
+	assert.NoError(err)
+
+	files, err := ioutil.ReadDir(folder)
+	assert.NoError(err)
+	assert.Len(files, 2)
+}